@@ -0,0 +1,538 @@
+package main
+
+/*
+    Audio registers, two pulse channels, a triangle and a noise channel
+    close to the NES, mixed in 16-bit PCM samples at a chosen rate
+
+    $00 P1ENV    duty (bits 6-7), halt length and loop envelope (bit 5),
+                 constant volume (bit 4), volume or envelope period (bits 0-3)
+    $01 P1SWEEP  enable (bit 7), period (4-6), negate (bit 3), shift (0-2)
+    $02 P1LO     low byte of the period of the timer
+    $03 P1HI     length index (bits 3-7), high bits of the period (0-2),
+                 writing it restarts the envelope and the duty cycle
+    $04-$07      same for the second pulse channel
+    $08 TRILIN   halt length and linear counter (bit 7), linear reload (0-6)
+    $0A TRILO    $0B TRIHI  as P1LO and P1HI
+    $0C NOIENV   as P1ENV without the duty
+    $0E NOIMODE  short sequence (bit 7), period index (bits 0-3)
+    $0F NOILEN   length index (bits 3-7), restarts the envelope
+    $10 ENABLE   bits 0-3 enable pulse 1, pulse 2, triangle, noise,
+                 reads whether their length counters are running
+    $11 FRAME    5 steps sequence (bit 7) instead of 4, resets it
+
+    timers count cycles of the processor (every other cycle for the
+    pulses), the frame sequencer clocks envelopes and linear counter
+    at 240 Hz and lengths and sweeps at 120 Hz, no interrupt is raised
+    the samples are given to an AudioOutput, such as a WAV recording or
+    the speakers when built with the tag audio (see audio_pipe.go)
+*/
+
+import (
+    "io"
+    "bytes"
+    "io/ioutil"
+    "encoding/binary"
+)
+
+
+const (
+    apuSize      = 0x20 // number of registers
+    defaultRate  = 44100
+    quarterFrame = cpuClock / 240 // cycles between two steps of the sequencer
+)
+
+// registers of the APU
+const (
+    regP1Env   = 0x00
+    regP2Env   = 0x04
+    regTriLin  = 0x08
+    regNoiEnv  = 0x0C
+    regEnable  = 0x10
+    regFrame   = 0x11
+)
+
+
+var apuLengths = [32]uint8 {
+    10, 254, 20,  2, 40,  4, 80,  6, 160,  8, 60, 10, 14, 12, 26, 14,
+    12,  16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+var apuDuties = [4][8]uint8 {
+    {0, 1, 0, 0, 0, 0, 0, 0},
+    {0, 1, 1, 0, 0, 0, 0, 0},
+    {0, 1, 1, 1, 1, 0, 0, 0},
+    {1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+var apuTriangle = [32]uint8 {
+    15, 14, 13, 12, 11, 10,  9,  8,  7,  6,  5,  4,  3,  2,  1,  0,
+     0,  1,  2,  3,  4,  5,  6,  7,  8,  9, 10, 11, 12, 13, 14, 15,
+}
+
+var apuNoisePeriods = [16]uint16 {
+    4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+
+// volume decreasing from 15 at every quarter frame
+type envelope struct {
+    loop     bool
+    constant bool
+    volume   uint8 // constant volume or period of the decay
+    start    bool
+    divider  uint8
+    decay    uint8
+}
+
+func (env *envelope) set (value uint8) {
+    env.loop     = value & 0x20 != 0
+    env.constant = value & 0x10 != 0
+    env.volume   = value & 0x0F
+}
+
+func (env *envelope) clock () {
+    if env.start {
+        env.start, env.decay, env.divider = false, 15, env.volume
+    } else if env.divider == 0 {
+        env.divider = env.volume
+        if env.decay > 0 {
+            env.decay -= 1
+        } else if env.loop {
+            env.decay = 15
+        }
+    } else {
+        env.divider -= 1
+    }
+}
+
+func (env *envelope) output () uint8 {
+    if env.constant {return env.volume}
+    return env.decay
+}
+
+
+// square wave with a sweep of its period
+type pulse struct {
+    second  bool // the negated sweep of pulse 2 does not subtract 1 more
+    enabled bool
+    duty    uint8
+    env     envelope
+    sweep   uint8 // SWEEP register
+    divider uint8
+    reload  bool
+    period  uint16
+    timer   uint16
+    step    uint8
+    length  uint8
+}
+
+func (p *pulse) write (reg uint, value uint8) {
+    switch reg {
+    case 0:
+        p.duty = value >> 6
+        p.env.set(value)
+    case 1:
+        p.sweep, p.reload = value, true
+    case 2:
+        p.period = p.period & 0x700 | uint16(value)
+    case 3:
+        p.period = p.period & 0xFF | uint16(value & 0x7) << 8
+        if p.enabled {p.length = apuLengths[value >> 3]}
+        p.step, p.env.start = 0, true
+    }
+}
+
+// period reached by the sweep
+func (p *pulse) target () uint16 {
+    change := p.period >> (p.sweep & 0x7)
+    if p.sweep & 0x8 == 0 {return p.period + change}
+    if !p.second {change += 1}
+    if change > p.period {return 0}
+    return p.period - change
+}
+
+func (p *pulse) muted () bool {
+    return p.period < 8 || p.target() > 0x7FF
+}
+
+func (p *pulse) clockTimer () {
+    if p.timer == 0 {
+        p.timer = p.period
+        p.step  = (p.step + 1) % 8
+    } else {
+        p.timer -= 1
+    }
+}
+
+func (p *pulse) clockHalf () {
+    if !p.env.loop && p.length > 0 {p.length -= 1}
+    if p.divider == 0 && p.sweep & 0x80 != 0 && p.sweep & 0x7 != 0 && !p.muted() {
+        p.period = p.target()
+    }
+    if p.divider == 0 || p.reload {
+        p.divider, p.reload = p.sweep >> 4 & 0x7, false
+    } else {
+        p.divider -= 1
+    }
+}
+
+func (p *pulse) output () uint8 {
+    if p.length == 0 || p.muted() || apuDuties[p.duty][p.step] == 0 {return 0}
+    return p.env.output()
+}
+
+
+// triangle wave cut by a linear counter
+type triangle struct {
+    enabled bool
+    control bool // halt the length, keep reloading the linear counter
+    reload  uint8
+    linear  uint8
+    reset   bool
+    period  uint16
+    timer   uint16
+    step    uint8
+    length  uint8
+}
+
+func (t *triangle) write (reg uint, value uint8) {
+    switch reg {
+    case 0:
+        t.control, t.reload = value & 0x80 != 0, value & 0x7F
+    case 2:
+        t.period = t.period & 0x700 | uint16(value)
+    case 3:
+        t.period = t.period & 0xFF | uint16(value & 0x7) << 8
+        if t.enabled {t.length = apuLengths[value >> 3]}
+        t.reset = true
+    }
+}
+
+func (t *triangle) clockTimer () {
+    if t.timer == 0 {
+        t.timer = t.period
+        if t.length > 0 && t.linear > 0 {t.step = (t.step + 1) % 32}
+    } else {
+        t.timer -= 1
+    }
+}
+
+func (t *triangle) clockQuarter () {
+    if t.reset {
+        t.linear = t.reload
+    } else if t.linear > 0 {
+        t.linear -= 1
+    }
+    if !t.control {t.reset = false}
+}
+
+func (t *triangle) clockHalf () {
+    if !t.control && t.length > 0 {t.length -= 1}
+}
+
+func (t *triangle) output () uint8 {
+    return apuTriangle[t.step]
+}
+
+
+// pseudo random bits of a shift register
+type noise struct {
+    enabled bool
+    env     envelope
+    short   bool
+    period  uint16
+    timer   uint16
+    shift   uint16
+    length  uint8
+}
+
+func (n *noise) write (reg uint, value uint8) {
+    switch reg {
+    case 0:
+        n.env.set(value)
+    case 2:
+        n.short, n.period = value & 0x80 != 0, apuNoisePeriods[value & 0xF]
+    case 3:
+        if n.enabled {n.length = apuLengths[value >> 3]}
+        n.env.start = true
+    }
+}
+
+func (n *noise) clockTimer () {
+    if n.timer == 0 {
+        n.timer = n.period
+        tap := uint(1)
+        if n.short {tap = 6}
+        feedback := (n.shift ^ n.shift >> tap) & 0x1
+        n.shift = n.shift >> 1 | feedback << 14
+    } else {
+        n.timer -= 1
+    }
+}
+
+func (n *noise) clockHalf () {
+    if !n.env.loop && n.length > 0 {n.length -= 1}
+}
+
+func (n *noise) output () uint8 {
+    if n.length == 0 || n.shift & 0x1 != 0 {return 0}
+    return n.env.output()
+}
+
+
+// receives the samples of the console
+type AudioOutput interface {
+    Play (samples []int16)
+}
+
+
+// opens the speakers of the computer, nil unless built with the tag audio
+var AudioBackend func (rate int) (AudioOutput, error)
+
+
+type APU struct {
+    pulses   [2]pulse
+    tri      triangle
+    noi      noise
+    five     bool   // 5 steps sequence
+    sequence uint   // step of the frame sequencer
+    counter  uint   // cycles before the next step
+
+    Rate     int          // samples per second
+    clock    func () uint64 // cycles of the processor to catch up with
+    cycles   uint64       // cycles already generated
+    fraction int          // time to the next sample, in cycles × Rate
+    sums     [4]uint      // outputs of the channels since the last sample
+    count    uint
+    samples  []int16      // generated and not played yet
+}
+
+
+// create an APU producing samples at a rate
+func NewAPU (rate int) *APU {
+    apu := &APU{Rate: rate}
+    apu.Reset()
+    return apu
+}
+
+
+// silence every channel
+func (apu *APU) Reset () {
+    *apu = APU{Rate: apu.Rate, clock: apu.clock, cycles: apu.cycles}
+    apu.pulses[1].second = true
+    apu.noi.shift        = 1
+    apu.noi.period       = apuNoisePeriods[0]
+    apu.counter          = quarterFrame
+}
+
+
+// only the state of the channels can be read
+func (apu *APU) Read (addr uint) uint8 {
+    if addr != regEnable {return 0}
+    var status uint8
+    for i, length := range []uint8{apu.pulses[0].length, apu.pulses[1].length, apu.tri.length, apu.noi.length} {
+        if length > 0 {status |= 1 << uint(i)}
+    }
+    return status
+}
+
+
+// the channels change at the cycle the register is written
+func (apu *APU) Write (addr uint, value uint8) {
+    apu.catchUp()
+    switch {
+    case addr < regTriLin:
+        apu.pulses[addr / 4].write(addr % 4, value)
+    case addr < regNoiEnv:
+        apu.tri.write(addr % 4, value)
+    case addr < regEnable:
+        apu.noi.write(addr % 4, value)
+    case addr == regEnable:
+        apu.pulses[0].enabled = value & 0x1 != 0
+        apu.pulses[1].enabled = value & 0x2 != 0
+        apu.tri.enabled       = value & 0x4 != 0
+        apu.noi.enabled       = value & 0x8 != 0
+        if !apu.pulses[0].enabled {apu.pulses[0].length = 0}
+        if !apu.pulses[1].enabled {apu.pulses[1].length = 0}
+        if !apu.tri.enabled       {apu.tri.length       = 0}
+        if !apu.noi.enabled       {apu.noi.length       = 0}
+    case addr == regFrame:
+        apu.five, apu.sequence, apu.counter = value & 0x80 != 0, 0, quarterFrame
+        if apu.five {apu.clockQuarter(); apu.clockHalf()}
+    }
+}
+
+
+func (apu *APU) clockQuarter () {
+    apu.pulses[0].env.clock()
+    apu.pulses[1].env.clock()
+    apu.tri.clockQuarter()
+    apu.noi.env.clock()
+}
+
+func (apu *APU) clockHalf () {
+    apu.pulses[0].clockHalf()
+    apu.pulses[1].clockHalf()
+    apu.tri.clockHalf()
+    apu.noi.clockHalf()
+}
+
+
+// advance the frame sequencer by a step
+func (apu *APU) step () {
+    steps, half := uint(4), apu.sequence % 2 == 1
+    if apu.five {steps, half = 5, apu.sequence == 1 || apu.sequence == 4}
+    if !apu.five || apu.sequence != 3 {apu.clockQuarter()}
+    if half {apu.clockHalf()}
+    apu.sequence = (apu.sequence + 1) % steps
+}
+
+
+// non linear mix of the channels, as the resistors of the NES
+func apuMix (p1, p2, t, n float64) float64 {
+    var out float64
+    if p1 + p2 > 0 {out += 95.88 / (8128 / (p1 + p2) + 100)}
+    if t + n > 0 {out += 159.79 / (1 / (t / 8227 + n / 12241) + 100)}
+    return out
+}
+
+
+// generate the samples of a number of cycles
+func (apu *APU) Run (cycles uint64) {
+    if apu.Rate <= 0 {apu.Rate = defaultRate}
+    for ; cycles > 0; cycles -= 1 {
+        if apu.cycles % 2 == 0 {
+            apu.pulses[0].clockTimer()
+            apu.pulses[1].clockTimer()
+        }
+        apu.tri.clockTimer()
+        apu.noi.clockTimer()
+        if apu.counter -= 1; apu.counter == 0 {
+            apu.counter = quarterFrame
+            apu.step()
+        }
+        apu.cycles += 1
+
+        // average the channels between two samples
+        apu.sums[0] += uint(apu.pulses[0].output())
+        apu.sums[1] += uint(apu.pulses[1].output())
+        apu.sums[2] += uint(apu.tri.output())
+        apu.sums[3] += uint(apu.noi.output())
+        apu.count   += 1
+        if apu.fraction += apu.Rate; apu.fraction >= cpuClock {
+            apu.fraction -= cpuClock
+            var avg [4]float64
+            for i := range avg {avg[i] = float64(apu.sums[i]) / float64(apu.count)}
+            apu.samples = append(apu.samples, int16(apuMix(avg[0], avg[1], avg[2], avg[3]) * 32767))
+            apu.sums, apu.count = [4]uint{}, 0
+        }
+    }
+}
+
+
+// generate the samples up to the current cycle of the processor
+func (apu *APU) catchUp () {
+    if apu.clock == nil {return}
+    if now := apu.clock(); now > apu.cycles {apu.Run(now - apu.cycles)}
+}
+
+
+// give the samples generated so far to an output, if any
+func (apu *APU) Flush (out AudioOutput) {
+    apu.catchUp()
+    if out != nil && len(apu.samples) != 0 {out.Play(apu.samples)}
+    apu.samples = apu.samples[:0]
+}
+
+
+// keeps every sample played, to be saved as WAV
+type AudioRecorder struct {
+    Rate    int
+    Samples []int16
+}
+
+func (rec *AudioRecorder) Play (samples []int16) {
+    rec.Samples = append(rec.Samples, samples...)
+}
+
+
+// write samples as a mono 16-bit PCM WAV file
+func WriteWAV (w io.Writer, rate int, samples []int16) error {
+    var buf bytes.Buffer
+    le   := func (v interface{}) {binary.Write(&buf, binary.LittleEndian, v)}
+    size := uint32(len(samples) * 2)
+    buf.WriteString("RIFF"); le(36 + size); buf.WriteString("WAVE")
+    buf.WriteString("fmt "); le(uint32(16))
+    le(uint16(1)); le(uint16(1))             // PCM, mono
+    le(uint32(rate)); le(uint32(rate * 2))   // samples and bytes per second
+    le(uint16(2)); le(uint16(16))            // bytes per sample, bits per sample
+    buf.WriteString("data"); le(size)
+    le(samples)
+    _, err := w.Write(buf.Bytes())
+    return err
+}
+
+
+// write the recorded samples in a WAV file
+func (rec *AudioRecorder) Save (path string) error {
+    var buf bytes.Buffer
+    if err := WriteWAV(&buf, rec.Rate, rec.Samples); err != nil {return err}
+    return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+
+func (env *envelope) save (w *stateWriter) {
+    w.bool(env.loop); w.bool(env.constant); w.u8(env.volume)
+    w.bool(env.start); w.u8(env.divider); w.u8(env.decay)
+}
+
+func (env *envelope) load (r *stateReader) {
+    env.loop, env.constant, env.volume = r.bool(), r.bool(), r.u8()
+    env.start, env.divider, env.decay  = r.bool(), r.u8(), r.u8()
+}
+
+
+// write the state of the channels in a snapshot
+func (apu *APU) save (w *stateWriter) {
+    for i := range apu.pulses {
+        p := &apu.pulses[i]
+        w.bool(p.enabled); w.u8(p.duty); p.env.save(w)
+        w.u8(p.sweep); w.u8(p.divider); w.bool(p.reload)
+        w.u16(uint(p.period)); w.u16(uint(p.timer)); w.u8(p.step); w.u8(p.length)
+    }
+    t := &apu.tri
+    w.bool(t.enabled); w.bool(t.control); w.u8(t.reload); w.u8(t.linear); w.bool(t.reset)
+    w.u16(uint(t.period)); w.u16(uint(t.timer)); w.u8(t.step); w.u8(t.length)
+    n := &apu.noi
+    w.bool(n.enabled); n.env.save(w); w.bool(n.short)
+    w.u16(uint(n.period)); w.u16(uint(n.timer)); w.u16(uint(n.shift)); w.u8(n.length)
+
+    w.bool(apu.five); w.u8(uint8(apu.sequence)); w.u16(apu.counter)
+    w.u64(apu.cycles); w.u64(uint64(apu.fraction))
+    for _, sum := range apu.sums {w.u64(uint64(sum))}
+    w.u64(uint64(apu.count))
+}
+
+
+// restore the state of the channels from a snapshot
+//( samples not played yet are lost )
+func (apu *APU) load (r *stateReader) {
+    for i := range apu.pulses {
+        p := &apu.pulses[i]
+        p.enabled, p.duty = r.bool(), r.u8() & 0x3; p.env.load(r)
+        p.sweep, p.divider, p.reload = r.u8(), r.u8(), r.bool()
+        p.period, p.timer, p.step, p.length = uint16(r.u16()), uint16(r.u16()), r.u8() % 8, r.u8()
+    }
+    t := &apu.tri
+    t.enabled, t.control, t.reload, t.linear, t.reset = r.bool(), r.bool(), r.u8(), r.u8(), r.bool()
+    t.period, t.timer, t.step, t.length = uint16(r.u16()), uint16(r.u16()), r.u8() % 32, r.u8()
+    n := &apu.noi
+    n.enabled = r.bool(); n.env.load(r); n.short = r.bool()
+    n.period, n.timer, n.shift, n.length = uint16(r.u16()), uint16(r.u16()), uint16(r.u16()), r.u8()
+
+    apu.five, apu.sequence, apu.counter = r.bool(), uint(r.u8()) % 5, r.u16()
+    apu.cycles, apu.fraction = r.u64(), int(r.u64())
+    for i := range apu.sums {apu.sums[i] = uint(r.u64())}
+    apu.count   = uint(r.u64())
+    apu.samples = nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// registers of the triangle and the noise not named by the APU
+const (
+    regTriLo  = 0x0A
+    regTriHi  = 0x0B
+    regNoiLen = 0x0F
+)
+
+
+// run the APU for a number of steps of the frame sequencer
+func runQuarters (apu *APU, n int) {
+    apu.Run(uint64(n) * quarterFrame)
+}
+
+
+// start the length counters of every channel with the same index
+//( the pulses get a period long enough not to be muted )
+func startLengths (apu *APU, index uint8, halt bool) {
+    var env, lin uint8
+    if halt {env, lin = 0x20, 0x80}
+    apu.Write(regEnable, 0x0F)
+    for _, reg := range []uint{regP1Env, regP2Env} {
+        apu.Write(reg, env | 0x1F)
+        apu.Write(reg + 2, 0x00)
+        apu.Write(reg + 3, index << 3 | 0x1)
+    }
+    apu.Write(regTriLin, lin | 0x7F)
+    apu.Write(regTriHi , index << 3)
+    apu.Write(regNoiEnv, env | 0x1F)
+    apu.Write(regNoiLen, index << 3)
+}
+
+
+// the channels stop after the number of half frames of the length table
+func TestAPULengths (t *testing.T) {
+    lengths := map[uint8]int{0: 10, 1: 254, 2: 20, 3: 2, 8: 160, 16: 12, 24: 192, 31: 30}
+    for index, length := range lengths {
+        apu := NewAPU(defaultRate)
+        startLengths(apu, index, false)
+        runQuarters(apu, (length - 1) * 2)
+        if status := apu.Read(regEnable); status != 0x0F {
+            t.Errorf("index %d: channels $%X running after %d half frames", index, status, length - 1)
+        }
+        runQuarters(apu, 2)
+        if status := apu.Read(regEnable); status != 0 {
+            t.Errorf("index %d: channels $%X still running after %d half frames", index, status, length)
+        }
+    }
+
+    // halted lengths keep running
+    apu := NewAPU(defaultRate)
+    startLengths(apu, 3, true)
+    runQuarters(apu, 40)
+    if status := apu.Read(regEnable); status != 0x0F {t.Errorf("halted: channels $%X running", status)}
+
+    // disabled channels do not load their length and writing ENABLE stops them
+    apu.Write(regEnable, 0x05)
+    if status := apu.Read(regEnable); status != 0x05 {t.Errorf("disabled: channels $%X running", status)}
+    apu.Write(regP2Env + 3, 1 << 3)
+    if status := apu.Read(regEnable); status != 0x05 {t.Errorf("disabled: channels $%X running after a write", status)}
+}
+
+
+// the output of a pulse follows its duty cycle, a step every 2 × (period + 1) cycles
+func TestAPUDuties (t *testing.T) {
+    duties := []string{"01000000", "01100000", "01111000", "10011111"}
+    for duty, want := range duties {
+        apu := NewAPU(defaultRate)
+        apu.Write(regEnable, 0x01)
+        apu.Write(regP1Env, uint8(duty) << 6 | 0x3F)
+        apu.Write(regP1Env + 2, 99)
+        apu.Write(regP1Env + 3, 1 << 3)
+
+        got  := []uint8("????????")
+        p    := &apu.pulses[0]
+        last := p.step
+        for i := 0; i < 8; i += 1 {
+            apu.Run(200)
+            if p.step != (last + 1) % 8 {t.Fatalf("duty %d: step %d after step %d", duty, p.step, last)}
+            last = p.step
+            switch p.output() {
+            case 0 : got[p.step] = '0'
+            case 15: got[p.step] = '1'
+            }
+        }
+        if string(got) != want {t.Errorf("duty %d: %s instead of %s", duty, got, want)}
+    }
+}
+
+
+// the volume decays from 15 every period + 1 quarter frames
+func TestAPUEnvelope (t *testing.T) {
+    cases := []struct {
+        loop    bool
+        quarter int
+        volume  uint8
+    }{
+        {false,  0,  0},
+        {false,  1, 15},
+        {false,  3, 15},
+        {false,  4, 14},
+        {false, 43,  1},
+        {false, 46,  0},
+        {false, 60,  0},
+        {true ,  4, 14},
+        {true , 46,  0},
+        {true , 49, 15},
+        {true , 52, 14},
+    }
+    for _, c := range cases {
+        apu := NewAPU(defaultRate)
+        var loop uint8
+        if c.loop {loop = 0x20}
+        apu.Write(regEnable, 0x09)
+        apu.Write(regP1Env, loop | 0x02)
+        apu.Write(regP1Env + 3, 1 << 3)
+        apu.Write(regNoiEnv, loop | 0x02)
+        apu.Write(regNoiLen, 1 << 3)
+        runQuarters(apu, c.quarter)
+        if v := apu.pulses[0].env.output(); v != c.volume {
+            t.Errorf("pulse, loop %v: volume %d after %d quarter frames instead of %d", c.loop, v, c.quarter, c.volume)
+        }
+        if v := apu.noi.env.output(); v != c.volume {
+            t.Errorf("noise, loop %v: volume %d after %d quarter frames instead of %d", c.loop, v, c.quarter, c.volume)
+        }
+    }
+
+    // a constant volume does not decay
+    apu := NewAPU(defaultRate)
+    apu.Write(regP1Env, 0x17)
+    runQuarters(apu, 10)
+    if v := apu.pulses[0].env.output(); v != 7 {t.Errorf("constant: volume %d instead of 7", v)}
+}
+
+
+// the sweep changes the period every half frame and mutes the pulse out of range
+func TestAPUSweep (t *testing.T) {
+    cases := []struct {
+        name    string
+        second  bool
+        sweep   uint8
+        period  uint16
+        halves  int
+        want    uint16
+        muted   bool
+    }{
+        {"too low"        , false, 0x00, 0x007, 0, 0x007, true},
+        {"lowest"         , false, 0x00, 0x008, 0, 0x008, false},
+        {"target too high", false, 0x00, 0x400, 0, 0x400, true},  // muted even without sweep
+        {"disabled"       , false, 0x01, 0x100, 4, 0x100, false},
+        {"no shift"       , false, 0x80, 0x100, 4, 0x100, false},
+        {"add"            , false, 0x81, 0x100, 1, 0x180, false},
+        {"add 4 times"    , false, 0x81, 0x100, 4, 0x510, false},
+        {"add until muted", false, 0x81, 0x100, 5, 0x798, true},
+        {"stays muted"    , false, 0x81, 0x100, 9, 0x798, true},
+        {"divider"        , false, 0xA1, 0x100, 4, 0x240, false}, // every 3 half frames from the first
+        {"negate pulse 1" , false, 0x89, 0x100, 1, 0x07F, false},
+        {"negate pulse 2" , true , 0x89, 0x100, 1, 0x080, false},
+        {"negate to low"  , false, 0x89, 0x100, 5, 0x007, true},
+    }
+    for _, c := range cases {
+        apu := NewAPU(defaultRate)
+        reg := uint(regP1Env)
+        if c.second {reg = regP2Env}
+        apu.Write(regEnable, 0x03)
+        apu.Write(reg    , 0xBF)
+        apu.Write(reg + 1, c.sweep)
+        apu.Write(reg + 2, uint8(c.period))
+        apu.Write(reg + 3, 1 << 3 | uint8(c.period >> 8))
+        runQuarters(apu, c.halves * 2)
+
+        p := &apu.pulses[reg / 4]
+        if p.period != c.want || p.muted() != c.muted {
+            t.Errorf("%s: period $%03X muted %v instead of $%03X muted %v", c.name, p.period, p.muted(), c.want, c.muted)
+        }
+        if c.muted {
+            for i := 0; i < 8; i += 1 {
+                apu.Run(uint64(c.period) * 2 + 2)
+                if v := p.output(); v != 0 {t.Errorf("%s: muted pulse outputs %d", c.name, v)}
+            }
+        }
+    }
+}
+
+
+// quarter and half frames given by the 4 and 5 steps sequences, counted by
+// the linear counter and the length of the triangle
+func TestAPUSequencer (t *testing.T) {
+    cases := []struct {
+        five     bool
+        quarters int // steps of the sequencer run
+        length   uint8
+        linear   uint8
+    }{
+        {false,  0, 254, 0},
+        {false,  1, 254, 127},
+        {false,  2, 253, 126},
+        {false,  3, 253, 125},
+        {false,  4, 252, 124},
+        {false, 20, 244, 108},
+        {true ,  0, 253, 127}, // writing FRAME clocks a quarter and a half frame
+        {true ,  1, 253, 126},
+        {true ,  2, 252, 125},
+        {true ,  3, 252, 124},
+        {true ,  4, 252, 124}, // the fourth step clocks nothing
+        {true ,  5, 251, 123},
+        {true , 20, 245, 111},
+    }
+    for _, c := range cases {
+        apu := NewAPU(defaultRate)
+        apu.Write(regEnable, 0x04)
+        apu.Write(regTriLin, 0x7F)
+        apu.Write(regTriLo , 0x40)
+        apu.Write(regTriHi , 1 << 3)
+        var frame uint8
+        if c.five {frame = 0x80}
+        apu.Write(regFrame, frame)
+        runQuarters(apu, c.quarters)
+        if apu.tri.length != c.length || apu.tri.linear != c.linear {
+            t.Errorf("five %v, %d steps: length %d linear %d instead of %d and %d",
+                c.five, c.quarters, apu.tri.length, apu.tri.linear, c.length, c.linear)
+        }
+    }
+
+    // writing FRAME restarts the sequence
+    apu := NewAPU(defaultRate)
+    apu.Write(regEnable, 0x04)
+    apu.Write(regTriHi , 1 << 3)
+    for i := 0; i < 10; i += 1 {
+        apu.Run(quarterFrame * 2 - 1)
+        apu.Write(regFrame, 0x00)
+    }
+    if apu.tri.length != 254 {t.Errorf("restarted: length %d instead of 254", apu.tri.length)}
+}
@@ -0,0 +1,457 @@
+package main
+
+/*
+    Two-pass assembler producing ROM images for the Processor
+
+    syntax:
+        label:  MNEMONIC operand, operand  ; comment
+        registers     A X Y Z
+        flags         Z N C V  (zero, negative, carry, overflow)
+        numbers       $FF 0xFF %1010 0b1010 255
+        immediates    #n  #<addr (low byte)  #>addr (high byte)
+        expressions   terms joined with + and -, * is the current address
+        directives    .org addr   .byte n, "text"   .word n
+*/
+
+import (
+    "fmt"
+    "flag"
+    "strconv"
+    "strings"
+    "io/ioutil"
+    "path/filepath"
+)
+
+
+// error raised while assembling, located in the source
+type AsmError struct {
+    File string
+    Line int
+    Msg  string
+}
+
+func (err *AsmError) Error () string {
+    return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Msg)
+}
+
+
+// a single line of source once parsed
+type asmLine struct {
+    line  int
+    addr  uint     // address of the first byte emitted
+    op    string   // mnemonic or directive (upper case)
+    args  []string // operands split on commas
+    def  *opDef    // instruction family (nil for directives)
+    inst  uint     // opcode with register, flag and index bits
+    size  uint     // number of bytes emitted
+}
+
+
+// state shared by both passes
+type assembler struct {
+    file   string
+    labels map[string]uint
+    lines  []asmLine
+    image  []uint8
+    used   []bool
+}
+
+
+// assemble a source file and return a memory image
+func AssembleFile (path string) ([]uint8, error) {
+    source, err := ioutil.ReadFile(path)
+    if err != nil {return nil, err}
+    return Assemble(path, string(source))
+}
+
+
+// assemble a source and return a memory image
+//( the file name is only used to locate errors )
+func Assemble (file, source string) ([]uint8, error) {
+    asm := assembler {
+        file:   file,
+        labels: make(map[string]uint),
+        image:  make([]uint8, memSize),
+        used:   make([]bool , memSize),
+    }
+    if err := asm.firstPass(source); err != nil {return nil, err}
+    if err := asm.secondPass()     ; err != nil {return nil, err}
+    return asm.image, nil
+}
+
+
+// build an error for the given line
+func (asm *assembler) errorf (line int, format string, args ...interface{}) error {
+    return &AsmError{asm.file, line, fmt.Sprintf(format, args...)}
+}
+
+
+// parse lines, compute their size and place labels
+func (asm *assembler) firstPass (source string) error {
+    pc := uint(0)
+    for i, text := range strings.Split(source, "\n") {
+        line := asmLine{line: i + 1}
+        text  = strings.TrimSpace(stripComment(text))
+
+        // a label is placed at the current address
+        if colon := strings.Index(text, ":"); colon >= 0 && isIdent(text[:colon]) {
+            name := text[:colon]
+            if isReserved(name) {
+                return asm.errorf(line.line, "reserved name used as label: %s", name)
+            }
+            if _, ok := asm.labels[name]; ok {
+                return asm.errorf(line.line, "duplicate label: %s", name)
+            }
+            asm.labels[name] = pc
+            text = strings.TrimSpace(text[colon + 1:])
+        }
+        if text == "" {continue}
+
+        // split mnemonic and operands
+        op, rest := text, ""
+        if space := strings.IndexAny(text, " \t"); space >= 0 {
+            op, rest = text[:space], strings.TrimSpace(text[space:])
+        }
+        line.op = strings.ToUpper(op)
+        if rest != "" {
+            args, err := splitArgs(rest)
+            if err != nil {return asm.errorf(line.line, "%v", err)}
+            line.args = args
+        }
+
+        switch line.op {
+        case ".ORG":
+            if len(line.args) != 1 {
+                return asm.errorf(line.line, ".org expects 1 operand given %d", len(line.args))
+            }
+            val, err := asm.eval(line.args[0], pc)
+            if err != nil {return asm.errorf(line.line, "%v", err)}
+            if val < 0 || val >= memSize {
+                return asm.errorf(line.line, "origin out of range: %d", val)
+            }
+            pc = uint(val)
+            continue
+        case ".BYTE", ".WORD":
+            if len(line.args) == 0 {
+                return asm.errorf(line.line, "%s expects at least 1 operand", strings.ToLower(line.op))
+            }
+            for _, arg := range line.args {
+                if line.op == ".WORD" {
+                    line.size += 2
+                } else if isString(arg) {
+                    str, err := strconv.Unquote(arg)
+                    if err != nil {return asm.errorf(line.line, "invalid string: %s", arg)}
+                    line.size += uint(len(str))
+                } else {
+                    line.size += 1
+                }
+            }
+        default:
+            if err := line.decode(); err != nil {return asm.errorf(line.line, "%v", err)}
+            line.size = line.def.kind.Size(line.inst)
+        }
+
+        line.addr = pc
+        if pc + line.size > memSize {
+            return asm.errorf(line.line, "program exceeds memory at $%04X", pc)
+        }
+        pc += line.size
+        asm.lines = append(asm.lines, line)
+    }
+    return nil
+}
+
+
+// evaluate operands and emit bytes in the image
+func (asm *assembler) secondPass () error {
+    for _, line := range asm.lines {
+        var bytes []uint8
+
+        // emit a value after checking it fits in the given size
+        emit := func (arg string, size uint) error {
+            val, err := asm.eval(arg, line.addr)
+            if err != nil {return err}
+            if size == 1 {
+                if val < -0x80 || val > 0xFF {return fmt.Errorf("byte out of range: %d", val)}
+                bytes = append(bytes, uint8(val))
+            } else {
+                if val < 0 || val > 0xFFFF {return fmt.Errorf("address out of range: %d", val)}
+                bytes = append(bytes, uint8(val >> 8), uint8(val))
+            }
+            return nil
+        }
+
+        var err error
+        switch line.op {
+        case ".BYTE":
+            for _, arg := range line.args {
+                if isString(arg) {
+                    str, _ := strconv.Unquote(arg)
+                    bytes = append(bytes, str...)
+                } else if err = emit(arg, 1); err != nil {break}
+            }
+        case ".WORD":
+            for _, arg := range line.args {
+                if err = emit(arg, 2); err != nil {break}
+            }
+        default:
+            bytes = append(bytes, uint8(line.inst))
+            switch line.def.kind {
+            case kindRegAddr, kindFlagAddr:
+                err = emit(line.args[1], 2)
+            case kindIndexed, kindAddr:
+                err = emit(line.args[0], 2)
+            case kindRegNum:
+                err = emit(line.args[1][1:], 1)
+            case kindOperand:
+                if line.size > 1 {err = emit(line.args[0], 2)}
+            }
+        }
+        if err != nil {return asm.errorf(line.line, "%v", err)}
+
+        // copy the bytes while detecting overlapping sections
+        for i, b := range bytes {
+            addr := line.addr + uint(i)
+            if asm.used[addr] {
+                return asm.errorf(line.line, "overlapping output at $%04X", addr)
+            }
+            asm.image[addr] = b
+            asm.used [addr] = true
+        }
+    }
+    return nil
+}
+
+
+// find the opcode matching the mnemonic and the operands
+func (line *asmLine) decode () error {
+    args := line.args
+    reg  := func (i int) int {
+        if i >= len(args) {return -1}
+        return indexOf(regNames[:], strings.ToUpper(args[i]))
+    }
+    flag := func (i int) int {
+        if i >= len(args) {return -1}
+        return indexOf(flagNames[:], strings.ToUpper(args[i]))
+    }
+    isNum := func (i int) bool {
+        return i < len(args) && strings.HasPrefix(args[i], "#")
+    }
+
+    known := false
+    for i := range opDefs {
+        def := &opDefs[i]
+        if def.name != line.op {continue}
+        known = true
+
+        // check operands match this form of the instruction
+        inst, ok := def.base, false
+        switch def.kind {
+        case kindNone:
+            ok = len(args) == 0
+        case kindReg:
+            ok = len(args) == 1 && reg(0) >= 0
+            inst |= uint(reg(0))
+        case kindRegAddr:
+            ok = len(args) == 2 && reg(0) >= 0 && reg(1) < 0 && !isNum(1)
+            inst |= uint(reg(0))
+        case kindIndexed:
+            ok = (len(args) == 1 || (len(args) == 2 && reg(1) > 0)) && reg(0) < 0 && !isNum(0)
+            if len(args) == 2 {inst |= uint(reg(1))}
+        case kindRegNum:
+            ok = len(args) == 2 && reg(0) >= 0 && isNum(1)
+            inst |= uint(reg(0))
+        case kindAddr:
+            ok = len(args) == 1 && reg(0) < 0 && !isNum(0)
+        case kindRegReg:
+            ok = len(args) == 2 && reg(0) >= 0 && reg(1) >= 0
+            inst |= uint(reg(1) << 2 | reg(0))
+        case kindOperand:
+            if len(args) == 1 && reg(0) >= 0 {
+                ok    = true
+                inst |= uint(reg(0))
+            } else {
+                ok    = (len(args) == 1 || (len(args) == 2 && reg(1) > 0)) && reg(0) < 0 && !isNum(0)
+                inst |= 0x4
+                if len(args) == 2 {inst |= uint(reg(1))}
+            }
+        case kindFlag:
+            ok = len(args) == 1 && flag(0) >= 0
+            inst |= uint(flag(0))
+        case kindFlagAddr:
+            ok = len(args) == 2 && flag(0) >= 0 && !isNum(1)
+            inst |= uint(flag(0))
+        }
+        if ok {
+            line.def  = def
+            line.inst = inst
+            return nil
+        }
+    }
+
+    if !known {return fmt.Errorf("unknown instruction: %s", line.op)}
+    return fmt.Errorf("invalid operands for %s: %s", line.op, strings.Join(args, ", "))
+}
+
+
+// evaluate an expression made of numbers and labels joined with + and -
+func (asm *assembler) eval (expr string, pc uint) (int, error) {
+    expr = strings.TrimSpace(expr)
+    if expr == "" {return 0, fmt.Errorf("missing value")}
+
+    // select the low or high byte of the expression
+    if expr[0] == '<' || expr[0] == '>' {
+        val, err := asm.eval(expr[1:], pc)
+        if err != nil {return 0, err}
+        if expr[0] == '>' {val >>= 8}
+        return val & 0xFF, nil
+    }
+
+    total, sign, start := 0, 1, 0
+    for i := 0; i <= len(expr); i += 1 {
+        // split terms on operators, a leading sign is part of the first term
+        if i < len(expr) && (i == start || (expr[i] != '+' && expr[i] != '-')) {continue}
+
+        term := strings.TrimSpace(expr[start:i])
+        val, err := asm.term(term, pc)
+        if err != nil {return 0, err}
+        total += sign * val
+
+        if i < len(expr) {
+            sign = 1
+            if expr[i] == '-' {sign = -1}
+        }
+        start = i + 1
+    }
+    return total, nil
+}
+
+
+// evaluate a single number or label
+func (asm *assembler) term (term string, pc uint) (int, error) {
+    switch {
+    case term == "":
+        return 0, fmt.Errorf("missing value")
+    case term == "*":
+        return int(pc), nil
+    case term[0] == '-' || term[0] == '+':
+        val, err := asm.term(strings.TrimSpace(term[1:]), pc)
+        if term[0] == '-' {val = -val}
+        return val, err
+    case isIdent(term):
+        val, ok := asm.labels[term]
+        if !ok {return 0, fmt.Errorf("undefined label: %s", term)}
+        return int(val), nil
+    }
+    return parseNumber(term)
+}
+
+
+// parse a number in hexadecimal, binary or decimal notation
+func parseNumber (text string) (int, error) {
+    base, digits := 10, text
+    lower := strings.ToLower(text)
+    switch {
+    case strings.HasPrefix(lower, "$" ): base, digits = 16, text[1:]
+    case strings.HasPrefix(lower, "0x"): base, digits = 16, text[2:]
+    case strings.HasPrefix(lower, "%" ): base, digits = 2 , text[1:]
+    case strings.HasPrefix(lower, "0b"): base, digits = 2 , text[2:]
+    }
+    val, err := strconv.ParseInt(digits, base, 32)
+    if err != nil {return 0, fmt.Errorf("invalid number: %s", text)}
+    return int(val), nil
+}
+
+
+// split operands on commas outside of strings
+func splitArgs (text string) ([]string, error) {
+    var (
+        args  []string
+        quote = false
+        start = 0
+    )
+    for i := 0; i < len(text); i += 1 {
+        switch {
+        case text[i] == '\\' && quote:
+            i += 1
+        case text[i] == '"':
+            quote = !quote
+        case text[i] == ',' && !quote:
+            args  = append(args, strings.TrimSpace(text[start:i]))
+            start = i + 1
+        }
+    }
+    if quote {return nil, fmt.Errorf("unterminated string")}
+    args = append(args, strings.TrimSpace(text[start:]))
+
+    for _, arg := range args {
+        if arg == "" {return nil, fmt.Errorf("empty operand")}
+    }
+    return args, nil
+}
+
+
+// remove the comment at the end of a line
+func stripComment (text string) string {
+    quote := false
+    for i := 0; i < len(text); i += 1 {
+        switch {
+        case text[i] == '\\' && quote:
+            i += 1
+        case text[i] == '"':
+            quote = !quote
+        case text[i] == ';' && !quote:
+            return text[:i]
+        }
+    }
+    return text
+}
+
+
+// check if the text is a valid label name
+func isIdent (text string) bool {
+    if text == "" {return false}
+    for i, c := range text {
+        letter := c == '_' || c == '.' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+        digit  := '0' <= c && c <= '9'
+        if !letter && !(digit && i > 0) {return false}
+    }
+    return text[0] != '.'
+}
+
+
+// registers cannot be used as labels
+func isReserved (name string) bool {
+    return indexOf(regNames[:], strings.ToUpper(name)) >= 0
+}
+
+
+func isString (text string) bool {
+    return len(text) >= 2 && text[0] == '"' && text[len(text) - 1] == '"'
+}
+
+
+func indexOf (list []string, value string) int {
+    for i, v := range list {
+        if v == value {return i}
+    }
+    return -1
+}
+
+
+// command line: vox-legacy asm [-o output] source
+func cmdAssemble (args []string) error {
+    flags  := flag.NewFlagSet("asm", flag.ContinueOnError)
+    output := flags.String("o", "", "ROM image to write (default: source with .rom extension)")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {return fmt.Errorf("usage: vox-legacy asm [-o output] source")}
+
+    source := flags.Arg(0)
+    image, err := AssembleFile(source)
+    if err != nil {return err}
+
+    if *output == "" {
+        *output = strings.TrimSuffix(source, filepath.Ext(source)) + ".rom"
+    }
+    return ioutil.WriteFile(*output, image, 0644)
+}
@@ -0,0 +1,70 @@
+// +build audio
+
+package main
+
+/*
+    Speakers of the computer, built with: go build -tags audio
+
+    the samples are piped in 16-bit PCM to the first player found,
+    aplay of ALSA or paplay of PulseAudio, without the tag or without
+    a player the audio is dropped as before
+*/
+
+import (
+    "io"
+    "fmt"
+    "os/exec"
+    "strconv"
+)
+
+
+// command lines of the players, given the rate
+var audioPlayers = []func (rate string) []string {
+    func (rate string) []string {return []string{"aplay", "-q", "-t", "raw", "-f", "S16_LE", "-c", "1", "-r", rate, "-"}},
+    func (rate string) []string {return []string{"paplay", "--raw", "--format=s16le", "--channels=1", "--rate=" + rate}},
+}
+
+
+func init () {
+    AudioBackend = openPlayer
+}
+
+
+// samples written to the standard input of a player
+type AudioPipe struct {
+    cmd  *exec.Cmd
+    in   io.WriteCloser
+    buf  []uint8
+    err  error // first write failed, the player is gone
+}
+
+
+// start the first player installed
+func openPlayer (rate int) (AudioOutput, error) {
+    for _, player := range audioPlayers {
+        args := player(strconv.Itoa(rate))
+        if _, err := exec.LookPath(args[0]); err != nil {continue}
+
+        cmd     := exec.Command(args[0], args[1:]...)
+        in, err := cmd.StdinPipe()
+        if err != nil {return nil, fmt.Errorf("Cannot open the speakers: %v", err)}
+        if err := cmd.Start(); err != nil {return nil, fmt.Errorf("Cannot open the speakers: %v", err)}
+        return &AudioPipe{cmd: cmd, in: in}, nil
+    }
+    return nil, fmt.Errorf("Cannot open the speakers: no aplay or paplay found")
+}
+
+
+func (pipe *AudioPipe) Play (samples []int16) {
+    if pipe.err != nil {return}
+    pipe.buf = pipe.buf[:0]
+    for _, s := range samples {pipe.buf = append(pipe.buf, uint8(s), uint8(uint16(s) >> 8))}
+    _, pipe.err = pipe.in.Write(pipe.buf)
+}
+
+
+// stop the player once it has played the samples given
+func (pipe *AudioPipe) Close () error {
+    pipe.in.Close()
+    return pipe.cmd.Wait()
+}
@@ -0,0 +1,41 @@
+// +build audio
+
+package main
+
+import (
+    "os"
+    "bytes"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+)
+
+
+// the samples reach the player in 16-bit little endian PCM
+func TestAudioPipe (t *testing.T) {
+    dir, err := ioutil.TempDir("", "audio")
+    if err != nil {t.Fatal(err)}
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "pcm")
+
+    saved := audioPlayers
+    defer func () {audioPlayers = saved}()
+    audioPlayers = []func (rate string) []string {
+        func (rate string) []string {return []string{"no-such-player"}},
+        func (rate string) []string {return []string{"sh", "-c", "cat > " + path}},
+    }
+    out, err := AudioBackend(defaultRate)
+    if err != nil {t.Fatal(err)}
+    out.Play([]int16{1, -2, 0x1234})
+    out.Play([]int16{-32768})
+    if err := out.(*AudioPipe).Close(); err != nil {t.Fatal(err)}
+
+    data, err := ioutil.ReadFile(path)
+    if err != nil {t.Fatal(err)}
+    if want := []uint8{0x01, 0x00, 0xFE, 0xFF, 0x34, 0x12, 0x00, 0x80}; !bytes.Equal(data, want) {
+        t.Errorf("player received % X instead of % X", data, want)
+    }
+
+    audioPlayers = audioPlayers[:1]
+    if _, err := AudioBackend(defaultRate); err == nil {t.Error("speakers opened without a player")}
+}
@@ -0,0 +1,159 @@
+package main
+
+/*
+    Audio rendered offline, programs run without a window and their
+    samples are written in WAV files or compared with checksums
+
+    every program of the directory (*.asm assembled and loaded at $0000,
+    *.vox cartridges inserted) is run for a fixed number of frames, the
+    CRC-32 of its samples is compared with the one stored in sums.txt
+    ("name crc" per line), a program that differs is written next to it
+    as <name>.actual.wav
+
+    go test -run AudioChecksums -update   writes the checksums again
+*/
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "bufio"
+    "strings"
+    "hash/crc32"
+    "io/ioutil"
+    "encoding/binary"
+    "path/filepath"
+)
+
+
+const (
+    audioFrames  = 120 // frames of every program checked
+    audioSumFile = "sums.txt"
+)
+
+
+// run a console for a number of frames and keep its samples
+//( the samples played until the processor halts are returned with the fault )
+func RenderAudio (con *Console, frames int) ([]int16, error) {
+    saved := con.audio
+    defer con.SetAudio(saved)
+
+    rec := &AudioRecorder{Rate: con.apu.Rate}
+    con.SetAudio(rec)
+    for i := 0; i < frames; i += 1 {
+        if err := con.Frame(); err != nil {return rec.Samples, err}
+    }
+    return rec.Samples, nil
+}
+
+
+// checksum of samples, in little endian as written in WAV files
+func audioChecksum (samples []int16) uint32 {
+    data := make([]uint8, len(samples) * 2)
+    for i, s := range samples {
+        binary.LittleEndian.PutUint16(data[i * 2:], uint16(s))
+    }
+    return crc32.ChecksumIEEE(data)
+}
+
+
+// read the checksums stored for the programs
+func loadAudioSums (path string) (map[string]uint32, error) {
+    sums := make(map[string]uint32)
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {return sums, nil}
+    if err != nil {return nil, err}
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line += 1 {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 0 {continue}
+        if len(fields) != 2 {
+            return nil, fmt.Errorf("%s:%d: expecting name and checksum", path, line)
+        }
+        var sum uint32
+        if _, err := fmt.Sscanf(fields[1], "%08x", &sum); err != nil {
+            return nil, fmt.Errorf("%s:%d: invalid checksum %q", path, line, fields[1])
+        }
+        sums[fields[0]] = sum
+    }
+    return sums, scanner.Err()
+}
+
+
+// render the programs of a directory and compare their checksums
+//( with update, the checksums are written instead )
+func CheckAudio (dir string, update bool) ([]string, error) {
+    programs, err := filepath.Glob(filepath.Join(dir, "*.asm"))
+    if err != nil {return nil, err}
+    carts, err := filepath.Glob(filepath.Join(dir, "*.vox"))
+    if err != nil {return nil, err}
+    programs = append(programs, carts...)
+    if len(programs) == 0 {return nil, fmt.Errorf("Cannot check audio: no program in %s", dir)}
+    sort.Strings(programs)
+
+    sums, err := loadAudioSums(filepath.Join(dir, audioSumFile))
+    if err != nil {return nil, err}
+
+    var failures []string
+    var lines    strings.Builder
+    for _, path := range programs {
+        name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+        con  := NewConsole()
+        if filepath.Ext(path) == ".asm" {
+            image, err := AssembleFile(path)
+            if err != nil {return nil, err}
+            if err := con.bus.Load(0, image); err != nil {return nil, err}
+        } else if err := con.LoadFile(path, "$0000"); err != nil {
+            return nil, fmt.Errorf("%s: %v", path, err)
+        }
+        samples, err := RenderAudio(con, audioFrames)
+        if err != nil {return nil, fmt.Errorf("%s: %v", path, err)}
+        sum := audioChecksum(samples)
+        fmt.Fprintf(&lines, "%s %08x\n", name, sum)
+        if update {continue}
+
+        expected, ok := sums[name]
+        if !ok {
+            failures = append(failures, fmt.Sprintf("%s: no checksum in %s", name, audioSumFile))
+            continue
+        }
+        if sum != expected {
+            // keep the audio rendered to listen to it
+            actual := filepath.Join(dir, name + ".actual.wav")
+            rec    := AudioRecorder{Rate: con.apu.Rate, Samples: samples}
+            if err := rec.Save(actual); err != nil {return nil, err}
+            failures = append(failures, fmt.Sprintf("%s: checksum %08x instead of %08x, see %s", name, sum, expected, actual))
+        }
+    }
+
+    if update {
+        return nil, ioutil.WriteFile(filepath.Join(dir, audioSumFile), []uint8(lines.String()), 0644)
+    }
+    return failures, nil
+}
+
+
+// command line: vox-legacy wav [-frames n] [-rate r] [-pc addr] [-o out.wav] image|game.vox
+func cmdWav (args []string) error {
+    flags  := flag.NewFlagSet("wav", flag.ContinueOnError)
+    frames := flags.Int   ("frames", 300        , "number of frames to run")
+    rate   := flags.Int   ("rate"  , defaultRate, "samples per second")
+    entry  := flags.String("pc"    , "$0000"    , "address of the first instruction of an image")
+    output := flags.String("o"     , "out.wav"  , "WAV file to write")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 || *frames < 0 || *rate <= 0 {
+        return fmt.Errorf("usage: vox-legacy wav [-frames n] [-rate r] [-pc addr] [-o out.wav] image|game.vox")
+    }
+
+    con := NewConsole()
+    con.apu.Rate = *rate
+    if err := con.LoadFile(flags.Arg(0), *entry); err != nil {return err}
+    samples, err := RenderAudio(con, *frames)
+    if err != nil {return err}
+    rec := AudioRecorder{Rate: *rate, Samples: samples}
+    fmt.Printf("%d samples, checksum %08x\n", len(rec.Samples), audioChecksum(rec.Samples))
+    return rec.Save(*output)
+}
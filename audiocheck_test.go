@@ -0,0 +1,16 @@
+package main
+
+import (
+    "testing"
+    "path/filepath"
+)
+
+
+// the programs of testdata/audio sound as their checksums
+func TestAudioChecksums (t *testing.T) {
+    failures, err := CheckAudio(filepath.Join("testdata", "audio"), *update)
+    if err != nil {t.Fatal(err)}
+    for _, failure := range failures {
+        t.Error(failure)
+    }
+}
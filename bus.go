@@ -0,0 +1,152 @@
+package main
+
+/*
+    Bus connecting the Processor to the devices of the console
+    ranges of addresses are mapped to devices, unmapped addresses read 0
+*/
+
+import (
+    "fmt"
+)
+
+
+// component of the console reachable from the bus
+//( addresses are relative to the start of the mapping )
+type Device interface {
+    Read  (addr uint) uint8
+    Write (addr uint, value uint8)
+}
+
+// device with side effects on reads that can be inspected without them
+type Peeker interface {
+    Peek (addr uint) uint8
+}
+
+// device that can be filled by loaders and tools, even if read-only
+type Poker interface {
+    Poke (addr uint, value uint8)
+}
+
+
+// a device mapped on a range of addresses
+type mapping struct {
+    name  string
+    start uint
+    end   uint
+    dev   Device
+}
+
+
+type Bus struct {
+    maps  []mapping
+    table [memSize]uint8 // mapping used by each address (0 if unmapped)
+
+    // called on every read and write made by programs (used by the debugger)
+    OnRead  func (index uint)
+    OnWrite func (index, value uint)
+}
+
+
+// create a bus with a single memory covering every address
+func NewFlatBus () *Bus {
+    bus := &Bus{}
+    bus.Map("RAM", 0, memSize, NewMemory(memSize))
+    return bus
+}
+
+
+// map a device on the addresses between start and end (excluded)
+//( the device replaces any device previously mapped on the range,
+//  a device mapped again with the same name and range takes its slot )
+func (bus *Bus) Map (name string, start, end uint, dev Device) error {
+    if start >= end || end > memSize {
+        return fmt.Errorf("Cannot map %s: invalid range $%04X-$%04X", name, start, end)
+    }
+
+    id := 0
+    for i, m := range bus.maps {
+        if m.name == name && m.start == start && m.end == end {id = i + 1}
+    }
+    if id == 0 {
+        if len(bus.maps) >= 0xFF {
+            return fmt.Errorf("Cannot map %s: too many devices", name)
+        }
+        bus.maps = append(bus.maps, mapping{})
+        id = len(bus.maps)
+    }
+    bus.maps[id - 1] = mapping{name, start, end, dev}
+    for i := start; i < end; i += 1 {
+        bus.table[i] = uint8(id)
+    }
+    return nil
+}
+
+
+// find the device mapped at an address and the address relative to it
+func (bus *Bus) find (index uint) (*mapping, uint) {
+    index &= memSize - 1
+    id := bus.table[index]
+    if id == 0 {return nil, 0}
+    m := &bus.maps[id - 1]
+    return m, index - m.start
+}
+
+
+// name of the device mapped at an address
+func (bus *Bus) DeviceName (index uint) string {
+    m, _ := bus.find(index)
+    if m == nil {return "unmapped"}
+    return m.name
+}
+
+
+// return a single byte without side effects
+func (bus *Bus) GetByte (index uint) uint {
+    m, addr := bus.find(index)
+    if m == nil {return 0}
+    if p, ok := m.dev.(Peeker); ok {return uint(p.Peek(addr))}
+    return uint(m.dev.Read(addr))
+}
+
+// return two bytes without side effects (usually an address)
+func (bus *Bus) GetAddress (index uint) uint {
+    high := bus.GetByte(index)
+    low  := bus.GetByte(index + 1)
+    return (high << 8) | low
+}
+
+
+// read a byte on behalf of a program
+func (bus *Bus) Read (index uint) uint {
+    index &= memSize - 1
+    if bus.OnRead != nil {bus.OnRead(index)}
+    m, addr := bus.find(index)
+    if m == nil {return 0}
+    return uint(m.dev.Read(addr))
+}
+
+
+// write a byte on behalf of a program
+func (bus *Bus) Write (index, value uint) {
+    index &= memSize - 1
+    if bus.OnWrite != nil {bus.OnWrite(index, value)}
+    m, addr := bus.find(index)
+    if m == nil {return}
+    m.dev.Write(addr, uint8(value))
+}
+
+
+// copy an image starting at the given address
+//( only memories are filled, bytes mapped on other devices are skipped )
+func (bus *Bus) Load (offset uint, image []uint8) error {
+    if offset + uint(len(image)) > memSize {
+        return fmt.Errorf(
+            "Cannot load image: %d bytes do not fit at $%04X", len(image), offset)
+    }
+    for i, b := range image {
+        m, addr := bus.find(offset + uint(i))
+        if m == nil {continue}
+        if p, ok := m.dev.(Poker); ok {p.Poke(addr, b)}
+    }
+    return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// swapping cartridges replaces the ROM instead of piling up mappings
+func TestBusRemapROM (t *testing.T) {
+    con   := NewConsole()
+    count := len(con.bus.maps)
+    for i := 0; i < 300; i += 1 {
+        mapper, err := NewMapper(&Cartridge{Tiles: make([]TileBank, 1)})
+        if err != nil {t.Fatal(err)}
+        if err := con.SetMapper(mapper); err != nil {t.Fatalf("cartridge %d: %v", i, err)}
+        if err := con.bus.Load(addrROM, []uint8{uint8(i)}); err != nil {t.Fatal(err)}
+        if got := con.bus.GetByte(addrROM); got != uint(uint8(i)) {
+            t.Fatalf("cartridge %d: ROM reads $%02X", i, got)
+        }
+    }
+    if len(con.bus.maps) != count {
+        t.Errorf("%d mappings instead of %d", len(con.bus.maps), count)
+    }
+    if name := con.bus.DeviceName(addrROM); name != "ROM" {
+        t.Errorf("%s mapped on the ROM", name)
+    }
+}
+
+
+func TestBusMapErrors (t *testing.T) {
+    bus := &Bus{}
+    if err := bus.Map("RAM", 0x100, 0x100, NewMemory(1)); err == nil {
+        t.Error("empty range mapped")
+    }
+    if err := bus.Map("RAM", 0, memSize + 1, NewMemory(memSize + 1)); err == nil {
+        t.Error("range past the memory mapped")
+    }
+    for i := 0; i < 0xFF; i += 1 {
+        if err := bus.Map("RAM", uint(i), uint(i) + 1, NewMemory(1)); err != nil {t.Fatal(err)}
+    }
+    if err := bus.Map("RAM", 0x100, 0x101, NewMemory(1)); err == nil {
+        t.Error("more than 255 devices mapped")
+    }
+}
+
+
+// OAMDMA copies a page in the attribute table and stalls the processor,
+// the table is also read and written byte by byte on the bus
+func TestBusOAMDMA (t *testing.T) {
+    image, err := Assemble("dma", `
+        .org $4000
+        LOD A, #$03
+        STR A, $201D
+end:    JMP end
+`)
+    if err != nil {t.Fatal(err)}
+    con  := NewConsole()
+    cart := &Cartridge{Origin: addrROM, Entry: addrROM, Program: image[addrROM:], Tiles: make([]TileBank, 1)}
+    if err := con.Insert(cart); err != nil {t.Fatal(err)}
+
+    // entries whose bytes are all kept, the unused bytes read 0
+    entry := func (i uint) [oamEntrySize]uint8 {
+        return [oamEntrySize]uint8{uint8(i + 1), uint8(i % 8) << 3 | uint8(i % 4), uint8(i * 3), uint8(i * 5), uint8(i * 7), uint8(i) & 0x3F}
+    }
+    for i := uint(0); i < nbSprites; i += 1 {
+        e := entry(i)
+        copy(con.ram.data[0x300 + i * oamEntrySize:], e[:6])
+        con.ram.data[0x300 + i * oamEntrySize + 6] = 0xFF
+    }
+
+    con.cpu.Cycle()
+    before := con.cpu.Cycles()
+    con.cpu.Cycle()
+    if spent := con.cpu.Cycles() - before; spent != uint64(opCycles[image[addrROM + 2]]) + oamSize + 1 {
+        t.Errorf("STR $201D took %d cycles", spent)
+    }
+    for i := uint(0); i < nbSprites; i += 1 {
+        e := entry(i)
+        for b := uint(0); b < oamEntrySize; b += 1 {
+            if got := con.bus.GetByte(addrOAM + i * oamEntrySize + b); got != uint(e[b]) {
+                t.Errorf("sprite %d: byte %d reads $%02X instead of $%02X", i, b, got, e[b])
+            }
+        }
+    }
+    s := &con.ppu.sprites[9]
+    if s.id_tile != 10 || s.id_pal != 1 || s.pos != (Vector3{27, 45, 63}) || s.mir.GetByte() != 1 || s.rot.GetByte() != 9 {
+        t.Errorf("sprite 9 copied as %+v", *s)
+    }
+
+    // bytes written on the bus move the sprites, the registers show in the table
+    con.bus.Write(addrOAM + 5 * oamEntrySize + 2, 0x77)
+    if x := con.ppu.sprites[5].pos.x; x != 0x77 {t.Errorf("sprite 5 at x %d after a write in the table", x)}
+    con.bus.Write(addrPPU + regSprite, 63)
+    con.bus.Write(addrPPU + regSZ    , 0x42)
+    if z := con.bus.GetByte(addrOAM + oamSize - oamEntrySize + 4); z != 0x42 {t.Errorf("SZ reads $%02X in the table", z)}
+
+    // a page of the ROM
+    con.bus.Write(addrPPU + regOAMDMA, 0x40)
+    if tile := con.ppu.sprites[0].id_tile; tile != uint(image[addrROM]) {t.Errorf("tile %d copied from the ROM", tile)}
+}
@@ -0,0 +1,369 @@
+package main
+
+/*
+    Cartridge packaging a game in a single file (big endian)
+
+    offset  size
+    0       4      magic "VOXL"
+    4       1      version
+    5       1      mapper (see mapper.go)
+    6       32     title (padded with zeros)
+    38      2      entry address
+    40      2      address where the program is loaded
+    42      4      size of the program
+    46      n      program
+    +n      32768  banks of 256 tiles of 64 rows, 2 bits per voxel
+                   (one or more, their number follows from the file size)
+    ...     24     8 palettes of 3 color indices
+    ...     32768  2 tile maps of 4096 cells (tile, rotation, mirror, palette)
+    ...     4      CRC-32 of everything before it
+*/
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "bufio"
+    "bytes"
+    "strings"
+    "hash/crc32"
+    "io/ioutil"
+    "path/filepath"
+    "encoding/binary"
+)
+
+
+const (
+    cartMagic   = "VOXL"
+    cartVersion = 1
+    titleSize   = 32
+    headerSize  = 46
+    nbBankTiles = 256
+    tileSize    = nbRows * 2
+)
+
+
+type Cartridge struct {
+    Title    string
+    Mapper   uint8
+    Entry    uint
+    Origin   uint
+    Program  []uint8
+    Tiles    []TileBank
+    Palettes [nbPalettes][nbColors4Pal]uint8
+    Maps     [2]TileMap
+}
+
+
+// read a cartridge from a file
+func LoadCartridge (path string) (*Cartridge, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {return nil, err}
+    cart, err := DecodeCartridge(data)
+    if err != nil {return nil, fmt.Errorf("%s: %v", path, err)}
+    return cart, nil
+}
+
+
+// write a cartridge in a file
+func (cart *Cartridge) Save (path string) error {
+    data, err := cart.Encode()
+    if err != nil {return err}
+    return ioutil.WriteFile(path, data, 0644)
+}
+
+
+// read a cartridge from a reader
+func ReadCartridge (r io.Reader) (*Cartridge, error) {
+    data, err := ioutil.ReadAll(r)
+    if err != nil {return nil, err}
+    return DecodeCartridge(data)
+}
+
+
+// write a cartridge in a writer
+func (cart *Cartridge) Write (w io.Writer) error {
+    data, err := cart.Encode()
+    if err != nil {return err}
+    _, err = w.Write(data)
+    return err
+}
+
+
+// decode and validate the content of a cartridge file
+func DecodeCartridge (data []uint8) (*Cartridge, error) {
+    if len(data) < headerSize {
+        return nil, fmt.Errorf("Invalid cartridge: %d bytes is too short", len(data))
+    }
+    if string(data[:4]) != cartMagic {
+        return nil, fmt.Errorf("Invalid cartridge: bad magic %q", data[:4])
+    }
+    if data[4] != cartVersion {
+        return nil, fmt.Errorf("Invalid cartridge: unsupported version %d", data[4])
+    }
+
+    // check the size before reading the sections
+    size := uint64(binary.BigEndian.Uint32(data[42:46]))
+    want := uint64(headerSize) + size + cartDataSize + 4
+    if uint64(len(data)) < want || (uint64(len(data)) - want) % bankSize != 0 {
+        return nil, fmt.Errorf(
+            "Invalid cartridge: expecting %d bytes and more banks of %d given %d", want, bankSize, len(data))
+    }
+    banks := 1 + (uint64(len(data)) - want) / bankSize
+    sum := binary.BigEndian.Uint32(data[len(data) - 4:])
+    if crc32.ChecksumIEEE(data[:len(data) - 4]) != sum {
+        return nil, fmt.Errorf("Invalid cartridge: checksum mismatch")
+    }
+
+    cart := &Cartridge {
+        Title:  strings.TrimRight(string(data[6:6 + titleSize]), "\x00"),
+        Mapper: data[5],
+        Entry:  uint(binary.BigEndian.Uint16(data[38:40])),
+        Origin: uint(binary.BigEndian.Uint16(data[40:42])),
+    }
+    data = data[headerSize:]
+    cart.Program = append([]uint8(nil), data[:size]...)
+    data = data[size:]
+
+    // tiles are stored row by row
+    cart.Tiles = make([]TileBank, banks)
+    for b := range cart.Tiles {
+        for i := range cart.Tiles[b] {
+            for r := 0; r < nbRows; r += 1 {
+                cart.Tiles[b][i].rows[r] = binary.BigEndian.Uint16(data[r * 2:])
+            }
+            data = data[tileSize:]
+        }
+    }
+    for i := range cart.Palettes {
+        copy(cart.Palettes[i][:], data)
+        data = data[nbColors4Pal:]
+    }
+    for i := range cart.Maps {
+        for c := uint(0); c < nbTiles; c += 1 {
+            cell := data[c * 4:]
+            cart.Maps[i].Set(c, cell[0], cell[1], cell[2], cell[3])
+        }
+        data = data[nbTiles * 4:]
+    }
+
+    if err := cart.Validate(); err != nil {return nil, err}
+    return cart, nil
+}
+
+
+// number of bytes of a bank of tiles and of the sections following the program
+const (
+    bankSize     = nbBankTiles * tileSize
+    cartDataSize = bankSize + nbPalettes * nbColors4Pal + 2 * nbTiles * 4
+)
+
+
+// encode the cartridge in its file format
+func (cart *Cartridge) Encode () ([]uint8, error) {
+    if err := cart.Validate(); err != nil {return nil, err}
+
+    var buf bytes.Buffer
+    var word [4]uint8
+    buf.WriteString(cartMagic)
+    buf.WriteByte(cartVersion)
+    buf.WriteByte(cart.Mapper)
+
+    var title [titleSize]uint8
+    copy(title[:], cart.Title)
+    buf.Write(title[:])
+
+    binary.BigEndian.PutUint16(word[:], uint16(cart.Entry ))
+    buf.Write(word[:2])
+    binary.BigEndian.PutUint16(word[:], uint16(cart.Origin))
+    buf.Write(word[:2])
+    binary.BigEndian.PutUint32(word[:], uint32(len(cart.Program)))
+    buf.Write(word[:])
+    buf.Write(cart.Program)
+
+    for b := range cart.Tiles {
+        for i := range cart.Tiles[b] {
+            for _, row := range cart.Tiles[b][i].rows {
+                buf.WriteByte(uint8(row >> 8))
+                buf.WriteByte(uint8(row))
+            }
+        }
+    }
+    for _, pal := range cart.Palettes {
+        buf.Write(pal[:])
+    }
+    for i := range cart.Maps {
+        for c := uint(0); c < nbTiles; c += 1 {
+            til, rot, mir, pal := cart.Maps[i].Get(c)
+            buf.Write([]uint8{til, rot, mir, pal})
+        }
+    }
+
+    binary.BigEndian.PutUint32(word[:], crc32.ChecksumIEEE(buf.Bytes()))
+    buf.Write(word[:])
+    return buf.Bytes(), nil
+}
+
+
+// check the content of the cartridge can run on the console
+func (cart *Cartridge) Validate () error {
+    if len(cart.Title) > titleSize {
+        return fmt.Errorf("Invalid cartridge: title longer than %d bytes", titleSize)
+    }
+    if cart.Entry >= memSize || cart.Origin >= memSize {
+        return fmt.Errorf("Invalid cartridge: addresses out of memory")
+    }
+    if len(cart.Tiles) == 0 || len(cart.Tiles) > 0x100 {
+        return fmt.Errorf("Invalid cartridge: expecting 1 to 256 tile banks given %d", len(cart.Tiles))
+    }
+
+    // programs of mappers are split in banks starting at the ROM
+    if cart.Mapper == MapperNone {
+        if cart.Origin + uint(len(cart.Program)) > memSize {
+            return fmt.Errorf(
+                "Invalid cartridge: %d bytes of program do not fit at $%04X", len(cart.Program), cart.Origin)
+        }
+    } else {
+        if cart.Origin != addrROM {
+            return fmt.Errorf("Invalid cartridge: mapper %d expects origin $%04X", cart.Mapper, addrROM)
+        }
+        if _, err := NewMapper(cart); err != nil {return err}
+    }
+
+    for b := range cart.Tiles {
+        if cart.Tiles[b][0].rows != [nbRows]uint16{} {
+            return fmt.Errorf("Invalid cartridge: tile 0 of bank %d must be clear", b)
+        }
+    }
+    for i, pal := range cart.Palettes {
+        for _, color := range pal {
+            if color >= nbColors {
+                return fmt.Errorf("Invalid cartridge: palette %d uses color %d", i, color)
+            }
+        }
+    }
+    for i := range cart.Maps {
+        for c := uint(0); c < nbTiles; c += 1 {
+            _, rot, mir, pal := cart.Maps[i].Get(c)
+            if rot > 0x3F || mir > 0x7 || pal > 0x3 {
+                return fmt.Errorf("Invalid cartridge: invalid attributes in map %d cell %d", i, c)
+            }
+        }
+    }
+    return nil
+}
+
+
+// plug the mapper of the cartridge, copy its content in the console
+//( the console is reset, nothing is kept from the previous game )
+func (con *Console) Insert (cart *Cartridge) error {
+    if err := cart.Validate(); err != nil {return err}
+    mapper, err := NewMapper(cart)
+    if err != nil {return err}
+    if err := con.SetMapper(mapper); err != nil {return err}
+    con.Reset()
+
+    // without mapper the program is copied in memory
+    if cart.Mapper == MapperNone {
+        if err := con.bus.Load(cart.Origin, cart.Program); err != nil {return err}
+    }
+
+    con.ppu.maps = cart.Maps
+    con.ppu.maps[0].Invalidate()
+    con.ppu.maps[1].Invalidate()
+    for i, pal := range cart.Palettes {
+        for c, color := range pal {
+            con.ppu.palettes[i].SetColor(uint(c), uint(color))
+        }
+    }
+    con.cpu.ptr = cart.Entry
+    return nil
+}
+
+
+// command line: vox-legacy run [-state file] [-wav file] [-palette file] [-fit f] game.vox
+func cmdRun (args []string) error {
+    flags := flag.NewFlagSet("run", flag.ContinueOnError)
+    state := flags.String("state", "", "state to restore before running, such as the quick save of F5 (game.state)")
+    wav   := flags.String("wav"  , "", "record the audio in a WAV file")
+    loadPalette := paletteFlags(flags)
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {return fmt.Errorf("usage: vox-legacy run [-state file] [-wav file] [-palette file] [-fit f] game.vox")}
+    if err := loadPalette(); err != nil {return err}
+
+    path := flags.Arg(0)
+    cart, err := LoadCartridge(path)
+    if err != nil {return err}
+    con := NewConsole()
+    if err := con.Insert(cart); err != nil {return err}
+
+    // F5 and F9 save and restore the quick save next to the cartridge
+    quick := strings.TrimSuffix(path, filepath.Ext(path)) + ".state"
+    if *state != "" {
+        if err := con.LoadStateFile(*state); err != nil {return err}
+    }
+
+    // the recording replaces the speakers
+    rec := &AudioRecorder{Rate: con.apu.Rate}
+    if *wav != "" {con.SetAudio(rec)}
+    Play(con, cart.Title, quick)
+    if *wav != "" {return rec.Save(*wav)}
+    return nil
+}
+
+
+// command line: vox-legacy pack [-o game.vox] [-title t] [-entry addr] [-tiles file] source
+func cmdPack (args []string) error {
+    flags  := flag.NewFlagSet("pack", flag.ContinueOnError)
+    output := flags.String("o"    , "game.vox", "cartridge to write")
+    title  := flags.String("title", ""        , "title of the game")
+    entry  := flags.String("entry", ""        , "address of the first instruction (default: start of the program)")
+    tiles  := flags.String("tiles", ""        , "file with one tile per line in HEX (starting at tile 1)")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {
+        return fmt.Errorf("usage: vox-legacy pack [-o game.vox] [-title t] [-entry addr] [-tiles file] source")
+    }
+
+    image, err := AssembleFile(flags.Arg(0))
+    if err != nil {return err}
+
+    // keep the range of the image that is not empty
+    first, last := 0, len(image)
+    for first < last && image[first   ] == 0 {first += 1}
+    for last > first && image[last - 1] == 0 {last  -= 1}
+
+    cart := &Cartridge{Title: *title, Origin: uint(first), Entry: uint(first)}
+    cart.Tiles   = make([]TileBank, 1)
+    cart.Program = image[first:last]
+    if *entry != "" {
+        addr, err := parseNumber(*entry)
+        if err != nil {return err}
+        cart.Entry = uint(addr)
+    }
+    if *tiles != "" {
+        if err := cart.loadTiles(*tiles); err != nil {return err}
+    }
+    return cart.Save(*output)
+}
+
+
+// read tiles in HEX from a file, one per line, empty lines are skipped
+func (cart *Cartridge) loadTiles (path string) error {
+    file, err := os.Open(path)
+    if err != nil {return err}
+    defer file.Close()
+
+    id := 1
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line += 1 {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" {continue}
+        if id >= nbBankTiles {return fmt.Errorf("%s:%d: too many tiles", path, line)}
+        if err := cart.Tiles[0][id].LoadHEX(text); err != nil {
+            return fmt.Errorf("%s:%d: %v", path, line, err)
+        }
+        id += 1
+    }
+    return scanner.Err()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "math/rand"
+    "hash/crc32"
+    "encoding/binary"
+)
+
+
+// a small cartridge using every section
+func testCartridge () *Cartridge {
+    cart := &Cartridge {
+        Title:   "Test",
+        Entry:   addrROM,
+        Origin:  addrROM,
+        Program: []uint8{opLODN, 0x01, opJMP, 0x40, 0x00},
+        Tiles:   make([]TileBank, 1),
+    }
+    cart.Tiles[0][1].rows[0] = 0xFFFF
+    cart.Palettes[2] = [nbColors4Pal]uint8{1, 2, nbColors - 1}
+    cart.Maps[1].Set(77, 1, 0x21, 0x5, 0x3)
+    return cart
+}
+
+
+// compute the checksum again after changing the bytes of a cartridge
+func reseal (data []uint8) []uint8 {
+    n := len(data) - 4
+    binary.BigEndian.PutUint32(data[n:], crc32.ChecksumIEEE(data[:n]))
+    return data
+}
+
+
+func TestCartridgeRoundTrip (t *testing.T) {
+    data, err := testCartridge().Encode()
+    if err != nil {t.Fatal(err)}
+    cart, err := DecodeCartridge(data)
+    if err != nil {t.Fatal(err)}
+    again, err := cart.Encode()
+    if err != nil {t.Fatal(err)}
+    if !bytes.Equal(data, again) {t.Error("decoded cartridge is encoded differently")}
+}
+
+
+func TestDecodeCartridgeErrors (t *testing.T) {
+    valid, err := testCartridge().Encode()
+    if err != nil {t.Fatal(err)}
+    program := headerSize + len(testCartridge().Program) // first byte of the tiles
+    palette := program + bankSize                       // first byte of the palettes
+    cells   := palette + nbPalettes * nbColors4Pal       // first cell of the maps
+
+    cases := []struct {
+        name   string
+        change func (data []uint8) []uint8
+        err    string
+    }{
+        {"too short", func (d []uint8) []uint8 {return d[:headerSize - 1]}, "too short"},
+        {"magic"    , func (d []uint8) []uint8 {d[0] = 'X'; return d}, "bad magic"},
+        {"version"  , func (d []uint8) []uint8 {d[4] = 9; return d}, "unsupported version"},
+        {"truncated", func (d []uint8) []uint8 {return d[:len(d) - 1]}, "expecting"},
+        {"long program", func (d []uint8) []uint8 {d[45] += 1; return d}, "expecting"},
+        {"partial bank", func (d []uint8) []uint8 {
+            return reseal(append(d, make([]uint8, tileSize)...))
+        }, "expecting"},
+        {"checksum" , func (d []uint8) []uint8 {d[len(d) - 1] ^= 1; return d}, "checksum mismatch"},
+        {"program"  , func (d []uint8) []uint8 {d[headerSize] ^= 1; return d}, "checksum mismatch"},
+        {"tile 0"   , func (d []uint8) []uint8 {d[program] = 1; return reseal(d)}, "tile 0 of bank 0 must be clear"},
+        {"tile 0 of a second bank", func (d []uint8) []uint8 {
+            bank := make([]uint8, bankSize)
+            bank[0] = 0x80
+            d = append(d[:program + bankSize], append(bank, d[program + bankSize:]...)...)
+            return reseal(d)
+        }, "tile 0 of bank 1 must be clear"},
+        {"color"    , func (d []uint8) []uint8 {d[palette] = nbColors; return reseal(d)}, "palette 0 uses color 64"},
+        {"rotation" , func (d []uint8) []uint8 {d[cells + 1] = 0x40; return reseal(d)}, "invalid attributes in map 0 cell 0"},
+        {"mirror"   , func (d []uint8) []uint8 {d[cells + 4 * nbTiles + 2] = 0x8; return reseal(d)}, "invalid attributes in map 1 cell 0"},
+        {"palette"  , func (d []uint8) []uint8 {d[cells + 7] = 0x4; return reseal(d)}, "invalid attributes in map 0 cell 1"},
+        {"mapper"   , func (d []uint8) []uint8 {d[5] = 7; return reseal(d)}, "unknown mapper 7"},
+        {"origin"   , func (d []uint8) []uint8 {
+            d[5] = MapperSwitch
+            d[40] = 0
+            return reseal(d)
+        }, "mapper 1 expects origin $4000"},
+    }
+
+    for _, c := range cases {
+        data := c.change(append([]uint8(nil), valid...))
+        _, err := DecodeCartridge(data)
+        if err == nil {
+            t.Errorf("%s: decoded without error", c.name)
+        } else if !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%s: %q does not mention %q", c.name, err, c.err)
+        }
+    }
+}
+
+
+func TestValidateCartridge (t *testing.T) {
+    cases := []struct {
+        name   string
+        change func (cart *Cartridge)
+        err    string
+    }{
+        {"title", func (c *Cartridge) {c.Title = strings.Repeat("x", titleSize + 1)}, "title longer than 32 bytes"},
+        {"entry", func (c *Cartridge) {c.Entry = memSize}, "addresses out of memory"},
+        {"origin", func (c *Cartridge) {c.Origin = memSize}, "addresses out of memory"},
+        {"no bank", func (c *Cartridge) {c.Tiles = nil}, "expecting 1 to 256 tile banks given 0"},
+        {"too many banks", func (c *Cartridge) {c.Tiles = make([]TileBank, 0x101)}, "expecting 1 to 256 tile banks given 257"},
+        {"program", func (c *Cartridge) {c.Origin = memSize - 2}, "5 bytes of program do not fit at $FFFE"},
+        {"bank size", func (c *Cartridge) {c.Mapper = MapperMulti}, "program must be a multiple of 8192 bytes"},
+        {"tile 0", func (c *Cartridge) {c.Tiles[0][0].rows[nbRows - 1] = 1}, "tile 0 of bank 0 must be clear"},
+        {"color", func (c *Cartridge) {c.Palettes[7][2] = 0xFF}, "palette 7 uses color 255"},
+        {"map", func (c *Cartridge) {c.Maps[1].Set(nbTiles - 1, 0, 0, 0, 0x4)}, "invalid attributes in map 1 cell 4095"},
+    }
+
+    for _, c := range cases {
+        cart := testCartridge()
+        c.change(cart)
+        err := cart.Validate()
+        if err == nil {
+            t.Errorf("%s: validated", c.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%s: %q does not mention %q", c.name, err, c.err)
+        }
+        if _, err := cart.Encode(); err == nil {
+            t.Errorf("%s: encoded", c.name)
+        }
+    }
+}
+
+
+// decoding never panics and a decoded cartridge is encoded into the same bytes
+//( random corruptions of a valid cartridge, resealed or not, go 1.13 has no fuzzing )
+func TestDecodeCorrupted (t *testing.T) {
+    valid, err := testCartridge().Encode()
+    if err != nil {t.Fatal(err)}
+
+    rng := rand.New(rand.NewSource(8))
+    for i := 0; i < 5000; i += 1 {
+        data := append([]uint8{}, valid...)
+        for n := 1 + rng.Intn(4); n > 0; n -= 1 {
+            at := rng.Intn(len(data))
+            switch rng.Intn(3) {
+            case 0: data[at] ^= 1 << uint(rng.Intn(8))
+            case 1: data[at]  = uint8(rng.Intn(0x100))
+            case 2: data      = data[:at]
+            }
+            if len(data) == 0 {break}
+        }
+        if len(data) >= 4 && rng.Intn(4) != 0 {data = reseal(data)}
+
+        cart, err := DecodeCartridge(data)
+        if err != nil {continue}
+        again, err := cart.Encode()
+        if err != nil {t.Fatalf("corruption %d: decoded cartridge cannot be encoded: %v", i, err)}
+        if !bytes.Equal(data, again) {t.Fatalf("corruption %d: decoded cartridge is encoded differently", i)}
+    }
+}
+
+
+// a cartridge inserted after another game starts as on a new console
+func TestInsertResets (t *testing.T) {
+    image, err := Assemble("game", `
+        .org $4000
+        LOD A, #$55
+        STR A, $0100        ; RAM
+        PSH A
+        STR A, $2013        ; SX of sprite 0
+        STR A, $2001        ; SCROLLX
+        LOD A, #$0F
+        STR A, $3110        ; APU
+        STR A, $3103
+        SET C
+loop:   JMP loop
+`)
+    if err != nil {t.Fatal(err)}
+    game := &Cartridge{Entry: addrROM, Origin: addrROM, Program: image[addrROM:], Tiles: make([]TileBank, 1)}
+
+    used := NewConsole()
+    if err := used.Insert(game); err != nil {t.Fatal(err)}
+    used.input.SetButtons(1, ButtonA)
+    for i := 0; i < 3; i += 1 {
+        if err := used.Frame(); err != nil {t.Fatal(err)}
+    }
+    if used.ram.data[0x100] != 0x55 || used.stack.ptr != 1 || used.ppu.sprites[0].pos.x != 0x55 {
+        t.Fatal("the first game did not run")
+    }
+
+    fresh := NewConsole()
+    for _, con := range []*Console{used, fresh} {
+        if err := con.Insert(testCartridge()); err != nil {t.Fatal(err)}
+    }
+    var a, b bytes.Buffer
+    if err := used.SaveState(&a); err != nil {t.Fatal(err)}
+    if err := fresh.SaveState(&b); err != nil {t.Fatal(err)}
+    if !bytes.Equal(a.Bytes(), b.Bytes()) {t.Error("state kept from the previous game")}
+}
@@ -0,0 +1,176 @@
+package main
+
+/*
+    Collisions of the sprites with each other and with the tile maps,
+    computed at the start of every frame from the scene drawn last
+
+    box    the boxes around the voxels of the oriented tiles overlap
+    voxel  a voxel of the sprite is at the same place as another voxel
+
+    only the voxels inside the screen touch the tile maps, positions
+    wrap around 256 s.t. sprites touch across the edges of the screen
+*/
+
+
+type Precision int
+
+const (
+    PrecisionBox Precision = iota
+    PrecisionVoxel
+)
+
+
+// sprites touching each other and the tile maps
+type Collisions struct {
+    Sprites [nbSprites]uint64 // bit j of Sprites[i] when sprite i touches sprite j
+    Map     uint64            // bit i when sprite i touches the tile maps
+}
+
+
+// sprite i touches sprite j
+func (c *Collisions) SpriteHit (i, j int) bool {
+    return c.Sprites[i] >> uint(j) & 0x1 != 0
+}
+
+// sprite i touches the tile maps
+func (c *Collisions) MapHit (i int) bool {
+    return c.Map >> uint(i) & 0x1 != 0
+}
+
+// any sprite touches something
+func (c *Collisions) Any () bool {
+    if c.Map != 0 {return true}
+    for _, s := range c.Sprites {
+        if s != 0 {return true}
+    }
+    return false
+}
+
+
+// voxels of a sprite once oriented and the box around them
+type spriteShape struct {
+    tile   Tile
+    lo, hi [3]int
+    pos    [3]int
+}
+
+
+// tiles oriented for a cell or a sprite, each is built once
+type orientCache map[orientedTile]*Tile
+
+func (cache orientCache) get (tile *Tile, rot Byte3, mir Bool3) *Tile {
+    index, mirrored := Orientation(rot, mir)
+    key := orientedTile{tile, index, mirrored}
+    if cache[key] == nil {
+        oriented := tile.Orient(OrientationRot(index), Bool3{x: mirrored})
+        cache[key] = &oriented
+    }
+    return cache[key]
+}
+
+
+// shape of an active sprite, false when it has no voxel
+func (ppu *PPU) spriteShape (i int, cache orientCache) (spriteShape, bool) {
+    sprite := &ppu.sprites[i]
+    shape  := spriteShape{lo: [3]int{8, 8, 8}, hi: [3]int{-1, -1, -1}}
+    if sprite.id_tile == 0 {return shape, false}
+
+    shape.tile = *cache.get(&ppu.Tiles()[sprite.id_tile % nbBankTiles], sprite.rot, sprite.mir)
+    shape.pos  = [3]int{int(sprite.pos.x & 0xFF), int(sprite.pos.y & 0xFF), int(sprite.pos.z & 0xFF)}
+    for z := 0; z < 8; z += 1 {
+    for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+        if shape.tile.GetVoxel(x, y, z) == 0 {continue}
+        for a, v := range [3]int{x, y, z} {
+            if v < shape.lo[a] {shape.lo[a] = v}
+            if v > shape.hi[a] {shape.hi[a] = v}
+        }
+    }}}
+    return shape, shape.hi[0] >= 0
+}
+
+
+// offset of b from a on every axis, wrapping around 256
+func wrapOffset (a, b [3]int) [3]int {
+    var d [3]int
+    for i := range d {
+        d[i] = (b[i] - a[i]) & 0xFF
+        if d[i] >= 0x80 {d[i] -= 0x100}
+    }
+    return d
+}
+
+
+// two sprites touch
+func (a *spriteShape) touches (b *spriteShape, precision Precision) bool {
+    d := wrapOffset(a.pos, b.pos)
+    for i := range d {
+        if b.lo[i] + d[i] > a.hi[i] || b.hi[i] + d[i] < a.lo[i] {return false}
+    }
+    if precision == PrecisionBox {return true}
+
+    for z := a.lo[2]; z <= a.hi[2]; z += 1 {
+    for y := a.lo[1]; y <= a.hi[1]; y += 1 {
+    for x := a.lo[0]; x <= a.hi[0]; x += 1 {
+        if a.tile.GetVoxel(x, y, z) != 0 && b.tile.GetVoxel(x - d[0], y - d[1], z - d[2]) != 0 {return true}
+    }}}
+    return false
+}
+
+
+// color of the voxel of the tile maps seen at a position of the screen
+func (ppu *PPU) mapVoxel (p [3]int, cache orientCache) uint {
+    // position in the checkerboard of the maps moved by the scrolling
+    var q [3]uint
+    for i, s := range [3]uint{ppu.scroll.x, ppu.scroll.y, ppu.scroll.z} {
+        q[i] = (uint(p[i]) - s) & 0xFF
+    }
+    tm := &ppu.maps[0]
+    if (q[0] >> 7 + q[1] >> 7 + q[2] >> 7) % 2 != 0 {tm = &ppu.maps[1]}
+
+    cell := (q[2] & 0x7F) >> 3 << 8 | (q[1] & 0x7F) >> 3 << 4 | (q[0] & 0x7F) >> 3
+    til, rot, mir, _ := tm.Get(cell)
+    if til == 0 {return 0}
+    var r3 Byte3; r3.SetByte(rot)
+    var m3 Bool3; m3.SetByte(mir)
+    tile := cache.get(&ppu.Tiles()[til], r3, m3)
+    return tile.GetVoxel(int(q[0] & 0x7), int(q[1] & 0x7), int(q[2] & 0x7))
+}
+
+
+// a sprite touches the solid voxels of the tile maps inside the screen
+func (ppu *PPU) touchesMap (s *spriteShape, precision Precision, cache orientCache) bool {
+    for z := s.lo[2]; z <= s.hi[2]; z += 1 {
+    for y := s.lo[1]; y <= s.hi[1]; y += 1 {
+    for x := s.lo[0]; x <= s.hi[0]; x += 1 {
+        if precision == PrecisionVoxel && s.tile.GetVoxel(x, y, z) == 0 {continue}
+        p := [3]int{(s.pos[0] + x) & 0xFF, (s.pos[1] + y) & 0xFF, (s.pos[2] + z) & 0xFF}
+        if p[0] >= screenSize || p[1] >= screenSize || p[2] >= screenSize {continue}
+        if ppu.mapVoxel(p, cache) != 0 {return true}
+    }}}
+    return false
+}
+
+
+// find the sprites touching each other and the tile maps
+func (ppu *PPU) Collide (precision Precision) Collisions {
+    var c Collisions
+    cache  := make(orientCache)
+    shapes := make([]spriteShape, nbSprites)
+    active := make([]bool, nbSprites)
+    for i := range shapes {
+        shapes[i], active[i] = ppu.spriteShape(i, cache)
+    }
+
+    for i := range shapes {
+        if !active[i] {continue}
+        for j := i + 1; j < nbSprites; j += 1 {
+            if active[j] && shapes[i].touches(&shapes[j], precision) {
+                c.Sprites[i] |= 1 << uint(j)
+                c.Sprites[j] |= 1 << uint(i)
+            }
+        }
+        if ppu.touchesMap(&shapes[i], precision, cache) {c.Map |= 1 << uint(i)}
+    }
+    return c
+}
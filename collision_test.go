@@ -0,0 +1,147 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// tiles of the collision tests
+//( 1 a voxel at the corner, 2 two voxels at opposite corners, 3 a full cube )
+func collisionTiles () *TileBank {
+    tiles := &TileBank{}
+    tiles[1].SetVoxel(0, 0, 0, 1)
+    tiles[2].SetVoxel(0, 0, 0, 1)
+    tiles[2].SetVoxel(7, 7, 7, 2)
+    for z := 0; z < 8; z += 1 {
+    for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+        tiles[3].SetVoxel(x, y, z, 3)
+    }}}
+    return tiles
+}
+
+
+// a sprite placed for a collision test
+type colSprite struct {
+    index   int
+    tile    uint
+    x, y, z uint
+}
+
+
+// PPU with the sprites and a cube in the cell (2, 2, 2) of both maps,
+// the one of map 1 is outside the screen at (144, 16, 16)
+func collisionPPU (sprites []colSprite) *PPU {
+    ppu := &PPU{}
+    ppu.bank = &mapperNone{ROM{}, collisionTiles()}
+    ppu.maps[0].Set(2 << 8 | 2 << 4 | 2, 3, 0, 0, 0)
+    ppu.maps[1].Set(2 << 8 | 2 << 4 | 2, 3, 0, 0, 0)
+    for _, s := range sprites {
+        ppu.sprites[s.index].id_tile = s.tile
+        ppu.sprites[s.index].pos     = Vector3{s.x, s.y, s.z}
+    }
+    return ppu
+}
+
+
+// sprites touch each other when their boxes or their voxels overlap,
+// across the edges of the screen, and touch the solid voxels of the maps
+func TestCollide (t *testing.T) {
+    cases := []struct {
+        name      string
+        sprites   []colSprite
+        box       [][2]int // pairs of sprites touching with boxes
+        voxel     [][2]int // pairs of sprites touching with voxels
+        boxMap    []int    // sprites touching the map with boxes
+        voxelMap  []int
+    }{
+        {"apart", []colSprite{{0, 3, 40, 40, 40}, {1, 3, 60, 40, 40}}, nil, nil, nil, nil},
+        {"overlap", []colSprite{{0, 3, 40, 40, 40}, {1, 3, 47, 47, 47}}, [][2]int{{0, 1}}, [][2]int{{0, 1}}, nil, nil},
+        {"side by side", []colSprite{{0, 3, 40, 40, 40}, {1, 3, 48, 40, 40}}, nil, nil, nil, nil},
+        {"hidden sprite", []colSprite{{0, 3, 40, 40, 40}, {1, 0, 40, 40, 40}}, nil, nil, nil, nil},
+        {"across x", []colSprite{{2, 3, 252, 40, 40}, {7, 3, 3, 40, 40}}, [][2]int{{2, 7}}, [][2]int{{2, 7}}, nil, nil},
+        {"across y", []colSprite{{2, 3, 40, 250, 40}, {7, 1, 40, 1, 40}}, [][2]int{{2, 7}}, [][2]int{{2, 7}}, nil, nil},
+        {"not across", []colSprite{{2, 3, 250, 40, 40}, {7, 3, 2, 40, 40}}, nil, nil, nil, nil},
+        {"inside the box", []colSprite{{0, 2, 40, 40, 40}, {1, 1, 43, 43, 43}}, [][2]int{{0, 1}}, nil, nil, nil},
+        {"corner voxels", []colSprite{{0, 2, 40, 40, 40}, {1, 1, 47, 47, 47}}, [][2]int{{0, 1}}, [][2]int{{0, 1}}, nil, nil},
+        {"three sprites", []colSprite{{0, 3, 40, 40, 40}, {5, 3, 44, 40, 40}, {9, 3, 50, 40, 40}},
+            [][2]int{{0, 5}, {5, 9}}, [][2]int{{0, 5}, {5, 9}}, nil, nil},
+        {"map", []colSprite{{4, 1, 20, 20, 20}, {6, 1, 24, 20, 20}}, nil, nil, []int{4}, []int{4}},
+        {"map box", []colSprite{{4, 2, 9, 9, 20}}, nil, nil, []int{4}, nil},
+        {"map outside the screen", []colSprite{{4, 3, 144, 16, 16}}, nil, nil, nil, nil},
+    }
+    for _, c := range cases {
+        for _, precision := range []Precision{PrecisionBox, PrecisionVoxel} {
+            pairs, touch := c.box, c.boxMap
+            if precision == PrecisionVoxel {pairs, touch = c.voxel, c.voxelMap}
+            var want Collisions
+            for _, p := range pairs {
+                want.Sprites[p[0]] |= 1 << uint(p[1])
+                want.Sprites[p[1]] |= 1 << uint(p[0])
+            }
+            for _, i := range touch {want.Map |= 1 << uint(i)}
+
+            got := collisionPPU(c.sprites).Collide(precision)
+            if got != want {t.Errorf("%s, precision %d: %+v instead of %+v", c.name, precision, got, want)}
+            for _, p := range pairs {
+                if !got.SpriteHit(p[0], p[1]) || !got.SpriteHit(p[1], p[0]) {t.Errorf("%s: %v do not touch", c.name, p)}
+            }
+            if got.Any() != (len(pairs) + len(touch) > 0) {t.Errorf("%s, precision %d: any %v", c.name, precision, got.Any())}
+        }
+    }
+}
+
+
+// offsets between positions take the shortest way around 256
+func TestWrapOffset (t *testing.T) {
+    cases := []struct {
+        a, b, want [3]int
+    }{
+        {[3]int{0, 0, 0}    , [3]int{5, 0, 0}    , [3]int{5, 0, 0}},
+        {[3]int{5, 0, 0}    , [3]int{0, 0, 0}    , [3]int{-5, 0, 0}},
+        {[3]int{250, 0, 0}  , [3]int{2, 0, 0}    , [3]int{8, 0, 0}},
+        {[3]int{2, 0, 0}    , [3]int{250, 0, 0}  , [3]int{-8, 0, 0}},
+        {[3]int{0, 255, 128}, [3]int{0, 0, 0}    , [3]int{0, 1, -128}},
+        {[3]int{0, 0, 0}    , [3]int{127, 128, 0}, [3]int{127, -128, 0}},
+    }
+    for _, c := range cases {
+        if d := wrapOffset(c.a, c.b); d != c.want {t.Errorf("%v to %v: %v instead of %v", c.a, c.b, d, c.want)}
+    }
+}
+
+
+// COLSTAT reports the first other sprite touched, $3F when there is none
+func TestColStat (t *testing.T) {
+    ppu := collisionPPU([]colSprite{
+        {0, 3, 40, 40, 40}, {3, 3, 44, 44, 44},                    // 0 and 3 touch
+        {5, 3, 80, 40, 40}, {9, 3, 84, 40, 40}, {63, 3, 88, 40, 40}, // 5-9 and 9-63 touch
+        {12, 1, 20, 20, 20},                                        // on the map
+        {20, 3, 100, 100, 100},                                     // alone
+    })
+    ppu.Write(regControl, 0x1)
+    ppu.UpdateCollisions()
+    cases := []struct {
+        sprite uint8
+        status uint8
+    }{
+        {0 , 0x80 | 3},
+        {3 , 0x80 | 0},
+        {5 , 0x80 | 9},
+        {9 , 0x80 | 5},
+        {63, 0x80 | 9},
+        {12, 0x40 | colNone},
+        {20, colNone},
+        {30, colNone}, // hidden
+    }
+    for _, c := range cases {
+        ppu.Write(regColSel, c.sprite)
+        if status := ppu.Read(regColStat); status != c.status {
+            t.Errorf("sprite %d: COLSTAT $%02X instead of $%02X", c.sprite, status, c.status)
+        }
+    }
+    if status := ppu.Read(regStatus); status & 0x40 == 0 {t.Errorf("STATUS $%02X without collision", status)}
+
+    ppu = collisionPPU([]colSprite{{20, 3, 100, 100, 100}})
+    ppu.UpdateCollisions()
+    if status := ppu.Read(regStatus); status & 0x40 != 0 {t.Errorf("STATUS $%02X with a collision", status)}
+}
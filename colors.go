@@ -0,0 +1,427 @@
+package main
+
+/*
+    Loaders of the master colors from palette files
+
+    .gpl   GIMP palette, "R G B name" per line after the header
+    .pal   JASC palette, "JASC-PAL", "0100", count then "R G B" per line
+    .ase   Adobe swatch exchange, RGB, gray and CMYK colors (big endian)
+    .png   swatch strip, the opaque swatches read row by row, a swatch
+           is the largest cell dividing the runs of identical pixels
+    .hex   Lospec list, one rrggbb per line
+    .txt   paint.net palette, one aarrggbb per line after the ; comments
+
+    the console needs exactly 64 colors, smaller tables can be padded
+    with black and larger ones quantized by a median cut
+*/
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "bufio"
+    "bytes"
+    "math"
+    "strconv"
+    "strings"
+    "image"
+    "image/png"
+    "image/color"
+    "io/ioutil"
+    "unicode/utf16"
+    "encoding/binary"
+    "path/filepath"
+)
+
+
+// how to get 64 colors from a table of another size
+type ColorFit int
+
+const (
+    FitExact    ColorFit = iota // any other count is an error
+    FitPad                      // missing colors are black
+    FitQuantize                 // too many colors are merged, missing ones are black
+)
+
+
+var colorFits = map[string]ColorFit {
+    "exact"   : FitExact,
+    "pad"     : FitPad,
+    "quantize": FitQuantize,
+}
+
+
+// read the colors of a palette file, the format is given by the extension
+func ReadColorTable (r io.Reader, ext string) ([]color.RGBA, error) {
+    data, err := ioutil.ReadAll(r)
+    if err != nil {return nil, err}
+
+    switch strings.ToLower(ext) {
+    case ".gpl":
+        return readGPL(data)
+    case ".pal":
+        return readJASC(data)
+    case ".ase":
+        return readASE(data)
+    case ".png":
+        img, err := png.Decode(bytes.NewReader(data))
+        if err != nil {return nil, err}
+        return readSwatches(img), nil
+    case ".hex":
+        return readHexList(data)
+    case ".txt":
+        return readPaintNet(data)
+    }
+    return nil, fmt.Errorf("Invalid palette format %q: expecting .gpl, .pal, .ase, .png, .hex or .txt", ext)
+}
+
+
+// lines of a text palette without blank lines and comments
+func paletteLines (data []uint8) []string {
+    var lines []string
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {continue}
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+
+// read 3 components between 0 and 255
+func parseRGB (fields []string) (color.RGBA, error) {
+    var c [3]uint8
+    if len(fields) < 3 {return color.RGBA{}, fmt.Errorf("expecting 3 components given %d", len(fields))}
+    for i := range c {
+        v, err := strconv.ParseUint(fields[i], 10, 8)
+        if err != nil {return color.RGBA{}, fmt.Errorf("%q is not a component (0-255)", fields[i])}
+        c[i] = uint8(v)
+    }
+    return color.RGBA{c[0], c[1], c[2], 0xFF}, nil
+}
+
+
+func readGPL (data []uint8) ([]color.RGBA, error) {
+    lines := paletteLines(data)
+    if len(lines) == 0 || lines[0] != "GIMP Palette" {
+        return nil, fmt.Errorf("Invalid gpl: missing header GIMP Palette")
+    }
+
+    var colors []color.RGBA
+    for i, line := range lines[1:] {
+        if strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") {continue}
+        c, err := parseRGB(strings.Fields(line))
+        if err != nil {return nil, fmt.Errorf("Invalid gpl: color %d: %v", i, err)}
+        colors = append(colors, c)
+    }
+    return colors, nil
+}
+
+
+func readJASC (data []uint8) ([]color.RGBA, error) {
+    lines := paletteLines(data)
+    if len(lines) < 3 || lines[0] != "JASC-PAL" {
+        return nil, fmt.Errorf("Invalid pal: missing header JASC-PAL")
+    }
+    count, err := strconv.Atoi(lines[2])
+    if err != nil {return nil, fmt.Errorf("Invalid pal: %q is not a count", lines[2])}
+    if count != len(lines) - 3 {
+        return nil, fmt.Errorf("Invalid pal: expecting %d colors given %d", count, len(lines) - 3)
+    }
+
+    colors := make([]color.RGBA, count)
+    for i := range colors {
+        if colors[i], err = parseRGB(strings.Fields(lines[3 + i])); err != nil {
+            return nil, fmt.Errorf("Invalid pal: color %d: %v", i, err)
+        }
+    }
+    return colors, nil
+}
+
+
+func readASE (data []uint8) ([]color.RGBA, error) {
+    if len(data) < 12 || string(data[:4]) != "ASEF" {
+        return nil, fmt.Errorf("Invalid ase: bad magic")
+    }
+    count := binary.BigEndian.Uint32(data[8:])
+    data   = data[12:]
+
+    var colors []color.RGBA
+    for b := uint32(0); b < count; b += 1 {
+        if len(data) < 6 {return nil, fmt.Errorf("Invalid ase: truncated block %d", b)}
+        kind := binary.BigEndian.Uint16(data)
+        size := binary.BigEndian.Uint32(data[2:])
+        if uint64(size) > uint64(len(data) - 6) {return nil, fmt.Errorf("Invalid ase: truncated block %d", b)}
+        block := data[6:6 + size]
+        data   = data[6 + size:]
+
+        // groups only contain colors, they are flattened
+        if kind != 0x0001 {continue}
+
+        if len(block) < 2 {return nil, fmt.Errorf("Invalid ase: truncated color %d", len(colors))}
+        name := int(binary.BigEndian.Uint16(block)) * 2
+        if len(block) < 2 + name + 4 {return nil, fmt.Errorf("Invalid ase: truncated color %d", len(colors))}
+        model  := string(block[2 + name:2 + name + 4])
+        values := block[2 + name + 4:]
+
+        comps := map[string]int{"RGB ": 3, "Gray": 1, "CMYK": 4}[model]
+        if comps == 0 {
+            return nil, fmt.Errorf("Invalid ase: color %q uses the unsupported model %q", aseName(block[2:2 + name]), model)
+        }
+        if len(values) < comps * 4 {return nil, fmt.Errorf("Invalid ase: truncated color %d", len(colors))}
+        var v [4]float64
+        for i := 0; i < comps; i += 1 {
+            v[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(values[i * 4:])))
+        }
+
+        var rgb [3]float64
+        switch model {
+        case "RGB ":
+            copy(rgb[:], v[:3])
+        case "Gray":
+            rgb = [3]float64{v[0], v[0], v[0]}
+        case "CMYK":
+            for i := range rgb {rgb[i] = (1 - v[i]) * (1 - v[3])}
+        }
+        var c [3]uint8
+        for i := range c {c[i] = uint8(math.Round(math.Max(0, math.Min(1, rgb[i])) * 255))}
+        colors = append(colors, color.RGBA{c[0], c[1], c[2], 0xFF})
+    }
+    return colors, nil
+}
+
+
+// name of an ase color, UTF-16 ending with a null character
+func aseName (data []uint8) string {
+    units := make([]uint16, 0, len(data) / 2)
+    for i := 0; i + 1 < len(data); i += 2 {
+        if u := binary.BigEndian.Uint16(data[i:]); u != 0 {units = append(units, u)}
+    }
+    return string(utf16.Decode(units))
+}
+
+
+// colors of the opaque swatches of an image, row by row, repeated colors included
+//( a swatch is the largest cell dividing every run of identical pixels
+//  along the rows and the columns, its top left pixel gives its color )
+func readSwatches (img image.Image) []color.RGBA {
+    bounds := img.Bounds()
+    w, h   := bounds.Dx(), bounds.Dy()
+    at := func (x, y int) color.RGBA {
+        return color.RGBAModel.Convert(img.At(bounds.Min.X + x, bounds.Min.Y + y)).(color.RGBA)
+    }
+    if w == 0 || h == 0 {return nil}
+
+    cw, ch := w, h
+    for y := 0; y < h; y += 1 {
+        run := 1
+        for x := 1; x <= w; x += 1 {
+            if x < w && at(x, y) == at(x - 1, y) {run += 1; continue}
+            cw, run = gcd(cw, run), 1
+        }
+    }
+    for x := 0; x < w; x += 1 {
+        run := 1
+        for y := 1; y <= h; y += 1 {
+            if y < h && at(x, y) == at(x, y - 1) {run += 1; continue}
+            ch, run = gcd(ch, run), 1
+        }
+    }
+
+    var colors []color.RGBA
+    for y := 0; y < h; y += ch {
+    for x := 0; x < w; x += cw {
+        if c := at(x, y); c.A == 0xFF {colors = append(colors, c)}
+    }}
+    return colors
+}
+
+
+func gcd (a, b int) int {
+    for b != 0 {a, b = b, a % b}
+    return a
+}
+
+
+func readHexList (data []uint8) ([]color.RGBA, error) {
+    var colors []color.RGBA
+    for _, line := range strings.Fields(strings.Replace(string(data), ",", " ", -1)) {
+        line = strings.TrimPrefix(line, "#")
+        if len(line) == 0 || len(line) % 6 != 0 {
+            return nil, fmt.Errorf("Invalid hex: %q is not rrggbb", line)
+        }
+        // colors written one after the other as read by LoadColors
+        for ; line != ""; line = line[6:] {
+            v, err := strconv.ParseUint(line[:6], 16, 24)
+            if err != nil {return nil, fmt.Errorf("Invalid hex: %q is not rrggbb", line[:6])}
+            colors = append(colors, color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF})
+        }
+    }
+    return colors, nil
+}
+
+
+// the alpha of the colors is dropped, the console has no transparent color
+func readPaintNet (data []uint8) ([]color.RGBA, error) {
+    var colors []color.RGBA
+    for _, line := range paletteLines(data) {
+        v, err := strconv.ParseUint(line, 16, 32)
+        if err != nil || len(line) != 8 {return nil, fmt.Errorf("Invalid txt: %q is not aarrggbb", line)}
+        colors = append(colors, color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF})
+    }
+    return colors, nil
+}
+
+
+// reduce the colors with a median cut, the order of the table is kept
+func quantizeColors (colors []color.RGBA, count int) []color.RGBA {
+    type box struct {indices []int}
+    comp := func (c color.RGBA, axis int) int {return int([3]uint8{c.R, c.G, c.B}[axis])}
+
+    all := make([]int, len(colors))
+    for i := range all {all[i] = i}
+    boxes := []box{{all}}
+    for len(boxes) < count {
+        // split the box with the largest range along that axis
+        best, axis, spread := -1, 0, -1
+        for b := range boxes {
+            if len(boxes[b].indices) < 2 {continue}
+            for a := 0; a < 3; a += 1 {
+                lo, hi := 255, 0
+                for _, i := range boxes[b].indices {
+                    v := comp(colors[i], a)
+                    if v < lo {lo = v}
+                    if v > hi {hi = v}
+                }
+                if hi - lo > spread {best, axis, spread = b, a, hi - lo}
+            }
+        }
+        if best < 0 {break}
+
+        indices := append([]int(nil), boxes[best].indices...)
+        sort.SliceStable(indices, func (i, j int) bool {
+            return comp(colors[indices[i]], axis) < comp(colors[indices[j]], axis)
+        })
+        half := len(indices) / 2
+        boxes[best] = box{indices[:half]}
+        boxes = append(boxes, box{indices[half:]})
+    }
+
+    // every box gives its mean color, placed where its first color was
+    sort.Slice(boxes, func (i, j int) bool {
+        return minIndex(boxes[i].indices) < minIndex(boxes[j].indices)
+    })
+    out := make([]color.RGBA, len(boxes))
+    for b := range boxes {
+        var sum [3]int
+        for _, i := range boxes[b].indices {
+            for a := range sum {sum[a] += comp(colors[i], a)}
+        }
+        n := len(boxes[b].indices)
+        out[b] = color.RGBA{uint8((sum[0] + n / 2) / n), uint8((sum[1] + n / 2) / n), uint8((sum[2] + n / 2) / n), 0xFF}
+    }
+    return out
+}
+
+
+func minIndex (indices []int) int {
+    m := indices[0]
+    for _, i := range indices {
+        if i < m {m = i}
+    }
+    return m
+}
+
+
+// bring a table to the 64 colors of the console
+func FitColors (colors []color.RGBA, fit ColorFit) ([]color.RGBA, error) {
+    if len(colors) > nbColors {
+        if fit != FitQuantize {
+            return nil, fmt.Errorf(
+                "Cannot load colors: expecting %d given %d, quantize them to fit", nbColors, len(colors))
+        }
+        colors = quantizeColors(colors, nbColors)
+    }
+    if len(colors) < nbColors {
+        if fit == FitExact {
+            return nil, fmt.Errorf(
+                "Cannot load colors: expecting %d given %d, pad them to fit", nbColors, len(colors))
+        }
+        black := color.RGBA{0, 0, 0, 0xFF}
+        for len(colors) < nbColors {colors = append(colors, black)}
+    }
+    return colors, nil
+}
+
+
+// replace the master colors
+//( palettes already set keep their old colors until set again )
+func SetColors (colors []color.RGBA) error {
+    if len(colors) != nbColors {
+        return fmt.Errorf("Cannot construct colors: expecting %d given %d", nbColors, len(colors))
+    }
+    for i, c := range colors {
+        Colors[i * 3    ] = float32(c.R) / 255.0
+        Colors[i * 3 + 1] = float32(c.G) / 255.0
+        Colors[i * 3 + 2] = float32(c.B) / 255.0
+    }
+    return nil
+}
+
+
+// read a palette file and fit its colors to the console
+func LoadColorFile (path string, fit ColorFit) ([]color.RGBA, error) {
+    file, err := os.Open(path)
+    if err != nil {return nil, err}
+    defer file.Close()
+
+    colors, err := ReadColorTable(file, filepath.Ext(path))
+    if err == nil {colors, err = FitColors(colors, fit)}
+    if err != nil {return nil, fmt.Errorf("%s: %v", path, err)}
+    return colors, nil
+}
+
+
+// flags of a command using a master palette, the returned function
+// loads it once the flags are parsed
+func paletteFlags (flags *flag.FlagSet) func () error {
+    path := flags.String("palette", ""     , "file of the 64 master colors (.gpl, .pal, .ase, .png, .hex, .txt)")
+    fit  := flags.String("fit"    , "exact", "how to fit other counts of colors: exact, pad or quantize")
+    return func () error {
+        mode, ok := colorFits[*fit]
+        if !ok {return fmt.Errorf("Invalid fit %q: expecting exact, pad or quantize", *fit)}
+        if *path == "" {return nil}
+        colors, err := LoadColorFile(*path, mode)
+        if err != nil {return err}
+        return SetColors(colors)
+    }
+}
+
+
+// command line: vox-legacy colors [-fit exact|pad|quantize] [-o colors.hex] palette
+func cmdColors (args []string) error {
+    flags  := flag.NewFlagSet("colors", flag.ContinueOnError)
+    fit    := flags.String("fit", "exact", "how to fit other counts of colors: exact, pad or quantize")
+    output := flags.String("o"  , ""     , "write the colors as the HEX string read by LoadColors")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {return fmt.Errorf("usage: vox-legacy colors [-fit exact|pad|quantize] [-o colors.hex] palette")}
+
+    mode, ok := colorFits[*fit]
+    if !ok {return fmt.Errorf("Invalid fit %q: expecting exact, pad or quantize", *fit)}
+    colors, err := LoadColorFile(flags.Arg(0), mode)
+    if err != nil {return err}
+
+    var sb strings.Builder
+    for i, c := range colors {
+        fmt.Fprintf(&sb, "%02X%02X%02X", c.R, c.G, c.B)
+        if *output == "" && i % 8 == 7 {sb.WriteString("\n")}
+    }
+    if *output == "" {
+        fmt.Print(sb.String())
+        return nil
+    }
+    return ioutil.WriteFile(*output, []uint8(sb.String()), 0644)
+}
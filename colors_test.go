@@ -0,0 +1,255 @@
+package main
+
+import (
+    "os"
+    "bytes"
+    "strings"
+    "testing"
+    "image"
+    "image/png"
+    "image/color"
+    "io/ioutil"
+    "unicode/utf16"
+    "encoding/binary"
+    "path/filepath"
+)
+
+
+// palettes downloaded from Lospec as .hex and as paint.net .txt
+func TestReadLospecPalettes (t *testing.T) {
+    want := []color.RGBA{{0x00, 0x00, 0x00, 0xFF}, {0x1D, 0x2B, 0x53, 0xFF}, {0x7E, 0x25, 0x53, 0xFF}}
+    cases := []struct {
+        ext  string
+        text string
+    }{
+        {".hex", "000000\n1d2b53\n7e2553\n"},
+        {".txt", ";paint.net Palette File\r\n;Downloaded from Lospec.com/palette-list\r\n" +
+                 ";Palette Name: PICO-8\r\n;Colors: 3\r\nFF000000\r\nFF1D2B53\r\nff7e2553\r\n"},
+        {".txt", "80000000\n001D2B53\n\nFF7E2553"}, // alpha is dropped
+    }
+    for _, c := range cases {
+        colors, err := ReadColorTable(bytes.NewReader([]uint8(c.text)), c.ext)
+        if err != nil {t.Errorf("%s: %v", c.ext, err); continue}
+        if len(colors) != len(want) {t.Errorf("%s: %d colors instead of %d", c.ext, len(colors), len(want)); continue}
+        for i := range want {
+            if colors[i] != want[i] {t.Errorf("%s: color %d is %v instead of %v", c.ext, i, colors[i], want[i])}
+        }
+    }
+
+    invalid := []struct {
+        ext  string
+        text string
+    }{
+        {".txt", "1D2B53\n"},   // no alpha
+        {".txt", "FF1D2B5G\n"},
+        {".txt", "+FF1D2B5\n"},
+        {".hex", "FF1D2B53\n"},
+    }
+    for _, c := range invalid {
+        if _, err := ReadColorTable(bytes.NewReader([]uint8(c.text)), c.ext); err == nil {
+            t.Errorf("%s: %q read without error", c.ext, c.text)
+        }
+    }
+}
+
+
+// block of an ase file
+func aseBlock (kind uint16, content []uint8) []uint8 {
+    var b bytes.Buffer
+    binary.Write(&b, binary.BigEndian, kind)
+    binary.Write(&b, binary.BigEndian, uint32(len(content)))
+    b.Write(content)
+    return b.Bytes()
+}
+
+
+// color block of an ase file, the name is UTF-16 ending with a null character
+func aseColor (name, model string, values ...float32) []uint8 {
+    var b bytes.Buffer
+    units := append(utf16.Encode([]rune(name)), 0)
+    binary.Write(&b, binary.BigEndian, uint16(len(units)))
+    binary.Write(&b, binary.BigEndian, units)
+    b.WriteString(model)
+    binary.Write(&b, binary.BigEndian, values)
+    binary.Write(&b, binary.BigEndian, uint16(2)) // normal color
+    return aseBlock(0x0001, b.Bytes())
+}
+
+
+func aseFile (blocks ...[]uint8) []uint8 {
+    var b bytes.Buffer
+    b.WriteString("ASEF")
+    binary.Write(&b, binary.BigEndian, [2]uint16{1, 0})
+    binary.Write(&b, binary.BigEndian, uint32(len(blocks)))
+    for _, block := range blocks {b.Write(block)}
+    return b.Bytes()
+}
+
+
+// strip of swatches of a size, the colors given by rows
+func swatchPNG (size int, rows ...[]color.RGBA) []uint8 {
+    img := image.NewRGBA(image.Rect(0, 0, len(rows[0]) * size, len(rows) * size))
+    for y := 0; y < img.Rect.Dy(); y += 1 {
+    for x := 0; x < img.Rect.Dx(); x += 1 {
+        img.SetRGBA(x, y, rows[y / size][x / size])
+    }}
+    var b bytes.Buffer
+    png.Encode(&b, img)
+    return b.Bytes()
+}
+
+
+// every format gives its colors in order, repeated colors included
+func TestReadColorFormats (t *testing.T) {
+    red, green, blue := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0x80, 0, 0xFF}, color.RGBA{0x10, 0x20, 0xFF, 0xFF}
+    clear := color.RGBA{}
+    cases := []struct {
+        name string
+        ext  string
+        data []uint8
+        want []color.RGBA
+    }{
+        {"gpl", ".gpl", []uint8("GIMP Palette\nName: test\nColumns: 4\n# comment\n255   0   0 Red\n  0 128   0\n255 0 0\n16 32 255 Blue\n"),
+            []color.RGBA{red, green, red, blue}},
+        {"pal", ".pal", []uint8("JASC-PAL\r\n0100\r\n3\r\n255 0 0\r\n255 0 0\r\n16 32 255\r\n"),
+            []color.RGBA{red, red, blue}},
+        {"ase", ".ase", aseFile(
+            aseColor("red", "RGB ", 1, 0, 0),
+            aseBlock(0xC001, []uint8{0, 2, 0, 'g', 0, 0}),
+            aseColor("gray", "Gray", 0.5),
+            aseColor("cyan", "CMYK", 1, 0, 0, 0),
+            aseBlock(0xC002, nil),
+            aseColor("red again", "RGB ", 1, 0, 0)),
+            []color.RGBA{red, {0x80, 0x80, 0x80, 0xFF}, {0, 0xFF, 0xFF, 0xFF}, red}},
+        {"png", ".png", swatchPNG(1, []color.RGBA{red, red, green, clear, blue}),
+            []color.RGBA{red, red, green, blue}},
+        {"png of large swatches", ".png", swatchPNG(8, []color.RGBA{red, red, green}, []color.RGBA{blue, clear, blue}),
+            []color.RGBA{red, red, green, blue, blue}},
+        {"png of a single swatch", ".png", swatchPNG(5, []color.RGBA{green}),
+            []color.RGBA{green}},
+        {"hex", ".hex", []uint8("ff0000\n#008000,1020ff\nff0000008000\n"),
+            []color.RGBA{red, green, blue, red, green}},
+    }
+    for _, c := range cases {
+        colors, err := ReadColorTable(bytes.NewReader(c.data), c.ext)
+        if err != nil {t.Errorf("%s: %v", c.name, err); continue}
+        if len(colors) != len(c.want) {t.Errorf("%s: %v instead of %v", c.name, colors, c.want); continue}
+        for i := range c.want {
+            if colors[i] != c.want[i] {t.Errorf("%s: color %d is %v instead of %v", c.name, i, colors[i], c.want[i])}
+        }
+    }
+
+    invalid := []struct {
+        name string
+        ext  string
+        data []uint8
+        err  string
+    }{
+        {"gpl header"   , ".gpl", []uint8("255 0 0\n"), "GIMP Palette"},
+        {"gpl component", ".gpl", []uint8("GIMP Palette\n256 0 0\n"), "color 0"},
+        {"gpl short"    , ".gpl", []uint8("GIMP Palette\n255 0\n"), "expecting 3"},
+        {"pal header"   , ".pal", []uint8("JASC\n0100\n1\n0 0 0\n"), "JASC-PAL"},
+        {"pal count"    , ".pal", []uint8("JASC-PAL\n0100\n2\n0 0 0\n"), "expecting 2 colors given 1"},
+        {"ase magic"    , ".ase", []uint8("ASEX\x00\x01\x00\x00\x00\x00\x00\x00"), "bad magic"},
+        {"ase truncated", ".ase", aseFile(aseColor("red", "RGB ", 1, 0, 0))[:30], "truncated"},
+        {"ase model"    , ".ase", aseFile(aseColor("lab", "LAB ", 50, 0, 0)), `"lab" uses the unsupported model`},
+        {"png"          , ".png", []uint8("not a png"), "png"},
+        {"format"       , ".act", nil, "expecting .gpl, .pal, .ase, .png, .hex or .txt"},
+    }
+    for _, c := range invalid {
+        _, err := ReadColorTable(bytes.NewReader(c.data), c.ext)
+        if err == nil || !strings.Contains(err.Error(), c.err) {t.Errorf("%s: %v instead of an error about %q", c.name, err, c.err)}
+    }
+}
+
+
+// colors brought to the 64 of the console by every fit
+func TestFitColors (t *testing.T) {
+    table := func (n int, value func (i int) uint8) []color.RGBA {
+        colors := make([]color.RGBA, n)
+        for i := range colors {
+            v := value(i)
+            colors[i] = color.RGBA{v, v, 0xFF - v, 0xFF}
+        }
+        return colors
+    }
+    steps := func (i int) uint8 {return uint8(i * 4)}
+    zeros := func (i int) uint8 {return 0}
+    pairs := func (i int) uint8 {return uint8(i / 2 * 4)}  // each color twice in a row
+    twice := func (i int) uint8 {return uint8(i % 64 * 4)} // the table twice
+    black := color.RGBA{0, 0, 0, 0xFF}
+    cases := []struct {
+        name   string
+        colors []color.RGBA
+        fit    ColorFit
+        err    string
+        want   []color.RGBA // first colors, then black
+    }{
+        {"exact"            , table(64 , steps), FitExact   , ""                               , table(64, steps)},
+        {"exact, too few"   , table(63 , zeros), FitExact   , "expecting 64 given 63, pad"     , nil},
+        {"exact, too many"  , table(65 , zeros), FitExact   , "expecting 64 given 65, quantize", nil},
+        {"pad"              , table(3  , steps), FitPad     , ""                               , table(3, steps)},
+        {"pad 64"           , table(64 , steps), FitPad     , ""                               , table(64, steps)},
+        {"pad, too many"    , table(65 , zeros), FitPad     , "expecting 64 given 65, quantize", nil},
+        {"quantize, too few", table(5  , steps), FitQuantize, ""                               , table(5, steps)},
+        {"quantize 64"      , table(64 , steps), FitQuantize, ""                               , table(64, steps)},
+        {"quantize pairs"   , table(128, pairs), FitQuantize, ""                               , table(64, steps)},
+        {"quantize twice"   , table(128, twice), FitQuantize, ""                               , table(64, steps)},
+    }
+    for _, c := range cases {
+        colors, err := FitColors(c.colors, c.fit)
+        if c.err != "" {
+            if err == nil || !strings.Contains(err.Error(), c.err) {t.Errorf("%s: %v instead of an error about %q", c.name, err, c.err)}
+            continue
+        }
+        if err != nil {t.Errorf("%s: %v", c.name, err); continue}
+        if len(colors) != nbColors {t.Errorf("%s: %d colors", c.name, len(colors)); continue}
+        for i := range colors {
+            want := black
+            if i < len(c.want) {want = c.want[i]}
+            if colors[i] != want {t.Errorf("%s: color %d is %v instead of %v", c.name, i, colors[i], want); break}
+        }
+    }
+
+    // 65 colors quantized: the two nearest are merged
+    colors := table(65, func (i int) uint8 {return uint8(i * 3)})
+    colors[64] = color.RGBA{0x01, 0x01, 0xFE, 0xFF}
+    fit, err := FitColors(colors, FitQuantize)
+    if err != nil {t.Fatal(err)}
+    merged := color.RGBA{0x01, 0x01, 0xFF, 0xFF}
+    if len(fit) != nbColors || fit[0] != merged || fit[1] != colors[1] || fit[63] != colors[63] {t.Errorf("quantized to %v", fit)}
+
+    if err := SetColors(fit[:63]); err == nil {t.Error("63 master colors set")}
+}
+
+
+// every format loaded from a file with every fit
+func TestLoadColorFile (t *testing.T) {
+    dir, err := ioutil.TempDir("", "colors")
+    if err != nil {t.Fatal(err)}
+    defer os.RemoveAll(dir)
+
+    red, blue := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}
+    files := map[string][]uint8{
+        "three.gpl": []uint8("GIMP Palette\n255 0 0\n0 0 255\n255 0 0\n"),
+        "three.pal": []uint8("JASC-PAL\n0100\n3\n255 0 0\n0 0 255\n255 0 0\n"),
+        "three.ase": aseFile(aseColor("r", "RGB ", 1, 0, 0), aseColor("b", "RGB ", 0, 0, 1), aseColor("r", "RGB ", 1, 0, 0)),
+        "three.png": swatchPNG(4, []color.RGBA{red, blue, red}),
+        "three.hex": []uint8("ff0000\n0000ff\nff0000\n"),
+        "three.txt": []uint8(";paint.net\nFFFF0000\nFF0000FF\nFFFF0000\n"),
+    }
+    for name, data := range files {
+        path := filepath.Join(dir, name)
+        if err := ioutil.WriteFile(path, data, 0644); err != nil {t.Fatal(err)}
+        if _, err := LoadColorFile(path, FitExact); err == nil || !strings.Contains(err.Error(), name) {
+            t.Errorf("%s: exact fit gives %v", name, err)
+        }
+        for _, fit := range []ColorFit{FitPad, FitQuantize} {
+            colors, err := LoadColorFile(path, fit)
+            if err != nil {t.Errorf("%s: %v", name, err); continue}
+            if len(colors) != nbColors || colors[0] != red || colors[1] != blue || colors[2] != red || colors[3] != (color.RGBA{0, 0, 0, 0xFF}) {
+                t.Errorf("%s, fit %d: %v", name, fit, colors[:4])
+            }
+        }
+    }
+}
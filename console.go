@@ -0,0 +1,148 @@
+package main
+
+/*
+    Console assembling the Processor and the devices on the bus
+
+    $0000-$1FFF  RAM     work memory
+    $2000-$203F  PPU     picture registers
+    $2100-$22FF  OAM     attribute table of the sprites
+    $3000-$30FF  Input   controllers
+    $3100-$311F  APU     audio registers
+    $4000-$FFFF  ROM     program and interrupt vectors
+*/
+
+import (
+    "io/ioutil"
+    "path/filepath"
+)
+
+
+// location of the devices on the bus
+const (
+    addrRAM   = 0x0000
+    addrPPU   = 0x2000
+    addrOAM   = 0x2100
+    addrInput = 0x3000
+    addrAPU   = 0x3100
+    addrROM   = 0x4000
+    sizeRAM   = 0x2000
+    sizeROM   = memSize - addrROM
+)
+
+
+type Console struct {
+    cpu    Processor
+    bus    Bus
+    ram   *Memory
+    mapper Mapper // ROM of the cartridge
+    stack  Stack
+    ppu   PPU
+    input Input
+    apu   APU
+    audio AudioOutput // receives the samples at the end of every frame
+}
+
+
+// create a console with its devices mapped on the bus
+func NewConsole () *Console {
+    con := &Console{ram: NewMemory(sizeRAM)}
+    devices := []mapping {
+        {"RAM"  , addrRAM  , addrRAM   + sizeRAM, con.ram      },
+        {"PPU"  , addrPPU  , addrPPU   + ppuSize, &con.ppu     },
+        {"OAM"  , addrOAM  , addrOAM   + oamSize, OAM{&con.ppu}},
+        {"Input", addrInput, addrInput + 0x100  , &con.input   },
+        {"APU"  , addrAPU  , addrAPU   + apuSize, &con.apu     },
+    }
+    for _, m := range devices {
+        if err := con.bus.Map(m.name, m.start, m.end, m.dev); err != nil {panic(err)}
+    }
+
+    // an empty ROM until a cartridge is inserted
+    mapper, err := NewMapper(&Cartridge{Tiles: make([]TileBank, 1)})
+    if err != nil {panic(err)}
+    if err := con.SetMapper(mapper); err != nil {panic(err)}
+
+    con.cpu.bus   = &con.bus
+    con.cpu.stack = &con.stack
+    con.ppu.dma   = con.spriteDMA
+    con.input.irq = con.cpu.RequestIRQ
+    con.apu.Rate  = defaultRate
+    con.apu.clock = con.cpu.Cycles
+    con.apu.Reset()
+    return con
+}
+
+
+// copy the memory starting at a page in the attribute table of the sprites
+//( a cycle per byte and one more to start, like the NES,
+//  the tracer records the copy with the writes of the instruction )
+func (con *Console) spriteDMA (page uint8) {
+    oam := OAM{&con.ppu}
+    for i := uint(0); i < oamSize; i += 1 {
+        value := uint8(con.bus.GetByte(uint(page) << 8 + i))
+        oam.Write(i, value)
+        if con.cpu.tracer != nil {con.cpu.tracer.write(addrOAM + i, uint(value))}
+    }
+    con.cpu.cycles += oamSize + 1
+}
+
+
+// power the console on again, the cartridge stays plugged
+//( the processor, the stack, the RAM, the PPU, the controllers and the APU
+//  start from their state at power on, a tracer stays attached )
+func (con *Console) Reset () {
+    con.cpu   = Processor{bus: &con.bus, stack: &con.stack, tracer: con.cpu.tracer}
+    con.stack = Stack{}
+    for i := range con.ram.data {con.ram.data[i] = 0}
+    con.ppu   = PPU{bank: con.ppu.bank, dma: con.ppu.dma}
+    con.input = Input{irq: con.input.irq}
+    con.apu   = APU{Rate: con.apu.Rate, clock: con.apu.clock}
+    con.apu.Reset()
+}
+
+
+// plug a mapper over the ROM and let the PPU use its tiles
+//( the mapper replaces the one of the previous cartridge on the bus )
+func (con *Console) SetMapper (mapper Mapper) error {
+    if err := con.bus.Map("ROM", addrROM, addrROM + sizeROM, mapper); err != nil {return err}
+    con.mapper   = mapper
+    con.ppu.bank = mapper
+    return nil
+}
+
+
+// execute the program for one frame, starting with the vertical blank
+//( return the fault that halted the processor )
+func (con *Console) Frame () error {
+    con.ppu.vblank = true
+    con.ppu.Animate()
+    con.ppu.UpdateCollisions()
+    con.ppu.UpdateSpriteLimit()
+    err := con.cpu.RunFrame()
+    con.apu.Flush(con.audio)
+    return err
+}
+
+
+// send the audio to an output, nil drops it
+func (con *Console) SetAudio (out AudioOutput) {
+    con.audio = out
+}
+
+
+// load a cartridge, or a raw image of the memory starting at entry
+func (con *Console) LoadFile (path, entry string) error {
+    if filepath.Ext(path) == ".vox" {
+        // cartridges start at their entry address
+        cart, err := LoadCartridge(path)
+        if err != nil {return err}
+        return con.Insert(cart)
+    }
+    image, err := ioutil.ReadFile(path)
+    if err != nil {return err}
+    pc, err := parseNumber(entry)
+    if err != nil {return err}
+    if err := con.bus.Load(0, image); err != nil {return err}
+    con.cpu.ptr = uint(pc) & (memSize - 1)
+    return nil
+}
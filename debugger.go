@@ -0,0 +1,280 @@
+package main
+
+/*
+    Interactive step debugger for the Processor
+    breakpoints stop before an instruction is executed,
+    watchpoints stop after the instruction accessing the memory
+*/
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "bufio"
+    "strings"
+    "os/signal"
+    "sync/atomic"
+)
+
+
+type Debugger struct {
+    cpu    *Processor
+    breaks  map[uint]bool // addresses to stop at
+    reads   map[uint]bool // addresses to watch for reads
+    writes  map[uint]bool // addresses to watch for writes
+    stop    string        // reason to stop, set by watchpoints
+    halt    int32         // set when the user interrupts the execution
+}
+
+
+// attach a debugger to the processor and its memory
+func NewDebugger (cpu *Processor) *Debugger {
+    dbg := &Debugger {
+        cpu:    cpu,
+        breaks: make(map[uint]bool),
+        reads:  make(map[uint]bool),
+        writes: make(map[uint]bool),
+    }
+    cpu.bus.OnRead = func (index uint) {
+        if dbg.reads[index] {
+            dbg.stop = fmt.Sprintf("read $%04X", index)
+        }
+    }
+    cpu.bus.OnWrite = func (index, value uint) {
+        if dbg.writes[index] {
+            dbg.stop = fmt.Sprintf("write $%04X <- $%02X", index, uint8(value))
+        }
+    }
+    return dbg
+}
+
+
+// execute a single instruction
+//( the fault halting the processor, such as a stack overflow, is returned )
+func (dbg *Debugger) Step () error {
+    dbg.cpu.Cycle()
+    return dbg.cpu.Fault()
+}
+
+
+// execute instructions until something stops the execution
+//( at most count instructions are executed, none if count is 0 )
+func (dbg *Debugger) Run (count int, until uint, useUntil bool) string {
+    atomic.StoreInt32(&dbg.halt, 0)
+    for i := 0; count < 0 || i < count; i += 1 {
+        // breakpoints do not stop the first instruction s.t. we can resume
+        if i > 0 {
+            if useUntil && dbg.cpu.ptr == until {return "reached"}
+            if dbg.breaks[dbg.cpu.ptr] {return "breakpoint"}
+        }
+
+        dbg.stop = ""
+        if err := dbg.Step(); err != nil {return err.Error()}
+        if dbg.stop != "" {return "watchpoint: " + dbg.stop}
+        if dbg.cpu.ReachedEnd() {return "end of memory"}
+        if atomic.LoadInt32(&dbg.halt) != 0 {return "interrupted"}
+    }
+    return "step"
+}
+
+
+// interrupt the execution from another goroutine
+func (dbg *Debugger) Interrupt () {
+    atomic.StoreInt32(&dbg.halt, 1)
+}
+
+
+// format the registers, the flags and the pointers
+func (dbg *Debugger) Registers () string {
+    cpu   := dbg.cpu
+    flags := ""
+    for i, name := range flagNames {
+        if cpu.flag[i] {
+            flags += name
+        } else {
+            flags += strings.ToLower(name)
+        }
+    }
+    if cpu.masked {flags += " masked"}
+    return fmt.Sprintf("PC=%04X SP=%02X A=%02X X=%02X Y=%02X Z=%02X flags=%s cycles=%d",
+        cpu.ptr, cpu.stack.ptr, cpu.reg[0], cpu.reg[1], cpu.reg[2], cpu.reg[3], flags, cpu.cycles)
+}
+
+
+// format the content of the stack from bottom to top
+func (dbg *Debugger) Stack () string {
+    s := dbg.cpu.stack
+    if s.ptr <= 0 {return "stack empty"}
+
+    hex := make([]string, 0, s.ptr)
+    for i := 0; i < s.ptr && i < len(s.data); i += 1 {
+        hex = append(hex, fmt.Sprintf("%02X", s.data[i]))
+    }
+    return fmt.Sprintf("SP=%02X: %s", s.ptr, strings.Join(hex, " "))
+}
+
+
+// format a range of memory as rows of 16 bytes
+func (dbg *Debugger) Dump (addr, count uint) string {
+    var sb strings.Builder
+    for i := uint(0); i < count; i += 16 {
+        fmt.Fprintf(&sb, "%04X:", (addr + i) & (memSize - 1))
+        for j := i; j < i + 16 && j < count; j += 1 {
+            fmt.Fprintf(&sb, " %02X", dbg.cpu.bus.GetByte(addr + j))
+        }
+        sb.WriteString("\n")
+    }
+    return sb.String()
+}
+
+
+const debugHelp = `commands (an empty line repeats the last one):
+  s, step [n]        execute n instructions
+  c, continue        run until a breakpoint or a watchpoint
+  u, until addr      run until the pointer reaches addr
+  b, break addr      toggle a breakpoint
+  r, rwatch addr     toggle a watchpoint on reads
+  w, watch addr      toggle a watchpoint on writes
+  i, info            list breakpoints and watchpoints
+  p, regs            show registers and flags
+  k, stack           show the stack
+  m, mem addr [n]    dump n bytes of memory
+  l, list [addr] [n] disassemble n instructions
+  q, quit            leave the debugger
+`
+
+
+// read commands from the input until the user quits
+func (dbg *Debugger) Repl (in io.Reader, out io.Writer) error {
+    scanner := bufio.NewScanner(in)
+    last    := ""
+
+    fmt.Fprintln(out, dbg.Registers())
+    fmt.Fprintln(out, Disassemble(dbg.cpu.bus, dbg.cpu.ptr))
+    for {
+        fmt.Fprint(out, "(dbg) ")
+        if !scanner.Scan() {return scanner.Err()}
+
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" {line = last}
+        last  = line
+        if line == "" {continue}
+
+        args := strings.Fields(line)
+        quit, err := dbg.command(args[0], args[1:], out)
+        if err != nil {fmt.Fprintln(out, "error:", err)}
+        if quit {return nil}
+    }
+}
+
+
+// execute a single command of the debugger
+func (dbg *Debugger) command (name string, args []string, out io.Writer) (bool, error) {
+    // parse the numbers given as arguments
+    nums := make([]uint, len(args))
+    for i, arg := range args {
+        n, err := parseNumber(arg)
+        if err != nil {return false, err}
+        if n < 0 {return false, fmt.Errorf("negative value: %s", arg)}
+        nums[i] = uint(n)
+    }
+    arg := func (i int, def uint) uint {
+        if i < len(nums) {return nums[i]}
+        return def
+    }
+    need := func (n int) error {
+        if len(nums) < n {return fmt.Errorf("%s expects %d argument(s)", name, n)}
+        return nil
+    }
+    toggle := func (set map[uint]bool, kind string) {
+        addr := nums[0] & (memSize - 1)
+        if set[addr] {
+            delete(set, addr)
+            fmt.Fprintf(out, "%s removed at $%04X\n", kind, addr)
+        } else {
+            set[addr] = true
+            fmt.Fprintf(out, "%s set at $%04X\n", kind, addr)
+        }
+    }
+    run := func (count int, until uint, useUntil bool) {
+        reason := dbg.Run(count, until, useUntil)
+        if reason != "step" {fmt.Fprintln(out, reason)}
+        fmt.Fprintln(out, dbg.Registers())
+        fmt.Fprintln(out, Disassemble(dbg.cpu.bus, dbg.cpu.ptr))
+    }
+
+    switch name {
+    case "s", "step":
+        run(int(arg(0, 1)), 0, false)
+    case "c", "continue":
+        run(-1, 0, false)
+    case "u", "until":
+        if err := need(1); err != nil {return false, err}
+        run(-1, nums[0], true)
+    case "b", "break":
+        if err := need(1); err != nil {return false, err}
+        toggle(dbg.breaks, "breakpoint")
+    case "r", "rwatch":
+        if err := need(1); err != nil {return false, err}
+        toggle(dbg.reads, "read watchpoint")
+    case "w", "watch":
+        if err := need(1); err != nil {return false, err}
+        toggle(dbg.writes, "write watchpoint")
+    case "i", "info":
+        for _, set := range []struct {kind string; addrs map[uint]bool} {
+            {"breakpoints", dbg.breaks}, {"read watchpoints", dbg.reads}, {"write watchpoints", dbg.writes}} {
+            fmt.Fprintf(out, "%s:", set.kind)
+            for addr := uint(0); addr < memSize; addr += 1 {
+                if set.addrs[addr] {fmt.Fprintf(out, " $%04X", addr)}
+            }
+            fmt.Fprintln(out)
+        }
+    case "p", "regs":
+        fmt.Fprintln(out, dbg.Registers())
+    case "k", "stack":
+        fmt.Fprintln(out, dbg.Stack())
+    case "m", "mem":
+        if err := need(1); err != nil {return false, err}
+        fmt.Fprint(out, dbg.Dump(nums[0], arg(1, 64)))
+    case "l", "list":
+        addr := arg(0, dbg.cpu.ptr)
+        for i := uint(0); i < arg(1, 10); i += 1 {
+            inst := Disassemble(dbg.cpu.bus, addr & (memSize - 1))
+            fmt.Fprintln(out, inst)
+            addr += uint(len(inst.Bytes))
+        }
+    case "h", "help":
+        fmt.Fprint(out, debugHelp)
+    case "q", "quit":
+        return true, nil
+    default:
+        return false, fmt.Errorf("unknown command %s (try help)", name)
+    }
+    return false, nil
+}
+
+
+// command line: vox-legacy debug [-pc addr] image|game.vox
+func cmdDebug (args []string) error {
+    flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+    entry := flags.String("pc", "$0000", "address of the first instruction")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {return fmt.Errorf("usage: vox-legacy debug [-pc addr] image|game.vox")}
+
+    con := NewConsole()
+    if err := con.LoadFile(flags.Arg(0), *entry); err != nil {return err}
+    dbg := NewDebugger(&con.cpu)
+
+    // Ctrl+C interrupts the program instead of the debugger
+    //( the channel is closed once the session ends s.t. the goroutine leaves )
+    interrupts := make(chan os.Signal, 1)
+    signal.Notify(interrupts, os.Interrupt)
+    defer close(interrupts)
+    defer signal.Stop(interrupts)
+    go func () {
+        for range interrupts {dbg.Interrupt()}
+    }()
+
+    return dbg.Repl(os.Stdin, os.Stdout)
+}
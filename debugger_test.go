@@ -0,0 +1,110 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+
+// count down A in a loop, push X, read the counter and wait
+const debugProgram = `
+        LOD A, #$03
+loop:   DEC A
+        STR A, $0200
+        BRN Z, loop
+        LOD X, #$2A
+        PSH X
+        LOD Y, $0200
+end:    JMP end
+`
+
+
+// run a script of commands on the program and return what is printed
+func debugSession (t *testing.T, script string) string {
+    image, err := Assemble("debug", debugProgram)
+    if err != nil {t.Fatal(err)}
+    con := NewConsole()
+    if err := con.bus.Load(0, image); err != nil {t.Fatal(err)}
+    con.cpu.ptr = 0
+
+    var out strings.Builder
+    dbg := NewDebugger(&con.cpu)
+    if err := dbg.Repl(strings.NewReader(script), &out); err != nil {t.Fatal(err)}
+    return out.String()
+}
+
+
+// commands read from the input and what the debugger answers
+//( an empty line repeats continue, nothing is read after quit )
+func TestDebuggerSession (t *testing.T) {
+    script := []string{"b $0006", "c", "", "w $0200", "c", "w $0200", "u $000C",
+        "k", "m $0000 4", "p", "i", "x", "l $0009 2", "s 2", "q", "s"}
+    want := `PC=0000 SP=00 A=00 X=00 Y=00 Z=00 flags=zncv cycles=0
+0000  50 03     LOD A, #$03
+(dbg) breakpoint set at $0006
+(dbg) breakpoint
+PC=0006 SP=00 A=02 X=00 Y=00 Z=00 flags=zncv cycles=10
+0006  B4 00 02  BRN Z, $0002
+(dbg) breakpoint
+PC=0006 SP=00 A=01 X=00 Y=00 Z=00 flags=zncv cycles=21
+0006  B4 00 02  BRN Z, $0002
+(dbg) write watchpoint set at $0200
+(dbg) watchpoint: write $0200 <- $00
+PC=0006 SP=00 A=00 X=00 Y=00 Z=00 flags=Zncv cycles=32
+0006  B4 00 02  BRN Z, $0002
+(dbg) write watchpoint removed at $0200
+(dbg) reached
+PC=000C SP=01 A=00 X=2A Y=00 Z=00 flags=zncv cycles=42
+000C  4A 02 00  LOD Y, $0200
+(dbg) SP=01: 2A
+(dbg) 0000: 50 03 78 40
+(dbg) PC=000C SP=01 A=00 X=2A Y=00 Z=00 flags=zncv cycles=42
+(dbg) breakpoints: $0006
+read watchpoints:
+write watchpoints:
+(dbg) error: unknown command x (try help)
+(dbg) 0009  51 2A     LOD X, #$2A
+000B  55        PSH X
+(dbg) PC=000F SP=01 A=00 X=2A Y=00 Z=00 flags=Zncv cycles=51
+000F  5C 00 0F  JMP $000F
+(dbg) `
+
+    got := debugSession(t, strings.Join(script, "\n") + "\n")
+    if got != want {
+        gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+        for i := range wantLines {
+            if i >= len(gotLines) || gotLines[i] != wantLines[i] {
+                t.Fatalf("line %d differs:\n%s", i + 1, got)
+            }
+        }
+        t.Fatalf("more lines than expected:\n%s", got)
+    }
+}
+
+
+// read watchpoints, the stack and the errors of the arguments
+func TestDebuggerCommands (t *testing.T) {
+    cases := []struct {
+        script string
+        want   []string // printed in this order
+    }{
+        {"r $0200\nc\n", []string{"read watchpoint set at $0200", "watchpoint: read $0200", "PC=000F"}},
+        {"k\n", []string{"stack empty"}},
+        {"b\nm\nu\n", []string{"error: b expects 1 argument(s)", "error: m expects 1 argument(s)", "error: u expects 1 argument(s)"}},
+        {"b -1\nb zz\n", []string{"error: negative value: -1", "error: "}},
+        {"s 0\n", []string{"(dbg) PC=0000"}},
+        {"h\n", []string{"commands (an empty line repeats the last one):", "q, quit"}},
+    }
+    for _, c := range cases {
+        out := debugSession(t, c.script)
+        rest := out
+        for _, want := range c.want {
+            i := strings.Index(rest, want)
+            if i < 0 {
+                t.Errorf("%q: %q not printed in order:\n%s", c.script, want, out)
+                break
+            }
+            rest = rest[i + len(want):]
+        }
+    }
+}
@@ -0,0 +1,191 @@
+package main
+
+/*
+    Disassembler decoding the memory like the Processor does
+    the output of Source can be assembled back into identical bytes
+*/
+
+import (
+    "fmt"
+    "flag"
+    "strings"
+    "io/ioutil"
+)
+
+
+// a decoded instruction
+type Instruction struct {
+    Addr  uint    // address of the opcode
+    Bytes []uint8 // opcode and operands
+    Text  string  // mnemonic and operands
+}
+
+
+// decode the instruction at the given address
+func Disassemble (bus *Bus, addr uint) Instruction {
+    inst := bus.GetByte(addr)
+    def  := decodeOp(inst)
+
+    // bytes that are not a known opcode are executed as NOP
+    size := uint(1)
+    if def != nil {size = def.kind.Size(inst)}
+    if def == nil || addr + size > memSize {
+        text := fmt.Sprintf(".byte $%02X ; %s", inst, noteOp(inst))
+        return Instruction{addr, []uint8{uint8(inst)}, text}
+    }
+
+    // copy the bytes of the instruction
+    bytes := make([]uint8, size)
+    for i := range bytes {
+        bytes[i] = uint8(bus.GetByte(addr + uint(i)))
+    }
+
+    // format the operands the way the assembler reads them
+    reg  := regNames [inst & 0x3]
+    flag := flagNames[inst & 0x3]
+    var args []string
+    switch def.kind {
+    case kindReg:
+        args = []string{reg}
+    case kindRegAddr:
+        args = []string{reg, fmtAddr(bus, addr + 1)}
+    case kindIndexed:
+        args = []string{fmtAddr(bus, addr + 1)}
+        if inst & 0x3 != 0 {args = append(args, reg)}
+    case kindRegNum:
+        args = []string{reg, fmt.Sprintf("#$%02X", bus.GetByte(addr + 1))}
+    case kindAddr:
+        args = []string{fmtAddr(bus, addr + 1)}
+    case kindRegReg:
+        args = []string{reg, regNames[(inst & 0xC) >> 2]}
+    case kindOperand:
+        if inst & 0x4 == 0 {
+            args = []string{reg}
+        } else {
+            args = []string{fmtAddr(bus, addr + 1)}
+            if inst & 0x3 != 0 {args = append(args, reg)}
+        }
+    case kindFlag:
+        args = []string{flag}
+    case kindFlagAddr:
+        args = []string{flag, fmtAddr(bus, addr + 1)}
+    }
+
+    text := def.name
+    if len(args) > 0 {text += " " + strings.Join(args, ", ")}
+    return Instruction{addr, bytes, text}
+}
+
+
+// decode every instruction between two addresses
+func DisassembleRange (bus *Bus, start, end uint) []Instruction {
+    var list []Instruction
+    for addr := start; addr < end && addr < memSize; {
+        inst := Disassemble(bus, addr)
+        list  = append(list, inst)
+        addr += uint(len(inst.Bytes))
+    }
+    return list
+}
+
+
+// format an instruction as a line of listing
+func (inst Instruction) String () string {
+    hex := make([]string, len(inst.Bytes))
+    for i, b := range inst.Bytes {
+        hex[i] = fmt.Sprintf("%02X", b)
+    }
+    return fmt.Sprintf("%04X  %-8s  %s", inst.Addr, strings.Join(hex, " "), inst.Text)
+}
+
+
+// format instructions as a source that can be assembled again
+func Source (list []Instruction) string {
+    var sb strings.Builder
+    next := uint(memSize)
+    for _, inst := range list {
+        if inst.Addr != next {
+            fmt.Fprintf(&sb, "    .org $%04X\n", inst.Addr)
+        }
+        fmt.Fprintf(&sb, "    %s\n", inst.Text)
+        next = inst.Addr + uint(len(inst.Bytes))
+    }
+    return sb.String()
+}
+
+
+// find the family of an opcode, nil if it is executed as NOP
+func decodeOp (inst uint) *opDef {
+    for i := range opDefs {
+        def := &opDefs[i]
+
+        // number of opcodes in this family
+        var count uint
+        switch def.kind {
+        case kindNone, kindAddr:
+            count = 1
+        case kindRegReg:
+            count = 16
+        case kindOperand:
+            count = 8
+        default:
+            count = 4
+        }
+        if between(def.base, inst, def.base + count) {return def}
+    }
+    return nil
+}
+
+
+// describe how the Processor executes a byte without mnemonic
+func noteOp (inst uint) string {
+    if inst == opRTN + 1 {return "RTN"}
+    if decodeOp(inst) != nil {return "truncated"}
+    return "NOP"
+}
+
+
+// format the address stored at the given location
+func fmtAddr (bus *Bus, addr uint) string {
+    return fmt.Sprintf("$%04X", bus.GetAddress(addr))
+}
+
+
+// command line: vox-legacy disasm [-s] [-start addr] [-end addr] image
+func cmdDisassemble (args []string) error {
+    flags  := flag.NewFlagSet("disasm", flag.ContinueOnError)
+    source := flags.Bool  ("s"    , false, "print a source that can be assembled again")
+    start  := flags.String("start", "$0000", "first address to decode")
+    end    := flags.String("end"  , ""     , "address to stop at (default: after the last non-zero byte)")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {
+        return fmt.Errorf("usage: vox-legacy disasm [-s] [-start addr] [-end addr] image")
+    }
+
+    image, err := ioutil.ReadFile(flags.Arg(0))
+    if err != nil {return err}
+    bus := NewFlatBus()
+    if err := bus.Load(0, image); err != nil {return err}
+
+    // find the range to decode
+    first, err := parseNumber(*start)
+    if err != nil {return err}
+    last := len(image)
+    for last > 0 && image[last - 1] == 0 {last -= 1}
+    if *end != "" {
+        if last, err = parseNumber(*end); err != nil {return err}
+    }
+    if first < 0 || last < first || last > memSize {
+        return fmt.Errorf("invalid range: $%04X-$%04X", first, last)
+    }
+
+    list := DisassembleRange(bus, uint(first), uint(last))
+    if *source {
+        fmt.Print(Source(list))
+        return nil
+    }
+    for _, inst := range list {
+        fmt.Println(inst)
+    }
+    return nil
+}
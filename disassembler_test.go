@@ -0,0 +1,85 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+)
+
+
+// disassemble a whole image, assemble the source again and compare
+func checkRoundTrip (t *testing.T, name string, image []uint8) {
+    bus := NewFlatBus()
+    if err := bus.Load(0, image); err != nil {t.Fatal(err)}
+    source := Source(DisassembleRange(bus, 0, memSize))
+
+    again, err := Assemble(name, source)
+    if err != nil {
+        t.Errorf("%s: cannot assemble the disassembly: %v", name, err)
+        return
+    }
+    if !bytes.Equal(image, again) {
+        for i := range image {
+            if image[i] != again[i] {
+                t.Errorf("%s: byte $%04X is $%02X instead of $%02X", name, i, again[i], image[i])
+                return
+            }
+        }
+    }
+}
+
+
+func TestDisassembleRoundTrip (t *testing.T) {
+    programs, err := filepath.Glob(filepath.Join("testdata", "disasm", "*.asm"))
+    if err != nil {t.Fatal(err)}
+    if len(programs) == 0 {t.Fatal("no program in testdata/disasm")}
+
+    for _, path := range programs {
+        image, err := AssembleFile(path)
+        if err != nil {t.Fatal(err)}
+        checkRoundTrip(t, path, image)
+    }
+}
+
+
+// every byte value decodes to something the assembler reads back
+func TestDisassembleAllBytes (t *testing.T) {
+    image := make([]uint8, memSize)
+    for i := range image {
+        image[i] = uint8(i * 7 + i >> 8)
+    }
+    checkRoundTrip(t, "bytes", image)
+}
+
+
+// the listing of every opcode matches the one kept next to its source
+//( opcodes.lst as printed by vox-legacy disasm -start $0100 )
+func TestDisassembleListing (t *testing.T) {
+    source := filepath.Join("testdata", "disasm", "opcodes.asm")
+    image, err := AssembleFile(source)
+    if err != nil {t.Fatal(err)}
+    want, err := ioutil.ReadFile(strings.TrimSuffix(source, ".asm") + ".lst")
+    if err != nil {t.Fatal(err)}
+
+    last := len(image)
+    for last > 0 && image[last - 1] == 0 {last -= 1}
+    bus := NewFlatBus()
+    if err := bus.Load(0, image); err != nil {t.Fatal(err)}
+    list  := DisassembleRange(bus, 0x100, uint(last))
+    lines := strings.Split(strings.TrimSpace(string(want)), "\n")
+    if len(list) != len(lines) {t.Errorf("%d instructions instead of %d", len(list), len(lines))}
+    for i := 0; i < len(list) && i < len(lines); i += 1 {
+        if got := list[i].String(); got != strings.TrimRight(lines[i], "\r") {
+            t.Errorf("line %d: %q instead of %q", i + 1, got, lines[i])
+        }
+    }
+
+    // the source uses every opcode with a mnemonic
+    used := make(map[uint8]bool)
+    for _, inst := range list {used[inst.Bytes[0]] = true}
+    for inst := uint(0); inst < 0x100; inst += 1 {
+        if decodeOp(inst) != nil && !used[uint8(inst)] {t.Errorf("opcode $%02X is not in %s", inst, source)}
+    }
+}
@@ -0,0 +1,297 @@
+package main
+
+/*
+    Exporters of the geometry built by the meshers, as Wavefront OBJ
+    with its MTL or as glTF 2.0 (.gltf with an embedded buffer, .glb)
+
+    positions are in voxels and turned to be y-up (y and z are negated),
+    every vertex carries the color of its palette
+*/
+
+import (
+    "io"
+    "fmt"
+    "flag"
+    "bytes"
+    "strings"
+    "io/ioutil"
+    "encoding/json"
+    "encoding/base64"
+    "encoding/binary"
+    "path/filepath"
+)
+
+
+// triangles with a color per vertex, vertices shared by faces are merged
+type ExportMesh struct {
+    Positions [][3]float32
+    Colors    [][3]float32
+    Indices   []uint32
+
+    index map[[6]float32]uint32
+}
+
+
+// add a triangle, the color is shared by its three vertices
+func (m *ExportMesh) addTriangle (tri [3][3]float32, rgb [3]float32) {
+    if m.index == nil {m.index = make(map[[6]float32]uint32)}
+    for _, p := range tri {
+        // turn the screen to be y-up
+        p = [3]float32{p[0], -p[1], -p[2]}
+        key := [6]float32{p[0], p[1], p[2], rgb[0], rgb[1], rgb[2]}
+        i, ok := m.index[key]
+        if !ok {
+            i = uint32(len(m.Positions))
+            m.index[key] = i
+            m.Positions = append(m.Positions, p)
+            m.Colors    = append(m.Colors, rgb)
+        }
+        m.Indices = append(m.Indices, i)
+    }
+}
+
+
+// add the vertices built by a mesher placed in the screen
+//( positions wrap around 256, faces outside the screen are dropped )
+func (m *ExportMesh) addVertices (vertices []uint8, pos Vector3, colors func (uint8) []float32) {
+    origin := [3]float32{float32(pos.x & 0xFF), float32(pos.y & 0xFF), float32(pos.z & 0xFF)}
+    for a := range origin {
+        if origin[a] >= screenSize {origin[a] -= 0x100}
+    }
+
+    const sizeOfTri = 3 * nbCoords4Vert
+    for i := 0; i + sizeOfTri <= len(vertices); i += sizeOfTri {
+        var tri [3][3]float32
+        var center [3]float32
+        for k := range tri {
+            for a := range tri[k] {
+                tri[k][a] = float32(vertices[i + k * nbCoords4Vert + a]) + origin[a]
+                center[a] += tri[k][a] / 3
+            }
+        }
+        if center[0] < 0 || center[1] < 0 || center[2] < 0 ||
+           center[0] > screenSize || center[1] > screenSize || center[2] > screenSize {continue}
+
+        c := colors(vertices[i + 3])
+        m.addTriangle(tri, [3]float32{c[0], c[1], c[2]})
+    }
+}
+
+
+// renderer collecting the scene instead of drawing it
+func (m *ExportMesh) Begin   () {}
+func (m *ExportMesh) Overlay () {}
+func (m *ExportMesh) End     () {}
+
+func (m *ExportMesh) DrawTile (tile *Tile, palette *Palette, pos Vector3, rot Byte3, mir Bool3) {
+    oriented := tile.Orient(rot, mir)
+    m.addVertices(oriented.BuildMesh(MeshGreedy), pos, func (index uint8) []float32 {
+        return palette.colors[(index - 1) * nbComps4Color:]
+    })
+}
+
+func (m *ExportMesh) DrawMesh (mesh *Mesh, palettes []Palette, pos Vector3) {
+    m.addVertices(mesh.Vertices, pos, func (index uint8) []float32 {
+        return palettes[index >> 2].colors[((index & 0x3) - 1) * nbComps4Color:]
+    })
+}
+
+
+// geometry of a single tile with a palette
+func ExportTile (tile *Tile, palette *Palette, mode MeshMode) *ExportMesh {
+    m := &ExportMesh{}
+    m.addVertices(tile.BuildMesh(mode), Vector3{}, func (index uint8) []float32 {
+        return palette.colors[(index - 1) * nbComps4Color:]
+    })
+    return m
+}
+
+
+// geometry of the tile maps and the sprites as seen on the screen
+func ExportScene (ppu *PPU) *ExportMesh {
+    m := &ExportMesh{}
+    ppu.Draw(m)
+    return m
+}
+
+
+// write the mesh as Wavefront OBJ, with a material per color in mtl
+//( vertices also carry their colors for the viewers that support it )
+func (m *ExportMesh) WriteOBJ (obj, mtl io.Writer, mtlName string) error {
+    var o, l strings.Builder
+    fmt.Fprintf(&o, "# vox-legacy\nmtllib %s\n", mtlName)
+    for i, p := range m.Positions {
+        c := m.Colors[i]
+        fmt.Fprintf(&o, "v %g %g %g %.4f %.4f %.4f\n", p[0], p[1], p[2], c[0], c[1], c[2])
+    }
+
+    // faces are grouped by color
+    materials := make(map[[3]float32]int)
+    current   := -1
+    for t := 0; t + 3 <= len(m.Indices); t += 3 {
+        c := m.Colors[m.Indices[t]]
+        id, ok := materials[c]
+        if !ok {
+            id = len(materials)
+            materials[c] = id
+            fmt.Fprintf(&l, "newmtl color%d\nKd %.4f %.4f %.4f\nKa 0 0 0\nKs 0 0 0\nillum 1\n\n", id, c[0], c[1], c[2])
+        }
+        if id != current {
+            fmt.Fprintf(&o, "usemtl color%d\n", id)
+            current = id
+        }
+        fmt.Fprintf(&o, "f %d %d %d\n", m.Indices[t] + 1, m.Indices[t + 1] + 1, m.Indices[t + 2] + 1)
+    }
+
+    if _, err := io.WriteString(obj, o.String()); err != nil {return err}
+    _, err := io.WriteString(mtl, l.String())
+    return err
+}
+
+
+// binary buffer of the glTF: positions, colors then indices
+func (m *ExportMesh) gltfBuffer () []uint8 {
+    var buf bytes.Buffer
+    binary.Write(&buf, binary.LittleEndian, m.Positions)
+    binary.Write(&buf, binary.LittleEndian, m.Colors)
+    binary.Write(&buf, binary.LittleEndian, m.Indices)
+    return buf.Bytes()
+}
+
+
+// description of the glTF, uri is empty for the buffer of a GLB
+func (m *ExportMesh) gltfJSON (uri string, size int) ([]uint8, error) {
+    if len(m.Positions) == 0 {return nil, fmt.Errorf("Cannot export glTF: empty mesh")}
+
+    lo, hi := m.Positions[0], m.Positions[0]
+    for _, p := range m.Positions {
+        for a := range p {
+            if p[a] < lo[a] {lo[a] = p[a]}
+            if p[a] > hi[a] {hi[a] = p[a]}
+        }
+    }
+
+    vec3 := len(m.Positions) * 12
+    buffer := map[string]interface{} {"byteLength": size}
+    if uri != "" {buffer["uri"] = uri}
+    doc := map[string]interface{} {
+        "asset":  map[string]interface{} {"version": "2.0", "generator": "vox-legacy"},
+        "scene":  0,
+        "scenes": []interface{} {map[string]interface{} {"nodes": []int{0}}},
+        "nodes":  []interface{} {map[string]interface{} {"mesh": 0}},
+        "meshes": []interface{} {map[string]interface{} {
+            "primitives": []interface{} {map[string]interface{} {
+                "attributes": map[string]int{"POSITION": 0, "COLOR_0": 1},
+                "indices":    2,
+                "material":   0,
+            }},
+        }},
+        "materials": []interface{} {map[string]interface{} {
+            "pbrMetallicRoughness": map[string]interface{} {"metallicFactor": 0, "roughnessFactor": 1},
+        }},
+        "buffers": []interface{} {buffer},
+        "bufferViews": []interface{} {
+            map[string]interface{} {"buffer": 0, "byteOffset": 0       , "byteLength": vec3, "target": 34962},
+            map[string]interface{} {"buffer": 0, "byteOffset": vec3    , "byteLength": vec3, "target": 34962},
+            map[string]interface{} {"buffer": 0, "byteOffset": vec3 * 2, "byteLength": len(m.Indices) * 4, "target": 34963},
+        },
+        "accessors": []interface{} {
+            map[string]interface{} {"bufferView": 0, "componentType": 5126, "count": len(m.Positions), "type": "VEC3",
+                "min": lo[:], "max": hi[:]},
+            map[string]interface{} {"bufferView": 1, "componentType": 5126, "count": len(m.Colors), "type": "VEC3"},
+            map[string]interface{} {"bufferView": 2, "componentType": 5125, "count": len(m.Indices), "type": "SCALAR"},
+        },
+    }
+    return json.MarshalIndent(doc, "", "  ")
+}
+
+
+// write the mesh as glTF with the buffer embedded in the JSON
+func (m *ExportMesh) WriteGLTF (w io.Writer) error {
+    buf := m.gltfBuffer()
+    doc, err := m.gltfJSON("data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buf), len(buf))
+    if err != nil {return err}
+    _, err = w.Write(doc)
+    return err
+}
+
+
+// write the mesh as binary glTF
+func (m *ExportMesh) WriteGLB (w io.Writer) error {
+    buf := m.gltfBuffer()
+    doc, err := m.gltfJSON("", len(buf))
+    if err != nil {return err}
+
+    // chunks are padded to 4 bytes, JSON with spaces and binary with zeros
+    for len(doc) % 4 != 0 {doc = append(doc, ' ')}
+    for len(buf) % 4 != 0 {buf = append(buf, 0)}
+
+    var out bytes.Buffer
+    le := func (v uint32) {binary.Write(&out, binary.LittleEndian, v)}
+    out.WriteString("glTF")
+    le(2)
+    le(uint32(12 + 8 + len(doc) + 8 + len(buf)))
+    le(uint32(len(doc))); out.WriteString("JSON"); out.Write(doc)
+    le(uint32(len(buf))); out.WriteString("BIN\x00"); out.Write(buf)
+    _, err = w.Write(out.Bytes())
+    return err
+}
+
+
+// write the mesh in the format given by the extension of the path
+//( .obj writes its materials next to it in a .mtl )
+func (m *ExportMesh) Save (path string) error {
+    var buf bytes.Buffer
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".obj":
+        var mtl bytes.Buffer
+        mtlPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".mtl"
+        if err := m.WriteOBJ(&buf, &mtl, filepath.Base(mtlPath)); err != nil {return err}
+        if err := ioutil.WriteFile(mtlPath, mtl.Bytes(), 0644); err != nil {return err}
+    case ".gltf":
+        if err := m.WriteGLTF(&buf); err != nil {return err}
+    case ".glb":
+        if err := m.WriteGLB(&buf); err != nil {return err}
+    default:
+        return fmt.Errorf("Cannot export %s: expecting .obj, .gltf or .glb", path)
+    }
+    return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+
+// command line: vox-legacy export [-frames n] [-pc addr] [-tile id] [-bank n] [-pal n] [-palette file] [-fit f] [-o scene.obj] image|game.vox
+func cmdExport (args []string) error {
+    flags  := flag.NewFlagSet("export", flag.ContinueOnError)
+    frames := flags.Int   ("frames", 1          , "number of frames to run before exporting the scene")
+    entry  := flags.String("pc"    , "$0000"    , "address of the first instruction of an image")
+    tile   := flags.Int   ("tile"  , 0          , "export a single tile of the cartridge instead of the scene")
+    bank   := flags.Int   ("bank"  , 0          , "bank of the tile")
+    pal    := flags.Int   ("pal"   , 0          , "palette of the tile")
+    output := flags.String("o"     , "scene.obj", "file to write (.obj, .gltf or .glb)")
+    loadPalette := paletteFlags(flags)
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {
+        return fmt.Errorf(
+            "usage: vox-legacy export [-frames n] [-pc addr] [-tile id] [-bank n] [-pal n] [-palette file] [-fit f] [-o scene.obj] image|game.vox")
+    }
+    if err := loadPalette(); err != nil {return err}
+
+    con := NewConsole()
+    if err := con.LoadFile(flags.Arg(0), *entry); err != nil {return err}
+
+    var mesh *ExportMesh
+    if *tile != 0 {
+        cart, err := LoadCartridge(flags.Arg(0))
+        if err != nil {return err}
+        if *bank < 0 || *bank >= len(cart.Tiles) || *tile < 0 || *tile >= nbBankTiles || *pal < 0 || *pal >= nbPalettes {
+            return fmt.Errorf("Cannot export tile %d of bank %d with palette %d", *tile, *bank, *pal)
+        }
+        mesh = ExportTile(&cart.Tiles[*bank][*tile], &con.ppu.palettes[*pal], MeshGreedy)
+    } else {
+        for i := 0; i < *frames; i += 1 {
+            if err := con.Frame(); err != nil {return err}
+        }
+        mesh = ExportScene(&con.ppu)
+    }
+    return mesh.Save(*output)
+}
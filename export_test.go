@@ -0,0 +1,170 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "bytes"
+    "strconv"
+    "strings"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+    "encoding/json"
+    "encoding/base64"
+    "encoding/binary"
+)
+
+
+// part of a glTF read back by the tests
+type gltfDoc struct {
+    Buffers []struct {
+        URI        string `json:"uri"`
+        ByteLength int    `json:"byteLength"`
+    } `json:"buffers"`
+    BufferViews []struct {
+        ByteOffset int `json:"byteOffset"`
+        ByteLength int `json:"byteLength"`
+    } `json:"bufferViews"`
+    Accessors []struct {
+        Count int       `json:"count"`
+        Min   []float32 `json:"min"`
+        Max   []float32 `json:"max"`
+    } `json:"accessors"`
+}
+
+
+// box of 2 × 1 × 1 voxels at the corner of the tile
+func exportBox (mode MeshMode, second uint) *ExportMesh {
+    tile := voxelTile(0, 0, 0, 1)
+    tile.SetVoxel(1, 0, 0, second)
+    palette := &Palette{colors: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}}
+    return ExportTile(&tile, palette, mode)
+}
+
+
+// check the accessors and the buffer described by a glTF
+func checkGLTF (t *testing.T, name string, doc *gltfDoc, positions, triangles int, buffer []uint8) {
+    if len(doc.Accessors) != 3 || len(doc.BufferViews) != 3 || len(doc.Buffers) != 1 {
+        t.Fatalf("%s: %d accessors, %d views, %d buffers", name, len(doc.Accessors), len(doc.BufferViews), len(doc.Buffers))
+    }
+    counts := []int{positions, positions, triangles * 3}
+    sizes  := []int{positions * 12, positions * 12, triangles * 12}
+    offset := 0
+    for i := range counts {
+        if doc.Accessors[i].Count != counts[i] {t.Errorf("%s: accessor %d counts %d instead of %d", name, i, doc.Accessors[i].Count, counts[i])}
+        view := doc.BufferViews[i]
+        if view.ByteOffset != offset || view.ByteLength != sizes[i] {
+            t.Errorf("%s: view %d at %d of %d bytes instead of %d and %d", name, i, view.ByteOffset, view.ByteLength, offset, sizes[i])
+        }
+        offset += sizes[i]
+    }
+    if doc.Buffers[0].ByteLength != offset || len(buffer) < offset {
+        t.Errorf("%s: buffer of %d bytes given %d, expecting %d", name, doc.Buffers[0].ByteLength, len(buffer), offset)
+    }
+
+    // positions are y-up, the box goes right
+    lo, hi := doc.Accessors[0].Min, doc.Accessors[0].Max
+    if len(lo) != 3 || len(hi) != 3 || lo[0] != 0 || lo[1] != -1 || lo[2] != -1 || hi[0] != 2 || hi[1] != 0 || hi[2] != 0 {
+        t.Errorf("%s: bounds %v to %v", name, lo, hi)
+    }
+}
+
+
+// the exported files hold the vertices and the faces of the meshers
+func TestExportFormats (t *testing.T) {
+    dir, err := ioutil.TempDir("", "export")
+    if err != nil {t.Fatal(err)}
+    defer os.RemoveAll(dir)
+
+    cases := []struct {
+        mode      MeshMode
+        second    uint // color of the second voxel
+        positions int  // vertices are shared by the faces of a color
+        triangles int
+        materials int
+    }{
+        {MeshFaces , 1, 12, 20, 1}, // the faces between the voxels are hidden
+        {MeshGreedy, 1,  8, 12, 1}, // the faces are merged
+        {MeshFaces , 2, 16, 20, 2},
+        {MeshGreedy, 2, 16, 20, 2}, // nothing to merge between two colors
+    }
+    for _, c := range cases {
+        name := fmt.Sprintf("mode %d, color %d", c.mode, c.second)
+        mesh := exportBox(c.mode, c.second)
+        if len(mesh.Positions) != c.positions || len(mesh.Indices) != c.triangles * 3 {
+            t.Fatalf("%s: %d vertices %d indices", name, len(mesh.Positions), len(mesh.Indices))
+        }
+
+        // OBJ and MTL
+        obj := filepath.Join(dir, "box.obj")
+        if err := mesh.Save(obj); err != nil {t.Fatal(err)}
+        text, err := ioutil.ReadFile(obj)
+        if err != nil {t.Fatal(err)}
+        mtl, err := ioutil.ReadFile(filepath.Join(dir, "box.mtl"))
+        if err != nil {t.Fatal(err)}
+        var vertices, faces int
+        for _, line := range strings.Split(string(text), "\n") {
+            fields := strings.Fields(line)
+            switch {
+            case strings.HasPrefix(line, "v "): vertices += 1
+            case strings.HasPrefix(line, "f "):
+                faces += 1
+                for _, f := range fields[1:] {
+                    if i, err := strconv.Atoi(f); err != nil || i < 1 || i > c.positions {
+                        t.Errorf("%s: face %q", name, line)
+                    }
+                }
+            }
+        }
+        if vertices != c.positions || faces != c.triangles {
+            t.Errorf("%s: OBJ of %d vertices and %d faces", name, vertices, faces)
+        }
+        if !bytes.Contains(text, []uint8("mtllib box.mtl\n")) || bytes.Count(mtl, []uint8("newmtl")) != c.materials {
+            t.Errorf("%s: materials not written", name)
+        }
+
+        // glTF with an embedded buffer
+        path := filepath.Join(dir, "box.gltf")
+        if err := mesh.Save(path); err != nil {t.Fatal(err)}
+        text, err = ioutil.ReadFile(path)
+        if err != nil {t.Fatal(err)}
+        var doc gltfDoc
+        if err := json.Unmarshal(text, &doc); err != nil {t.Fatalf("%s: glTF %v", name, err)}
+        prefix := "data:application/octet-stream;base64,"
+        if len(doc.Buffers) == 0 || !strings.HasPrefix(doc.Buffers[0].URI, prefix) {
+            t.Fatalf("%s: no embedded buffer", name)
+        }
+        buffer, err := base64.StdEncoding.DecodeString(doc.Buffers[0].URI[len(prefix):])
+        if err != nil {t.Fatal(err)}
+        checkGLTF(t, "glTF", &doc, c.positions, c.triangles, buffer)
+
+        // binary glTF: header then the chunks JSON and BIN
+        path = filepath.Join(dir, "box.glb")
+        if err := mesh.Save(path); err != nil {t.Fatal(err)}
+        glb, err := ioutil.ReadFile(path)
+        if err != nil {t.Fatal(err)}
+        le := binary.LittleEndian
+        if len(glb) < 28 || string(glb[:4]) != "glTF" || le.Uint32(glb[4:]) != 2 || int(le.Uint32(glb[8:])) != len(glb) {
+            t.Fatalf("%s: bad GLB header", name)
+        }
+        size := int(le.Uint32(glb[12:]))
+        if size % 4 != 0 || string(glb[16:20]) != "JSON" || 20 + size + 8 > len(glb) {
+            t.Fatalf("%s: bad JSON chunk", name)
+        }
+        doc = gltfDoc{}
+        if err := json.Unmarshal(glb[20:20 + size], &doc); err != nil {t.Fatalf("%s: GLB %v", name, err)}
+        bin := glb[20 + size:]
+        if n := int(le.Uint32(bin)); n % 4 != 0 || string(bin[4:8]) != "BIN\x00" || 8 + n != len(bin) {
+            t.Fatalf("%s: bad BIN chunk", name)
+        }
+        if doc.Buffers[0].URI != "" {t.Errorf("%s: GLB buffer with an uri", name)}
+        checkGLTF(t, "GLB", &doc, c.positions, c.triangles, bin[8:])
+        if !bytes.Equal(bin[8:8 + len(buffer)], buffer) {t.Errorf("%s: glTF and GLB buffers differ", name)}
+    }
+
+    // nothing to export
+    empty := &ExportMesh{}
+    for _, name := range []string{"empty.gltf", "empty.glb", "scene.stl"} {
+        if err := empty.Save(filepath.Join(dir, name)); err == nil {t.Errorf("%s written", name)}
+    }
+}
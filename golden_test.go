@@ -0,0 +1,297 @@
+package main
+
+/*
+    Golden images, fixed scenes are rendered headless and compared
+    with reference PNG files s.t. regressions of the renderers, the
+    tile maps and the scrolling are caught
+
+    every scene is rendered twice, facing the screen (<name>-front.png)
+    and from above (<name>-orbit.png), a scene that differs is written
+    next to its golden image as <name>.actual.png
+
+    the rasterization uses floats whose rounding depends on the
+    architecture (fused multiply-add on arm64), a few pixels on the
+    edges of the triangles may differ and the colors may be off by a level
+
+    go test -run Golden -update   writes the golden images again
+*/
+
+import (
+    "os"
+    "flag"
+    "image"
+    "image/png"
+    "sort"
+    "testing"
+    "path/filepath"
+)
+
+
+// write the golden files instead of comparing them
+var update = flag.Bool("update", false, "write the golden files instead of comparing them")
+
+
+// size of the images of the orbit camera
+const goldenSize = 192
+
+
+// differences allowed with the golden images
+const (
+    goldenLevels = 2    // difference of a channel (0-255) ignored
+    goldenRatio  = 1000 // a pixel in goldenRatio may differ more
+)
+
+
+// scene set up on a PPU before rendering
+type goldenScene struct {
+    name  string
+    setup func (ppu *PPU)
+}
+
+
+var goldenScenes = []goldenScene {
+    {"maps"    , sceneMaps    },
+    {"scroll"  , sceneScroll  },
+    {"fine"    , sceneFine    },
+    {"rotated" , sceneRotated },
+    {"sprites" , sceneSprites },
+    {"priority", scenePriority},
+}
+
+
+// tiles used by the scenes, the bank is shared by every PPU
+var goldenTiles = func () *TileBank {
+    tiles := &TileBank{}
+    for z := 0; z < 8; z += 1 {
+    for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+        set := func (id, color int) {
+            tiles[id].rows[y + z * 8] |= uint16(color) << uint(14 - x * 2)
+        }
+        set(1, 1)                        // full block
+        set(2, 1 + (x + y + z) % 3)      // stripes of the 3 colors
+        if x <= y {set(3, 2)}            // wedge, shows rotations
+        if z == 0 || x == 0 {set(4, 3)}  // corner, shows mirrors
+        if (x - 4) * (x - 4) + (y - 4) * (y - 4) + (z - 4) * (z - 4) < 12 {
+            set(5, 1 + y / 3)            // ball used by sprites
+        }
+    }}}
+    return tiles
+}()
+
+
+// master colors used by the scenes, independent of the configuration
+func goldenColors () [nbComps]float32 {
+    var colors [nbComps]float32
+    for i := 0; i < nbColors; i += 1 {
+        colors[i * 3    ] = float32((i >> 4) & 0x3) / 3
+        colors[i * 3 + 1] = float32((i >> 2) & 0x3) / 3
+        colors[i * 3 + 2] = float32( i       & 0x3) / 3
+    }
+    return colors
+}
+
+
+// PPU showing the golden tiles with distinct palettes
+func goldenPPU () *PPU {
+    saved := Colors
+    Colors = goldenColors()
+    defer func () {Colors = saved}()
+
+    ppu := &PPU{}
+    ppu.bank = &mapperNone{ROM{}, goldenTiles}
+    for i := range ppu.palettes {
+        base := uint(i * 7 + 5)
+        for c := uint(0); c < nbColors4Pal; c += 1 {
+            ppu.palettes[i].SetColor(c, (base + c * 21) % nbColors)
+        }
+    }
+    return ppu
+}
+
+
+// floor of tiles with both maps and several palettes
+func sceneMaps (ppu *PPU) {
+    for x := uint(0); x < mapSize; x += 1 {
+    for z := uint(0); z < mapSize; z += 1 {
+        ppu.maps[0].Set(z << 8 | 15 << 4 | x, uint8(1 + (x + z) % 2), 0, 0, uint8((x + z / 2) % 4))
+    }}
+    for i := uint(0); i < 5; i += 1 {
+        ppu.maps[0].Set(i << 8 | (14 - i) << 4 | (3 + i * 2), uint8(1 + i), 0, 0, uint8(i % 4))
+    }
+    ppu.maps[1].Set(0x000, 1, 0, 0, 3)
+}
+
+
+// coarse scrolling across the checkerboard of the 2 maps
+func sceneScroll (ppu *PPU) {
+    for i := uint(0); i < nbTiles; i += 7 {
+        ppu.maps[0].Set(i, 1, 0, 0, 0)
+        ppu.maps[1].Set(i, 2, 0, 0, 1)
+    }
+    ppu.scroll.Set(8 * 6, 8 * 11, 8 * 13)
+}
+
+
+// scrolling by less than a tile, cells enter on every axis
+func sceneFine (ppu *PPU) {
+    for i := uint(0); i < nbTiles; i += 5 {
+        ppu.maps[0].Set(i, 2, 0, 0, uint8(i % 4))
+        ppu.maps[1].Set(i, 4, 0, 0, uint8(i % 3))
+    }
+    ppu.scroll.Set(3, 5, 7)
+}
+
+
+// tiles rotated and mirrored along every axis
+func sceneRotated (ppu *PPU) {
+    for r := uint(0); r < 64; r += 1 {
+        cell := (r / 16) << 9 | ((r / 4) % 4) << 5 | (r % 4) << 1
+        ppu.maps[0].Set(cell, 3, uint8(r), 0, 0)
+    }
+    for m := uint(0); m < 8; m += 1 {
+        ppu.maps[0].Set(0xE00 | m << 1, 4, 0, uint8(m), 1)
+    }
+}
+
+
+// oriented sprites over the tile maps, some partially outside the screen
+func sceneSprites (ppu *PPU) {
+    sceneMaps(ppu)
+    positions := [][3]uint {{10, 20, 30}, {60, 60, 60}, {124, 8, 0}, {252, 100, 40}, {40, 250, 16}}
+    for i, p := range positions {
+        s := &ppu.sprites[i]
+        s.id_tile = 5
+        s.id_pal  = uint(i % 4)
+        s.pos.Set(p[0], p[1], p[2])
+        s.rot.SetByte(uint8(i * 13))
+        s.mir.SetByte(uint8(i))
+    }
+}
+
+
+// sprites sunk in the floor, only those with priority are seen
+func scenePriority (ppu *PPU) {
+    sceneMaps(ppu)
+    for i := uint(0); i < 4; i += 1 {
+        s := &ppu.sprites[i]
+        s.id_tile  = 5
+        s.id_pal   = i
+        s.priority = i % 2 == 1
+        s.pos.Set(16 + i * 24, 15 * 8, 40 + i * 16)
+    }
+}
+
+
+// render a scene with both cameras
+func (scene goldenScene) render () map[string]*image.RGBA {
+    ppu := goldenPPU()
+    scene.setup(ppu)
+
+    images := make(map[string]*image.RGBA)
+    front  := NewSoftRenderer(screenSize, screenSize, FrontCamera())
+    orbit  := NewSoftRenderer(goldenSize, goldenSize, OrbitCamera(35, 30))
+    ppu.Draw(front)
+    ppu.Draw(orbit)
+    images[scene.name + "-front.png"] = front.Image()
+    images[scene.name + "-orbit.png"] = orbit.Image()
+    return images
+}
+
+
+// read a PNG file
+func LoadPNG (path string) (image.Image, error) {
+    file, err := os.Open(path)
+    if err != nil {return nil, err}
+    defer file.Close()
+    return png.Decode(file)
+}
+
+
+// count the pixels that differ by more than goldenLevels on a channel
+//( images of different sizes differ on every pixel )
+func diffImages (a, b image.Image) int {
+    ra, rb := a.Bounds(), b.Bounds()
+    if ra.Size() != rb.Size() {
+        return ra.Dx() * ra.Dy()
+    }
+    count := 0
+    for y := 0; y < ra.Dy(); y += 1 {
+    for x := 0; x < ra.Dx(); x += 1 {
+        r1, g1, b1, a1 := a.At(ra.Min.X + x, ra.Min.Y + y).RGBA()
+        r2, g2, b2, a2 := b.At(rb.Min.X + x, rb.Min.Y + y).RGBA()
+        if farLevels(r1, r2) || farLevels(g1, g2) || farLevels(b1, b2) || farLevels(a1, a2) {count += 1}
+    }}
+    return count
+}
+
+
+// compare two channels of 16 bits as levels of 8 bits
+func farLevels (c1, c2 uint32) bool {
+    l1, l2 := int(c1 >> 8), int(c2 >> 8)
+    return l1 - l2 > goldenLevels || l2 - l1 > goldenLevels
+}
+
+
+// small differences of colors are ignored, not the pixels that change
+func TestDiffImages (t *testing.T) {
+    base := image.NewRGBA(image.Rect(0, 0, 4, 4))
+    for i := range base.Pix {base.Pix[i] = 0x80}
+    cases := []struct {
+        name  string
+        pix   int   // byte changed
+        value uint8
+        want  int
+    }{
+        {"same"        , 0 , 0x80, 0},
+        {"level off"   , 0 , 0x81, 0},
+        {"levels off"  , 5 , 0x7E, 0},
+        {"3 levels off", 6 , 0x83, 1},
+        {"other color" , 9 , 0xFF, 1},
+        {"alpha"       , 15, 0x00, 1},
+    }
+    for _, c := range cases {
+        img := image.NewRGBA(base.Rect)
+        copy(img.Pix, base.Pix)
+        img.Pix[c.pix] = c.value
+        if n := diffImages(base, img); n != c.want {t.Errorf("%s: %d pixels differ instead of %d", c.name, n, c.want)}
+    }
+    if n := diffImages(base, image.NewRGBA(image.Rect(0, 0, 4, 5))); n != 16 {t.Errorf("other size: %d pixels differ", n)}
+}
+
+
+// render the scenes and compare them with the golden images
+func TestGoldenImages (t *testing.T) {
+    dir := filepath.Join("testdata", "golden")
+    for _, scene := range goldenScenes {
+        scene := scene
+        t.Run(scene.name, func (t *testing.T) {
+            images := scene.render()
+            names  := make([]string, 0, len(images))
+            for name := range images {names = append(names, name)}
+            sort.Strings(names)
+
+            for _, name := range names {
+                img  := images[name]
+                path := filepath.Join(dir, name)
+                if *update {
+                    if err := SavePNG(path, img); err != nil {t.Fatal(err)}
+                    continue
+                }
+
+                golden, err := LoadPNG(path)
+                if err != nil {
+                    t.Errorf("%s: %v", name, err)
+                    continue
+                }
+                size := img.Bounds().Size()
+                if n := diffImages(golden, img); n > size.X * size.Y / goldenRatio {
+                    // keep the image rendered next to the golden one
+                    actual := path[:len(path) - len(".png")] + ".actual.png"
+                    if err := SavePNG(actual, img); err != nil {t.Fatal(err)}
+                    t.Errorf("%s: %d pixels differ, see %s", name, n, actual)
+                }
+            }
+        })
+    }
+}
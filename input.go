@@ -0,0 +1,47 @@
+package main
+
+/*
+    Controllers, programs read the buttons pressed on each of them
+    $00 first controller, $01 second controller
+
+    pressing a button raises a maskable interrupt (vector $FFFE)
+*/
+
+
+const nbPads = 2
+
+// buttons of a controller, one bit each
+const (
+    ButtonUp    = 1 << iota
+    ButtonDown
+    ButtonLeft
+    ButtonRight
+    ButtonFront
+    ButtonBack
+    ButtonA
+    ButtonB
+)
+
+
+type Input struct {
+    pads [nbPads]uint8
+    irq  func () // requests an interrupt of the processor
+}
+
+
+// set the buttons currently pressed on a controller
+//( releasing buttons does not interrupt the program )
+func (input *Input) SetButtons (pad int, buttons uint8) {
+    pressed := buttons &^ input.pads[pad]
+    input.pads[pad] = buttons
+    if pressed != 0 && input.irq != nil {input.irq()}
+}
+
+
+// read the buttons of a controller
+func (input *Input) Read (addr uint) uint8 {
+    return input.pads[addr % nbPads]
+}
+
+// controllers cannot be written
+func (input *Input) Write (addr uint, value uint8) {}
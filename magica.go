@@ -0,0 +1,294 @@
+package main
+
+/*
+    MagicaVoxel models (.vox files, little endian)
+
+    "VOX ", version, then a MAIN chunk whose children are the chunks
+    SIZE (x, y, z) and XYZI (count, then x, y, z, color of each voxel)
+    for every model and RGBA (256 colors, index i uses the color i - 1),
+    files using the default palette of MagicaVoxel have no RGBA chunk
+    a chunk is its id, the size of its content, the size of its children
+
+    MagicaVoxel is z-up, voxels are converted s.t. its x goes right,
+    its z goes up (y of the tiles goes down) and its y goes away
+    models are split in tiles of 8×8×8 in the order right, down, away,
+    colors become the nearest of the 3 colors of a palette
+    empty tiles at the end are dropped (the padding of the last row once exported)
+*/
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "bytes"
+    "strconv"
+    "strings"
+    "image/color"
+    "io/ioutil"
+    "encoding/binary"
+)
+
+
+const magicaMagic = "VOX "
+
+
+// model read from a MagicaVoxel file
+type MagicaModel struct {
+    Size   [3]int      // x, y, z of MagicaVoxel
+    Voxels [][4]uint8  // x, y, z, color index (1-255)
+}
+
+
+// default palette of MagicaVoxel: a cube of 6 levels per component
+// without black, then ramps of red, green, blue and gray
+func magicaPalette () [256]color.RGBA {
+    var rgba [256]color.RGBA
+    i := 1
+    for r := 5; r >= 0; r -= 1 {
+    for g := 5; g >= 0; g -= 1 {
+    for b := 5; b >= 0; b -= 1 {
+        if r + g + b == 0 {continue}
+        rgba[i] = color.RGBA{uint8(r * 0x33), uint8(g * 0x33), uint8(b * 0x33), 0xFF}
+        i += 1
+    }}}
+    for ramp := 0; ramp < 4; ramp += 1 {
+        for _, v := range []uint8{0xEE, 0xDD, 0xBB, 0xAA, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11} {
+            c := color.RGBA{A: 0xFF}
+            switch ramp {
+            case 0: c.R = v
+            case 1: c.G = v
+            case 2: c.B = v
+            case 3: c.R, c.G, c.B = v, v, v
+            }
+            rgba[i] = c
+            i += 1
+        }
+    }
+    return rgba
+}
+
+
+// read the models and the colors of a MagicaVoxel file
+func ReadMagica (r io.Reader) ([]MagicaModel, [256]color.RGBA, error) {
+    rgba := magicaPalette()
+    data, err := ioutil.ReadAll(r)
+    if err != nil {return nil, rgba, err}
+    if len(data) < 8 || string(data[:4]) != magicaMagic {
+        return nil, rgba, fmt.Errorf("Invalid vox: bad magic")
+    }
+    data = data[8:]
+
+    // chunks are read one after the other, the children of MAIN included
+    var models []MagicaModel
+    for len(data) > 0 {
+        if len(data) < 12 {return nil, rgba, fmt.Errorf("Invalid vox: truncated chunk")}
+        id      := string(data[:4])
+        size    := binary.LittleEndian.Uint32(data[4:])
+        content := data[12:]
+        if uint64(size) > uint64(len(content)) {
+            return nil, rgba, fmt.Errorf("Invalid vox: chunk %s of %d bytes is truncated", id, size)
+        }
+        content, data = content[:size], content[size:]
+
+        switch id {
+        case "SIZE":
+            if len(content) < 12 {return nil, rgba, fmt.Errorf("Invalid vox: SIZE too short")}
+            var m MagicaModel
+            for i := range m.Size {
+                m.Size[i] = int(binary.LittleEndian.Uint32(content[i * 4:]))
+                if m.Size[i] <= 0 || m.Size[i] > 256 {
+                    return nil, rgba, fmt.Errorf("Invalid vox: model of size %d", m.Size[i])
+                }
+            }
+            models = append(models, m)
+        case "XYZI":
+            if len(models) == 0 {return nil, rgba, fmt.Errorf("Invalid vox: XYZI before SIZE")}
+            if len(content) < 4 {return nil, rgba, fmt.Errorf("Invalid vox: XYZI too short")}
+            count := uint64(binary.LittleEndian.Uint32(content))
+            if count * 4 > uint64(len(content) - 4) {
+                return nil, rgba, fmt.Errorf("Invalid vox: expecting %d voxels", count)
+            }
+            m := &models[len(models) - 1]
+            for i := uint64(0); i < count; i += 1 {
+                var v [4]uint8
+                copy(v[:], content[4 + i * 4:])
+                m.Voxels = append(m.Voxels, v)
+            }
+        case "RGBA":
+            if len(content) < 1024 {return nil, rgba, fmt.Errorf("Invalid vox: RGBA too short")}
+            for i := 0; i < 255; i += 1 {
+                c := content[i * 4:]
+                rgba[i + 1] = color.RGBA{c[0], c[1], c[2], c[3]}
+            }
+        }
+    }
+
+    if len(models) == 0 {return nil, rgba, fmt.Errorf("Invalid vox: no model")}
+    return models, rgba, nil
+}
+
+
+// index (1-3) of the nearest color, 0 for transparent colors
+func nearestColor (c color.RGBA, colors [nbColors4Pal]color.RGBA) uint {
+    if c.A == 0 {return 0}
+    best, dist := uint(1), -1
+    for i, p := range colors {
+        dr, dg, db := int(c.R) - int(p.R), int(c.G) - int(p.G), int(c.B) - int(p.B)
+        if d := dr * dr + dg * dg + db * db; dist < 0 || d < dist {
+            best, dist = uint(i + 1), d
+        }
+    }
+    return best
+}
+
+
+// split the models in tiles and map their colors on a palette,
+// the empty tiles at the end are dropped
+func ImportMagica (models []MagicaModel, rgba [256]color.RGBA, colors [nbColors4Pal]color.RGBA) []Tile {
+    var tiles []Tile
+    for _, m := range models {
+        // size of the model in tiles: right, down, away
+        nx, ny, nz := (m.Size[0] + 7) / 8, (m.Size[2] + 7) / 8, (m.Size[1] + 7) / 8
+        first := len(tiles)
+        tiles = append(tiles, make([]Tile, nx * ny * nz)...)
+
+        for _, v := range m.Voxels {
+            x, y, z := int(v[0]), m.Size[2] - 1 - int(v[2]), int(v[1])
+            if x >= m.Size[0] || y < 0 || z >= m.Size[1] {continue}
+            tile := &tiles[first + ((z / 8) * ny + y / 8) * nx + x / 8]
+            tile.SetVoxel(x % 8, y % 8, z % 8, nearestColor(rgba[v[3]], colors))
+        }
+    }
+    for len(tiles) > 0 && tiles[len(tiles) - 1].rows == [nbRows]uint16{} {
+        tiles = tiles[:len(tiles) - 1]
+    }
+    return tiles
+}
+
+
+// write tiles side by side in a single model, 16 tiles per row
+func WriteMagica (w io.Writer, tiles []Tile, colors [nbColors4Pal]color.RGBA) error {
+    if len(tiles) == 0 {return fmt.Errorf("Cannot export vox: no tile")}
+    columns := len(tiles)
+    if columns > 16 {columns = 16}
+    rows := (len(tiles) + columns - 1) / columns
+
+    var voxels []uint8
+    for i := range tiles {
+        ox, oz := i % columns * 8, i / columns * 8
+        for z := 0; z < 8; z += 1 {
+        for y := 0; y < 8; y += 1 {
+        for x := 0; x < 8; x += 1 {
+            if c := tiles[i].GetVoxel(x, y, z); c != 0 {
+                voxels = append(voxels, uint8(ox + x), uint8(oz + z), uint8(7 - y), uint8(c))
+            }
+        }}}
+    }
+
+    var body bytes.Buffer
+    chunk := func (id string, content []uint8) {
+        body.WriteString(id)
+        binary.Write(&body, binary.LittleEndian, uint32(len(content)))
+        binary.Write(&body, binary.LittleEndian, uint32(0))
+        body.Write(content)
+    }
+    le := func (values ...int) []uint8 {
+        b := make([]uint8, len(values) * 4)
+        for i, v := range values {binary.LittleEndian.PutUint32(b[i * 4:], uint32(v))}
+        return b
+    }
+    chunk("SIZE", le(columns * 8, rows * 8, 8))
+    chunk("XYZI", append(le(len(voxels) / 4), voxels...))
+    palette := make([]uint8, 1024)
+    for i := 0; i < 255; i += 1 {palette[i * 4 + 3] = 0xFF}
+    for i, c := range colors {
+        copy(palette[i * 4:], []uint8{c.R, c.G, c.B, 0xFF})
+    }
+    chunk("RGBA", palette)
+
+    var out bytes.Buffer
+    out.WriteString(magicaMagic)
+    binary.Write(&out, binary.LittleEndian, uint32(150))
+    out.WriteString("MAIN")
+    binary.Write(&out, binary.LittleEndian, uint32(0))
+    binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+    out.Write(body.Bytes())
+    _, err := w.Write(out.Bytes())
+    return err
+}
+
+
+// read 3 colors written as rrggbb separated by commas
+func parseColors3 (text string) ([nbColors4Pal]color.RGBA, error) {
+    var colors [nbColors4Pal]color.RGBA
+    parts := strings.Split(text, ",")
+    if len(parts) != nbColors4Pal {
+        return colors, fmt.Errorf("Cannot read colors: expecting %d given %d", nbColors4Pal, len(parts))
+    }
+    for i, part := range parts {
+        v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(part), "#"), 16, 24)
+        if err != nil {return colors, fmt.Errorf("Cannot read colors: %q is not rrggbb", part)}
+        colors[i] = color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}
+    }
+    return colors, nil
+}
+
+
+// command line: vox-legacy voximport [-colors c1,c2,c3] [-o tiles.hex] model.vox
+func cmdVoxImport (args []string) error {
+    flags, text := colorFlags("voximport")
+    output := flags.String("o", "tiles.hex", "tiles to write, one per line in HEX (read by pack -tiles)")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {return fmt.Errorf("usage: vox-legacy voximport [-colors c1,c2,c3] [-o tiles.hex] model.vox")}
+
+    colors, err := parseColors3(*text)
+    if err != nil {return err}
+    file, err := os.Open(flags.Arg(0))
+    if err != nil {return err}
+    defer file.Close()
+    models, rgba, err := ReadMagica(file)
+    if err != nil {return fmt.Errorf("%s: %v", flags.Arg(0), err)}
+
+    var sb strings.Builder
+    tiles := ImportMagica(models, rgba, colors)
+    for i := range tiles {
+        sb.WriteString(tiles[i].HEX())
+        sb.WriteString("\n")
+    }
+    fmt.Printf("%d models split in %d tiles\n", len(models), len(tiles))
+    return ioutil.WriteFile(*output, []uint8(sb.String()), 0644)
+}
+
+
+// command line: vox-legacy voxexport [-bank n] [-colors c1,c2,c3] [-o model.vox] game.vox
+func cmdVoxExport (args []string) error {
+    flags, text := colorFlags("voxexport")
+    bank   := flags.Int   ("bank", 0          , "bank of tiles to export")
+    output := flags.String("o"   , "model.vox", "MagicaVoxel model to write")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {
+        return fmt.Errorf("usage: vox-legacy voxexport [-bank n] [-colors c1,c2,c3] [-o model.vox] game.vox")
+    }
+
+    colors, err := parseColors3(*text)
+    if err != nil {return err}
+    cart, err := LoadCartridge(flags.Arg(0))
+    if err != nil {return err}
+    if *bank < 0 || *bank >= len(cart.Tiles) {
+        return fmt.Errorf("Cannot export bank %d: the cartridge has %d banks", *bank, len(cart.Tiles))
+    }
+
+    // tile 0 is always clear, the others keep their index once packed again
+    var buf bytes.Buffer
+    if err := WriteMagica(&buf, cart.Tiles[*bank][1:], colors); err != nil {return err}
+    return ioutil.WriteFile(*output, buf.Bytes(), 0644)
+}
+
+
+// flags of a command converting colors with a palette
+func colorFlags (name string) (*flag.FlagSet, *string) {
+    flags := flag.NewFlagSet(name, flag.ContinueOnError)
+    text  := flags.String("colors", "555555,AAAAAA,FFFFFF", "the 3 colors of the palette as rrggbb")
+    return flags, text
+}
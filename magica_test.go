@@ -0,0 +1,90 @@
+package main
+
+import (
+    "os"
+    "bytes"
+    "testing"
+    "math/rand"
+    "image/color"
+    "path/filepath"
+    "encoding/binary"
+    "io/ioutil"
+)
+
+
+// a bank exported then imported gives the same tiles, once packed again
+func TestMagicaRoundTrip (t *testing.T) {
+    dir, err := ioutil.TempDir("", "magica")
+    if err != nil {t.Fatal(err)}
+    defer os.RemoveAll(dir)
+
+    rng := rand.New(rand.NewSource(3))
+    cases := []struct {
+        name string
+        used int // tiles not empty after tile 0, all written by voximport
+    }{
+        {"full bank" , nbBankTiles - 1},
+        {"16 tiles"  , 16},
+        {"20 tiles"  , 20},
+        {"empty bank", 0},
+    }
+    for _, c := range cases {
+        cart := &Cartridge{Tiles: make([]TileBank, 1)}
+        for i := 1; i <= c.used; i += 1 {
+            cart.Tiles[0][i] = randomTile(rng, 1 + i % 8, 3)
+        }
+        game, model, hex := filepath.Join(dir, "game.vox"), filepath.Join(dir, "model.vox"), filepath.Join(dir, "back.hex")
+        if err := cart.Save(game); err != nil {t.Fatal(err)}
+
+        if err := cmdVoxExport([]string{"-o", model, game}); err != nil {t.Fatalf("%s: %v", c.name, err)}
+        if err := cmdVoxImport([]string{"-o", hex, model}); err != nil {t.Fatalf("%s: %v", c.name, err)}
+        text, err := ioutil.ReadFile(hex)
+        if err != nil {t.Fatal(err)}
+        if n := bytes.Count(text, []uint8("\n")); n != c.used {
+            t.Errorf("%s: %d tiles imported instead of %d", c.name, n, c.used)
+        }
+
+        back := &Cartridge{Tiles: make([]TileBank, 1)}
+        if err := back.loadTiles(hex); err != nil {t.Errorf("%s: %v", c.name, err); continue}
+        for i := range back.Tiles[0] {
+            if back.Tiles[0][i].rows != cart.Tiles[0][i].rows {t.Errorf("%s: tile %d differs", c.name, i)}
+        }
+    }
+}
+
+
+// files without RGBA chunk use the default palette of MagicaVoxel
+func TestReadMagicaDefaultPalette (t *testing.T) {
+    tiles := []Tile{voxelTile(1, 2, 3, 1), voxelTile(4, 5, 6, 2), voxelTile(7, 0, 1, 3)}
+    var buf bytes.Buffer
+    if err := WriteMagica(&buf, tiles, [nbColors4Pal]color.RGBA{}); err != nil {t.Fatal(err)}
+
+    // the RGBA chunk is the last child of MAIN
+    data := buf.Bytes()
+    data  = data[:len(data) - 12 - 1024]
+    binary.LittleEndian.PutUint32(data[16:], uint32(len(data) - 20))
+
+    models, rgba, err := ReadMagica(bytes.NewReader(data))
+    if err != nil {t.Fatal(err)}
+
+    // words 0xAABBGGRR copied from the default palette published with the
+    // file format, the ramps start with red at 216
+    words := map[int]uint32{
+        0  : 0x00000000, 1  : 0xffffffff, 2  : 0xffccffff, 7  : 0xffffccff,
+        15 : 0xff9999ff, 37 : 0xffffffcc, 215: 0xff330000,
+        216: 0xff0000ee, 225: 0xff000011, 226: 0xff00ee00, 235: 0xff001100,
+        236: 0xffee0000, 245: 0xff110000, 246: 0xffeeeeee, 255: 0xff111111,
+    }
+    for index, w := range words {
+        want := color.RGBA{uint8(w), uint8(w >> 8), uint8(w >> 16), uint8(w >> 24)}
+        if rgba[index] != want {t.Errorf("color %d is %v instead of %v", index, rgba[index], want)}
+    }
+
+    // the voxels keep the colors of the default palette
+    colors := [nbColors4Pal]color.RGBA{rgba[1], rgba[2], rgba[3]}
+    back   := ImportMagica(models, rgba, colors)
+    if len(back) != len(tiles) {t.Fatalf("%d tiles imported instead of %d", len(back), len(tiles))}
+    for i := range tiles {
+        if back[i].rows != tiles[i].rows {t.Errorf("tile %d differs", i)}
+    }
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
 	//"strings"
 	//"runtime"
@@ -15,15 +17,84 @@ const (
     height = 512
 )
 
+// commands available from the command line
+var commands = map[string]func ([]string) error {
+    "asm"       : cmdAssemble,
+    "disasm"    : cmdDisassemble,
+    "debug"     : cmdDebug,
+    "pack"      : cmdPack,
+    "run"       : cmdRun,
+    "render"    : cmdRender,
+    "mesh"      : cmdMesh,
+    "voximport" : cmdVoxImport,
+    "voxexport" : cmdVoxExport,
+    "trace"     : cmdTrace,
+    "tracediff" : cmdTraceDiff,
+    "export"    : cmdExport,
+    "colors"    : cmdColors,
+    "wav"       : cmdWav,
+}
+
+
 // main function
 func main () {
-    window := InitGlfw()
+    // run a command instead of opening a window
+    if len(os.Args) > 1 {
+        cmd, ok := commands[os.Args[1]]
+        if !ok {
+            fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
+            os.Exit(2)
+        }
+        if err := cmd(os.Args[2:]); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    Play(NewConsole(), "", "")
+}
+
+
+// open a window and run the console until it is closed
+//( F5 and F9 save and restore the console in the quick save file )
+func Play (con *Console, title, quickSave string) {
+    window := InitGlfw(title)
     defer glfw.Terminate()
     InitOpenGL()
 
+    renderer := GLRenderer{Mode: MeshGreedy, Program: CreateProgram()}
+    if con.audio == nil && AudioBackend != nil {
+        out, err := AudioBackend(con.apu.Rate)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+        } else {
+            con.SetAudio(out)
+            if closer, ok := out.(io.Closer); ok {defer closer.Close()}
+        }
+    }
+    var saveKey, loadKey, halted bool
     for !window.ShouldClose() {
 		t := time.Now()
 
+        // react when the keys are pressed, not while they are held
+        save := window.GetKey(glfw.KeyF5) == glfw.Press
+        load := window.GetKey(glfw.KeyF9) == glfw.Press
+        if quickSave != "" && save && !saveKey {
+            if err := con.SaveStateFile(quickSave); err != nil {fmt.Fprintln(os.Stderr, err)}
+        }
+        if quickSave != "" && load && !loadKey {
+            if err := con.LoadStateFile(quickSave); err != nil {fmt.Fprintln(os.Stderr, err)}
+        }
+        saveKey, loadKey = save, load
+
+        con.input.SetButtons(0, ReadKeys(window))
+        // a fault is reported once, the processor stays halted until a state is restored
+        err := con.Frame()
+        if err != nil && !halted {fmt.Fprintln(os.Stderr, err)}
+        halted = err != nil
+        con.ppu.Draw(&renderer)
+        window.SwapBuffers()
         glfw.PollEvents()
 		time.Sleep(time.Second/time.Duration(FPS) - time.Since(t))
     }
@@ -31,7 +102,7 @@ func main () {
 
 
 // initializes glfw and returns a Window to use.
-func InitGlfw () *glfw.Window {
+func InitGlfw (title string) *glfw.Window {
     if err := glfw.Init(); err != nil {
 		panic(err)
     }
@@ -43,7 +114,8 @@ func InitGlfw () *glfw.Window {
     glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
     glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 
-    window, err := glfw.CreateWindow(width, height, "Vox-Legacy", nil, nil)
+    if title != "" {title = " - " + title}
+    window, err := glfw.CreateWindow(width, height, "Vox-Legacy" + title, nil, nil)
     if err != nil {
 		panic(err)
     }
@@ -59,4 +131,33 @@ func InitOpenGL () {
     }
     version := gl.GoStr(gl.GetString(gl.VERSION))
     fmt.Println("OpenGL version", version)
+
+    // a vertex array is required by the core profile
+    var vao uint32
+    gl.GenVertexArrays(1, &vao)
+    gl.BindVertexArray(vao)
+    gl.Enable(gl.DEPTH_TEST)
+    gl.Enable(gl.CULL_FACE)
+}
+
+
+// keys of the keyboard used as the first controller
+var keyButtons = map[glfw.Key]uint8 {
+    glfw.KeyUp      : ButtonUp,
+    glfw.KeyDown    : ButtonDown,
+    glfw.KeyLeft    : ButtonLeft,
+    glfw.KeyRight   : ButtonRight,
+    glfw.KeyPageUp  : ButtonFront,
+    glfw.KeyPageDown: ButtonBack,
+    glfw.KeyZ       : ButtonA,
+    glfw.KeyX       : ButtonB,
+}
+
+// read the buttons pressed on the keyboard
+func ReadKeys (window *glfw.Window) uint8 {
+    var buttons uint8
+    for key, button := range keyButtons {
+        if window.GetKey(key) == glfw.Press {buttons |= button}
+    }
+    return buttons
 }
@@ -0,0 +1,159 @@
+package main
+
+/*
+    Mappers of the cartridges, mapped over the ROM of the console
+    programs select the banks of program and of tiles that are visible
+    by writing in the ROM
+
+    0  none    up to 48 KiB of program, a single bank of tiles
+    1  switch  banks of 16 KiB, $4000-$7FFF is switchable and the
+               last 32 KiB are fixed at $8000-$FFFF
+               write in $4000-$7FFF: select the program bank
+               write in $8000-$FFFF: select the tile bank
+    2  multi   banks of 8 KiB, four switchable windows at
+               $4000 $6000 $8000 $A000 and the last 16 KiB fixed
+               at $C000-$FFFF, writes select a bank depending on
+               the lower bits of the address
+               0-3: program bank of the window   4: tile bank
+*/
+
+import (
+    "fmt"
+)
+
+
+const (
+    MapperNone   = 0
+    MapperSwitch = 1
+    MapperMulti  = 2
+)
+
+
+// bank of tiles used by the PPU
+type TileBank [nbBankTiles]Tile
+
+
+// hardware of a cartridge deciding which banks are visible
+type Mapper interface {
+    Device
+    Tiles () *TileBank // tiles currently visible to the PPU
+
+    // registers selecting the banks (used by save states)
+    Registers () []uint8
+    SetRegisters (regs []uint8)
+}
+
+
+// create the mapper described by the cartridge
+func NewMapper (cart *Cartridge) (Mapper, error) {
+    if len(cart.Tiles) == 0 {
+        return nil, fmt.Errorf("Cannot create mapper: no tile bank")
+    }
+    switch cart.Mapper {
+    case MapperNone:
+        return &mapperNone{ROM{make([]uint8, sizeROM)}, &cart.Tiles[0]}, nil
+    case MapperSwitch:
+        return newMapperBanked(cart, 0x4000, 1)
+    case MapperMulti:
+        return newMapperBanked(cart, 0x2000, 4)
+    }
+    return nil, fmt.Errorf("Cannot create mapper: unknown mapper %d", cart.Mapper)
+}
+
+
+// program read-only and a single bank of tiles
+type mapperNone struct {
+    ROM
+    tiles *TileBank
+}
+
+func (m *mapperNone) Tiles () *TileBank {
+    return m.tiles
+}
+
+func (m *mapperNone) Registers () []uint8 {
+    return nil
+}
+
+func (m *mapperNone) SetRegisters (regs []uint8) {}
+
+
+// program split in banks visible through switchable windows
+//( the banks after the windows are fixed at the end of the memory )
+type mapperBanked struct {
+    prg     []uint8
+    tiles   []TileBank
+    size    uint    // size of a bank
+    windows []uint8 // bank visible in each window
+    tile    uint8   // bank of tiles visible
+}
+
+
+func newMapperBanked (cart *Cartridge, size uint, windows int) (*mapperBanked, error) {
+    fixed := sizeROM - size * uint(windows)
+    if uint(len(cart.Program)) % size != 0 || uint(len(cart.Program)) < fixed + size {
+        return nil, fmt.Errorf(
+            "Cannot create mapper %d: program must be a multiple of %d bytes larger than %d",
+            cart.Mapper, size, fixed)
+    }
+    m := &mapperBanked {
+        prg:     cart.Program,
+        tiles:   cart.Tiles,
+        size:    size,
+        windows: make([]uint8, windows),
+    }
+    return m, nil
+}
+
+
+// number of banks of program
+func (m *mapperBanked) nbBanks () uint {
+    return uint(len(m.prg)) / m.size
+}
+
+
+// read from the window containing the address
+func (m *mapperBanked) Read (addr uint) uint8 {
+    window := addr / m.size
+    if window < uint(len(m.windows)) {
+        bank := uint(m.windows[window]) % m.nbBanks()
+        return m.prg[bank * m.size + addr % m.size]
+    }
+    // fixed banks at the end of the program
+    return m.prg[uint(len(m.prg)) - sizeROM + addr]
+}
+
+
+// select the banks visible
+func (m *mapperBanked) Write (addr uint, value uint8) {
+    if len(m.windows) == 1 {
+        if addr < m.size {
+            m.windows[0] = value
+        } else {
+            m.tile = value
+        }
+        return
+    }
+    reg := addr & 0x7
+    if reg < uint(len(m.windows)) {
+        m.windows[reg] = value
+    } else if reg == uint(len(m.windows)) {
+        m.tile = value
+    }
+}
+
+
+func (m *mapperBanked) Tiles () *TileBank {
+    return &m.tiles[uint(m.tile) % uint(len(m.tiles))]
+}
+
+
+func (m *mapperBanked) Registers () []uint8 {
+    return append(append([]uint8(nil), m.windows...), m.tile)
+}
+
+
+func (m *mapperBanked) SetRegisters (regs []uint8) {
+    n := copy(m.windows, regs)
+    if n < len(regs) {m.tile = regs[n]}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// cartridge whose banks are filled with their number,
+// tile 1 of each bank of tiles is marked with the number of the bank
+func bankedCartridge (mapper uint8, bankSize, banks, tileBanks int) *Cartridge {
+    cart := &Cartridge {
+        Mapper:  mapper,
+        Entry:   addrROM,
+        Origin:  addrROM,
+        Program: make([]uint8, bankSize * banks),
+        Tiles:   make([]TileBank, tileBanks),
+    }
+    for i := range cart.Program {
+        cart.Program[i] = uint8(i / bankSize)
+    }
+    for b := range cart.Tiles {
+        cart.Tiles[b][1].rows[0] = uint16(b)
+    }
+    return cart
+}
+
+
+// bank of program seen at an address and bank of tiles seen by the PPU
+func visibleBanks (con *Console, addr uint) (uint8, uint16) {
+    return uint8(con.bus.GetByte(addr)), con.ppu.Tiles()[1].rows[0]
+}
+
+
+type bankCase struct {
+    write uint           // address written, 0 to only read
+    value uint8
+    banks map[uint]uint8 // bank of program expected at addresses
+    tiles uint16         // bank of tiles expected
+}
+
+
+func checkBanks (t *testing.T, name string, cart *Cartridge, cases []bankCase) {
+    con := NewConsole()
+    if err := con.Insert(cart); err != nil {t.Fatalf("%s: %v", name, err)}
+    for _, c := range cases {
+        if c.write != 0 {con.bus.Write(c.write, uint(c.value))}
+        for addr, want := range c.banks {
+            if bank, _ := visibleBanks(con, addr); bank != want {
+                t.Errorf("%s: after $%02X in $%04X, $%04X shows bank %d instead of %d",
+                    name, c.value, c.write, addr, bank, want)
+            }
+        }
+        if _, tiles := visibleBanks(con, 0); tiles != c.tiles {
+            t.Errorf("%s: after $%02X in $%04X, tiles of bank %d instead of %d",
+                name, c.value, c.write, tiles, c.tiles)
+        }
+    }
+}
+
+
+// without mapper the program is copied at its origin and writes are ignored
+func TestMapperNone (t *testing.T) {
+    cart := bankedCartridge(MapperNone, 0x10, 3, 1)
+    checkBanks(t, "none", cart, []bankCase {
+        {banks: map[uint]uint8{0x4000: 0, 0x4010: 1, 0x402F: 2, 0x4030: 0}},
+        {write: 0x4000, value: 2, banks: map[uint]uint8{0x4000: 0, 0x4010: 1}},
+        {write: 0x8000, value: 1, banks: map[uint]uint8{0x8000: 0}},
+    })
+}
+
+
+// 4 banks of 16 KiB, the last two are fixed at $8000-$FFFF
+func TestMapperSwitch (t *testing.T) {
+    cart := bankedCartridge(MapperSwitch, 0x4000, 4, 3)
+    fixed := map[uint]uint8{0x8000: 2, 0xBFFF: 2, 0xC000: 3, 0xFFFF: 3}
+    with  := func (addr uint, bank uint8) map[uint]uint8 {
+        banks := map[uint]uint8{addr: bank}
+        for a, b := range fixed {banks[a] = b}
+        return banks
+    }
+    checkBanks(t, "switch", cart, []bankCase {
+        {banks: with(0x4000, 0)},
+        {write: 0x4000, value: 1, banks: with(0x7FFF, 1)},
+        {write: 0x7FFF, value: 3, banks: with(0x4000, 3)},
+        {write: 0x5000, value: 6, banks: with(0x4000, 2)}, // wraps around 4 banks
+        {write: 0x8000, value: 2, banks: with(0x4000, 2), tiles: 2},
+        {write: 0xFFFF, value: 4, banks: with(0x4000, 2), tiles: 1}, // wraps around 3 banks
+    })
+}
+
+
+// 8 banks of 8 KiB in four windows, the last two are fixed at $C000-$FFFF
+func TestMapperMulti (t *testing.T) {
+    cart := bankedCartridge(MapperMulti, 0x2000, 8, 2)
+    checkBanks(t, "multi", cart, []bankCase {
+        {banks: map[uint]uint8{0x4000: 0, 0x6000: 0, 0x8000: 0, 0xA000: 0, 0xC000: 6, 0xFFFF: 7}},
+        {write: 0x4001, value: 3, banks: map[uint]uint8{0x4000: 0, 0x6000: 3, 0x7FFF: 3, 0x8000: 0}},
+        {write: 0xC002, value: 5, banks: map[uint]uint8{0x6000: 3, 0x8000: 5, 0x9FFF: 5}},
+        {write: 0x4003, value: 7, banks: map[uint]uint8{0xA000: 7, 0xBFFF: 7, 0xC000: 6}},
+        {write: 0x4000, value: 9, banks: map[uint]uint8{0x4000: 1}}, // wraps around 8 banks
+        {write: 0x4004, value: 1, banks: map[uint]uint8{0x4000: 1}, tiles: 1},
+        {write: 0x400C, value: 2, banks: map[uint]uint8{0x4000: 1}, tiles: 0}, // wraps around 2 banks
+        {write: 0x4005, value: 4, banks: map[uint]uint8{0x4000: 1, 0x6000: 3, 0x8000: 5, 0xA000: 7}},
+        {write: 0x4007, value: 4, banks: map[uint]uint8{0x4000: 1, 0x6000: 3, 0x8000: 5, 0xA000: 7}},
+    })
+}
+
+
+// the registers saved in states select the same banks again
+func TestMapperRegisters (t *testing.T) {
+    cart := bankedCartridge(MapperMulti, 0x2000, 8, 2)
+    con  := NewConsole()
+    if err := con.Insert(cart); err != nil {t.Fatal(err)}
+    con.bus.Write(0x4002, 4)
+    con.bus.Write(0x4004, 1)
+    regs := con.mapper.Registers()
+
+    other := NewConsole()
+    if err := other.Insert(cart); err != nil {t.Fatal(err)}
+    other.mapper.SetRegisters(regs)
+    for addr := uint(addrROM); addr < memSize; addr += 0x1000 {
+        if a, b := con.bus.GetByte(addr), other.bus.GetByte(addr); a != b {
+            t.Errorf("$%04X shows bank %d instead of %d", addr, b, a)
+        }
+    }
+    if _, tiles := visibleBanks(other, 0); tiles != 1 {t.Errorf("tiles of bank %d instead of 1", tiles)}
+}
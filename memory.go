@@ -1,23 +1,55 @@
 package main
 
+
+// number of addressable bytes
+const memSize = 0x10000
+
+
+// random access memory
 type Memory struct {
-    data [0x10000]uint8
+    data []uint8
+}
+
+// create a memory of the given size
+func NewMemory (size uint) *Memory {
+    return &Memory{make([]uint8, size)}
 }
 
 // return a single byte from the memory
-func (ram Memory) GetByte (index uint) uint {
-    return uint(ram.data[index])
+func (ram *Memory) Read (addr uint) uint8 {
+    return ram.data[addr]
 }
 
-// return two bytes from the memory (usually an address)
-func (ram Memory) GetAddress (index uint) uint {
-    high := uint(ram.data[index    ])
-    low  := uint(ram.data[index + 1])
-    return (high << 8) | low
+// write a byte in the memory
+func (ram *Memory) Write (addr uint, value uint8) {
+    ram.data[addr] = value
+}
+
+// write a byte when loading an image
+func (ram *Memory) Poke (addr uint, value uint8) {
+    ram.data[addr] = value
 }
 
 
-// write a byte in the memory
-func (ram Memory) Write (index, value uint) {
-    ram.data[index] = uint8(value)
+// read-only memory, programs cannot modify it
+type ROM struct {
+    data []uint8
+}
+
+// create a read-only memory of the given size
+func NewROM (size uint) *ROM {
+    return &ROM{make([]uint8, size)}
+}
+
+// return a single byte from the memory
+func (rom *ROM) Read (addr uint) uint8 {
+    return rom.data[addr]
+}
+
+// writes from programs are ignored
+func (rom *ROM) Write (addr uint, value uint8) {}
+
+// write a byte when loading an image
+func (rom *ROM) Poke (addr uint, value uint8) {
+    rom.data[addr] = value
 }
@@ -0,0 +1,158 @@
+package main
+
+/*
+    Meshers turning the voxels of a tile into triangles
+
+    faces   two triangles for every visible face of a voxel
+    greedy  visible faces in the same plane and of the same color
+            are merged in rectangles, fewer vertices to draw
+*/
+
+import (
+    "fmt"
+    "flag"
+    "time"
+)
+
+
+type MeshMode int
+
+const (
+    MeshFaces  MeshMode = iota
+    MeshGreedy
+)
+
+
+// vertices built for a part of the scene
+type Mesh struct {
+    Vertices []uint8 // x, y, z, palette << 2 | color
+    VBO      uint32  // buffer in OpenGL
+    uploaded bool    // the buffer holds the vertices
+}
+
+
+// replace the vertices, the buffer is uploaded again when drawn
+func (mesh *Mesh) SetVertices (vertices []uint8) {
+    mesh.Vertices = vertices
+    mesh.uploaded = false
+}
+
+
+// merge the visible faces of each slice in the largest rectangles
+func (tile *Tile) greedyMesh () []uint8 {
+    var vertices []uint8
+    for _, def := range faceDefs {
+        // axis of the normal and axes of the plane of the face
+        n := 0
+        if def.iy != 0 {n = 1}
+        if def.iz != 0 {n = 2}
+        u, v := (n + 1) % 3, (n + 2) % 3
+
+        for s := 0; s < 8; s += 1 {
+            // colors of the visible faces of the slice
+            var mask [8][8]uint
+            for a := 0; a < 8; a += 1 {
+            for b := 0; b < 8; b += 1 {
+                var p [3]int
+                p[n], p[u], p[v] = s, a, b
+                color := tile.GetVoxel(p[0], p[1], p[2])
+                if color != 0 && tile.GetVoxel(p[0] + def.ix, p[1] + def.iy, p[2] + def.iz) == 0 {
+                    mask[a][b] = color
+                }
+            }}
+
+            // grow rectangles along u then along v
+            for b := 0; b < 8; b += 1 {
+            for a := 0; a < 8; a += 1 {
+                color := mask[a][b]
+                if color == 0 {continue}
+
+                w := 1
+                for a + w < 8 && mask[a + w][b] == color {w += 1}
+                h := 1
+                for grow := true; grow && b + h < 8; {
+                    for i := a; i < a + w; i += 1 {
+                        if mask[i][b + h] != color {grow = false; break}
+                    }
+                    if grow {h += 1}
+                }
+                for j := b; j < b + h; j += 1 {
+                for i := a; i < a + w; i += 1 {
+                    mask[i][j] = 0
+                }}
+
+                // stretch the vertices of the face over the rectangle
+                for k := 0; k < nbVerts4Face; k += 1 {
+                    var c [3]int
+                    for axis := range c {c[axis] = int(def.face[k * 3 + axis])}
+                    var p [3]int
+                    p[n] = c[n] + s
+                    p[u] = a + c[u] * w
+                    p[v] = b + c[v] * h
+                    vertices = append(vertices, uint8(p[0]), uint8(p[1]), uint8(p[2]), uint8(color))
+                }
+            }}
+        }
+    }
+    return vertices
+}
+
+
+// command line: vox-legacy mesh [-n runs] game.vox
+func cmdMesh (args []string) error {
+    flags := flag.NewFlagSet("mesh", flag.ContinueOnError)
+    runs  := flags.Int("n", 100, "number of times every bank is meshed to measure the time")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 || *runs <= 0 {return fmt.Errorf("usage: vox-legacy mesh [-n runs] game.vox")}
+
+    cart, err := LoadCartridge(flags.Arg(0))
+    if err != nil {return err}
+
+    // compare the number of vertices and the time to build the meshes
+    for _, mode := range []struct {name string; mode MeshMode} {{"faces", MeshFaces}, {"greedy", MeshGreedy}} {
+        verts := 0
+        start := time.Now()
+        for run := 0; run < *runs; run += 1 {
+            verts = 0
+            for b := range cart.Tiles {
+                for i := range cart.Tiles[b] {
+                    verts += len(cart.Tiles[b][i].BuildMesh(mode.mode)) / nbCoords4Vert
+                }
+            }
+        }
+        elapsed := time.Since(start) / time.Duration(*runs)
+        fmt.Printf("%-6s %8d vertices %12v per build of %d banks\n", mode.name, verts, elapsed, len(cart.Tiles))
+    }
+
+    // compare drawing the maps tile by tile and chunk by chunk
+    tiles := &cart.Tiles[0]
+    for i := range cart.Maps {
+        tm := &cart.Maps[i]
+        cells, chunks := 0, 0
+        for c := uint(0); c < nbTiles; c += 1 {
+            if tm.tils[c] != 0 {cells += 1}
+        }
+
+        start := time.Now()
+        for run := 0; run < *runs; run += 1 {
+            tm.Invalidate()
+            tm.Update(tiles, MeshGreedy)
+        }
+        full := time.Since(start) / time.Duration(*runs)
+        for c := range tm.chunks {
+            if len(tm.chunks[c].Vertices) != 0 {chunks += 1}
+        }
+
+        // a single cell modified only rebuilds its chunk
+        start = time.Now()
+        for run := 0; run < *runs; run += 1 {
+            til, rot, mir, pal := tm.Get(0)
+            tm.Set(0, til, rot, mir, pal)
+            tm.Update(tiles, MeshGreedy)
+        }
+        single := time.Since(start) / time.Duration(*runs)
+        fmt.Printf("map %d  %5d tile draws, %2d chunk draws, %12v per build, %12v per cell modified\n",
+            i, cells, chunks, full, single)
+    }
+    return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "testing"
+    "math/rand"
+)
+
+
+// unit square of a visible face, facing the side of its normal
+type unitFace struct {
+    axis    int  // axis of the normal
+    outside bool // the normal points toward the larger coordinates
+    plane   int
+    a, b    int  // corner on the two other axes
+    color   uint8
+}
+
+
+// split the rectangles of a mesh in unit squares, each counted once
+func meshSurface (t *testing.T, vertices []uint8) map[unitFace]int {
+    surface := make(map[unitFace]int)
+    const quad = nbVerts4Face * nbCoords4Vert
+    if len(vertices) % quad != 0 {
+        t.Fatalf("%d coordinates do not make rectangles", len(vertices))
+    }
+    for q := 0; q < len(vertices); q += quad {
+        vert := func (i int) [3]int {
+            v := vertices[q + i * nbCoords4Vert:]
+            return [3]int{int(v[0]), int(v[1]), int(v[2])}
+        }
+
+        // bounds of the rectangle, flat along the axis of the normal
+        lo, hi := vert(0), vert(0)
+        for i := 1; i < nbVerts4Face; i += 1 {
+            p := vert(i)
+            for k := range p {
+                if p[k] < lo[k] {lo[k] = p[k]}
+                if p[k] > hi[k] {hi[k] = p[k]}
+            }
+        }
+        axis := -1
+        for k := range lo {
+            if lo[k] == hi[k] {axis = k}
+        }
+        if axis < 0 {t.Fatalf("rectangle %d is not flat", q / quad)}
+        u, v := (axis + 1) % 3, (axis + 2) % 3
+
+        // both triangles face the same side
+        var side [2]int
+        for tri := range side {
+            p0, p1, p2 := vert(tri * 3), vert(tri * 3 + 1), vert(tri * 3 + 2)
+            side[tri] = (p1[u] - p0[u]) * (p2[v] - p0[v]) - (p1[v] - p0[v]) * (p2[u] - p0[u])
+        }
+        if side[0] == 0 || (side[0] > 0) != (side[1] > 0) {
+            t.Fatalf("triangles of rectangle %d do not face the same side", q / quad)
+        }
+
+        for a := lo[u]; a < hi[u]; a += 1 {
+        for b := lo[v]; b < hi[v]; b += 1 {
+            surface[unitFace{axis, side[0] > 0, lo[axis], a, b, vertices[q + 3]}] += 1
+        }}
+    }
+    return surface
+}
+
+
+// tile with random voxels, fewer colors make larger surfaces to merge
+func randomTile (rng *rand.Rand, density, colors int) Tile {
+    var tile Tile
+    for z := 0; z < 8; z += 1 {
+    for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+        if rng.Intn(8) < density {tile.SetVoxel(x, y, z, uint(1 + rng.Intn(colors)))}
+    }}}
+    return tile
+}
+
+
+// the greedy mesher covers the faces of the voxels exactly once
+func TestGreedyMeshSurface (t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    for i := 0; i < 500; i += 1 {
+        tile := randomTile(rng, i % 9, 1 + i % 3)
+        faces  := tile.BuildMesh(MeshFaces)
+        greedy := tile.BuildMesh(MeshGreedy)
+        if len(greedy) > len(faces) {
+            t.Errorf("tile %d: %d vertices merged into %d", i, len(faces) / nbCoords4Vert, len(greedy) / nbCoords4Vert)
+        }
+
+        want, got := meshSurface(t, faces), meshSurface(t, greedy)
+        for face, n := range got {
+            if n != 1 || want[face] != 1 {
+                t.Fatalf("tile %d: face %+v covered %d times instead of %d", i, face, n, want[face])
+            }
+        }
+        if len(got) != len(want) {
+            t.Fatalf("tile %d: %d unit faces instead of %d", i, len(got), len(want))
+        }
+    }
+}
+
+
+func TestMeshVertexCount (t *testing.T) {
+    var empty, single, line, cube, block, checker, stripes Tile
+    single.SetVoxel(3, 4, 5, 2)
+    for x := 0; x < 8; x += 1 {line.SetVoxel(x, 0, 0, 1)}
+    for z := 0; z < 8; z += 1 {
+    for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+        if x < 2 && y < 2 && z < 2 {cube.SetVoxel(x, y, z, 3)}
+        block.SetVoxel(x, y, z, 1)
+        if (x + y + z) % 2 == 0 {checker.SetVoxel(x, y, z, 1)}
+        stripes.SetVoxel(x, y, z, uint(1 + y % 3))
+    }}}
+
+    cases := []struct {
+        name          string
+        tile          *Tile
+        faces, greedy int // number of vertices
+    }{
+        {"empty"  , &empty  , 0, 0},
+        {"single" , &single , 36, 36},
+        {"line"   , &line   , 34 * 6, 6 * 6},
+        {"cube"   , &cube   , 24 * 6, 6 * 6},
+        {"block"  , &block  , 6 * 64 * 6, 6 * 6},
+        {"checker", &checker, 256 * 36, 256 * 36}, // no two faces touch
+        {"stripes", &stripes, 6 * 64 * 6, (2 + 8 * 4) * 6}, // a rectangle per row on the sides
+    }
+    for _, c := range cases {
+        faces  := len(c.tile.BuildMesh(MeshFaces )) / nbCoords4Vert
+        greedy := len(c.tile.BuildMesh(MeshGreedy)) / nbCoords4Vert
+        if faces != c.faces || greedy != c.greedy {
+            t.Errorf("%s: %d and %d vertices instead of %d and %d", c.name, faces, greedy, c.faces, c.greedy)
+        }
+    }
+}
+
+
+// tiles of every density to mesh in the benchmarks
+func benchTiles () []Tile {
+    rng   := rand.New(rand.NewSource(2))
+    tiles := make([]Tile, nbBankTiles)
+    for i := range tiles {
+        tiles[i] = randomTile(rng, i % 9, 1 + i % 3)
+    }
+    return tiles
+}
+
+
+func benchmarkBuildMesh (b *testing.B, mode MeshMode) {
+    tiles := benchTiles()
+    b.ResetTimer()
+    for n := 0; n < b.N; n += 1 {
+        tiles[n % len(tiles)].BuildMesh(mode)
+    }
+}
+
+func BenchmarkBuildMeshFaces (b *testing.B) {
+    benchmarkBuildMesh(b, MeshFaces)
+}
+
+func BenchmarkBuildMeshGreedy (b *testing.B) {
+    benchmarkBuildMesh(b, MeshGreedy)
+}
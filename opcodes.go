@@ -0,0 +1,185 @@
+package main
+
+/*
+    Opcode map of the Processor
+    (shared by the assembler and the disassembler)
+
+    the two lower bits of most instructions select a register (A, X, Y, Z)
+    or a flag (zero, negative, carry, overflow), the third bit selects
+    between a register and a memory operand
+*/
+
+
+// base value of every instruction family
+const (
+    opNOP  = 0x00 // no operation
+    opSTR  = 0x40 // STR r, addr
+    opSTRI = 0x44 // STR addr[,i]
+    opLOD  = 0x48 // LOD r, addr
+    opLODI = 0x4C // LOD addr[,i]
+    opLODN = 0x50 // LOD r, #n
+    opPSH  = 0x54 // PSH r
+    opPLL  = 0x58 // PLL r
+    opJMP  = 0x5C // JMP addr
+    opCALL = 0x5D // CALL addr
+    opRTN  = 0x5E // RTN
+    opTRS  = 0x60 // TRS r, r
+    opINC  = 0x70 // INC r | addr[,i]
+    opDEC  = 0x78 // DEC r | addr[,i]
+    opSHL  = 0x80 // SHL r | addr[,i]
+    opSHR  = 0x88 // SHR r | addr[,i]
+    opROL  = 0x90 // ROL r | addr[,i]
+    opROR  = 0x98 // ROR r | addr[,i]
+    opNOT  = 0xA0 // NOT r | addr[,i]
+    opRTI  = 0xA8 // RTI (return from interrupt)
+    opDSI  = 0xA9 // DSI (disable interrupts)
+    opENI  = 0xAA // ENI (enable interrupts)
+    opBRC  = 0xB0 // BRC f, addr (branch if set)
+    opBRN  = 0xB4 // BRN f, addr (branch if not set)
+    opSET  = 0xB8 // SET f
+    opCLR  = 0xBC // CLR f
+    opADD  = 0xC0 // ADD r | addr[,i]
+    opSUB  = 0xC8 // SUB r | addr[,i]
+    opAND  = 0xD0 // AND r | addr[,i]
+    opIOR  = 0xD8 // IOR r | addr[,i]
+    opXOR  = 0xE0 // XOR r | addr[,i]
+    opCMP  = 0xE8 // CMP r | addr[,i]
+)
+
+
+// how the operands of an instruction are written
+type opKind int
+
+const (
+    kindNone     opKind = iota // no operand
+    kindReg                    // a single register
+    kindRegAddr                // a register and an address (STR, LOD)
+    kindIndexed                // an address with an optional index (STR, LOD)
+    kindRegNum                 // a register and a number (LOD)
+    kindAddr                   // an address
+    kindRegReg                 // destination and source registers
+    kindOperand                // a register or an address with an optional index
+    kindFlag                   // a single flag
+    kindFlagAddr               // a flag and an address
+)
+
+
+// describe a family of instructions
+type opDef struct {
+    name string
+    base uint
+    kind opKind
+}
+
+
+// list every family of instructions
+var opDefs = [...]opDef {
+    {"NOP" , opNOP , kindNone    },
+    {"STR" , opSTR , kindRegAddr },
+    {"STR" , opSTRI, kindIndexed },
+    {"LOD" , opLOD , kindRegAddr },
+    {"LOD" , opLODI, kindIndexed },
+    {"LOD" , opLODN, kindRegNum  },
+    {"PSH" , opPSH , kindReg     },
+    {"PLL" , opPLL , kindReg     },
+    {"JMP" , opJMP , kindAddr    },
+    {"CALL", opCALL, kindAddr    },
+    {"RTN" , opRTN , kindNone    },
+    {"TRS" , opTRS , kindRegReg  },
+    {"INC" , opINC , kindOperand },
+    {"DEC" , opDEC , kindOperand },
+    {"SHL" , opSHL , kindOperand },
+    {"SHR" , opSHR , kindOperand },
+    {"ROL" , opROL , kindOperand },
+    {"ROR" , opROR , kindOperand },
+    {"NOT" , opNOT , kindOperand },
+    {"RTI" , opRTI , kindNone    },
+    {"DSI" , opDSI , kindNone    },
+    {"ENI" , opENI , kindNone    },
+    {"BRC" , opBRC , kindFlagAddr},
+    {"BRN" , opBRN , kindFlagAddr},
+    {"SET" , opSET , kindFlag    },
+    {"CLR" , opCLR , kindFlag    },
+    {"ADD" , opADD , kindOperand },
+    {"SUB" , opSUB , kindOperand },
+    {"AND" , opAND , kindOperand },
+    {"IOR" , opIOR , kindOperand },
+    {"XOR" , opXOR , kindOperand },
+    {"CMP" , opCMP , kindOperand },
+}
+
+
+// names of the registers and of the flags
+var (
+    regNames  = [4]string {"A", "X", "Y", "Z"}
+    flagNames = [4]string {"Z", "N", "C", "V"}
+)
+
+
+// number of bytes used by an instruction of the given kind
+func (kind opKind) Size (inst uint) uint {
+    switch kind {
+    case kindRegAddr, kindIndexed, kindAddr, kindFlagAddr:
+        return 3
+    case kindRegNum:
+        return 2
+    case kindOperand:
+        if (inst & 0x4) != 0 {return 3}
+    }
+    return 1
+}
+
+
+// number of cycles taken by every opcode
+//
+// one cycle is spent for each byte fetched (opcode and operands),
+// one for each data byte read or written in memory or on the stack,
+// one to execute the instruction and one more to add an index:
+//   NOP SET CLR TRS       2      DSI ENI               2
+//   INC .. NOT  r         2      ADD .. CMP  r         2
+//   INC .. NOT  addr      6      ADD .. CMP  addr      5
+//   INC .. NOT  addr,i    7      ADD .. CMP  addr,i    6
+//   LOD r, #n             3      PSH PLL               3
+//   STR LOD r, addr       5      JMP BRC BRN           4
+//   STR LOD addr          5      CALL                  6
+//   STR LOD addr,i        6      RTN                   4
+//                                RTI                   5
+var opCycles = makeCycles()
+
+func makeCycles () [0x100]uint8 {
+    var cycles [0x100]uint8
+    for inst := uint(0); inst < 0x100; inst += 1 {
+        def := decodeOp(inst)
+        if def == nil {
+            cycles[inst] = 2 // executed as NOP
+            continue
+        }
+
+        // bytes fetched and execution
+        count := def.kind.Size(inst) + 1
+
+        // data accesses
+        switch def.kind {
+        case kindRegAddr, kindIndexed, kindReg:
+            count += 1
+        case kindOperand:
+            if (inst & 0x4) != 0 {
+                count += 1
+                if def.base < opBRC {count += 1} // unary operations write back
+            }
+        case kindAddr:
+            if inst == opCALL {count += 2}
+        case kindNone:
+            if inst == opRTN  {count += 2}
+            if inst == opRTI  {count += 3}
+        }
+
+        // indexing
+        if (def.kind == kindIndexed || def.kind == kindOperand) &&
+            (inst & 0x4) != 0 && (inst & 0x3) != 0 {count += 1}
+
+        cycles[inst] = uint8(count)
+    }
+    cycles[opRTN + 1] = cycles[opRTN] // executed as RTN
+    return cycles
+}
@@ -0,0 +1,145 @@
+package main
+
+/*
+    Orientation of the tiles, cells of the tile maps and sprites are
+    mirrored along x, y, z and then rotated by quarter turns around
+    x, then y, then z
+
+    a quarter turn moves   around x  y -> z    (y' = 7 - z, z' = y)
+                           around y  z -> x    (z' = 7 - x, x' = z)
+                           around z  x -> y    (x' = 7 - y, y' = x)
+
+    the 64 rotation bytes only give 24 distinct orientations, and any
+    mirroring is one of them after mirroring along x or not, so the
+    512 combinations of rotation and mirroring give 48 distinct tiles
+*/
+
+
+// transform of the voxels around the center of a tile
+type orientMatrix [3][3]int
+
+
+var (
+    identity = orientMatrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+    turnX    = orientMatrix{{1, 0, 0}, {0, 0,-1}, {0, 1, 0}}
+    turnY    = orientMatrix{{0, 0, 1}, {0, 1, 0}, {-1,0, 0}}
+    turnZ    = orientMatrix{{0,-1, 0}, {1, 0, 0}, {0, 0, 1}}
+    mirrorX  = orientMatrix{{-1,0, 0}, {0, 1, 0}, {0, 0, 1}}
+)
+
+
+func (a orientMatrix) mul (b orientMatrix) orientMatrix {
+    var c orientMatrix
+    for i := 0; i < 3; i += 1 {
+    for j := 0; j < 3; j += 1 {
+        for k := 0; k < 3; k += 1 {c[i][j] += a[i][k] * b[k][j]}
+    }}
+    return c
+}
+
+
+// matrix of a rotation, x is applied first
+func rotMatrix (rot Byte3) orientMatrix {
+    m := identity
+    for i := uint8(0); i < rot.x & 0x3; i += 1 {m = turnX.mul(m)}
+    for i := uint8(0); i < rot.y & 0x3; i += 1 {m = turnY.mul(m)}
+    for i := uint8(0); i < rot.z & 0x3; i += 1 {m = turnZ.mul(m)}
+    return m
+}
+
+
+// matrix of a mirroring
+func mirMatrix (mir Bool3) orientMatrix {
+    m := identity
+    if mir.x {m[0][0] = -1}
+    if mir.y {m[1][1] = -1}
+    if mir.z {m[2][2] = -1}
+    return m
+}
+
+
+// distinct orientations and the smallest rotation byte giving them
+var (
+    orientRots     [24]uint8
+    orientMatrices [24]orientMatrix
+    orientOfRot    [64]uint8 // orientation given by each rotation byte
+)
+
+func init () {
+    count := 0
+    for b := 0; b < 64; b += 1 {
+        var rot Byte3; rot.SetByte(uint8(b))
+        m := rotMatrix(rot)
+
+        index := 0
+        for index < count && orientMatrices[index] != m {index += 1}
+        if index == count {
+            orientRots    [count] = uint8(b)
+            orientMatrices[count] = m
+            count += 1
+        }
+        orientOfRot[b] = uint8(index)
+    }
+}
+
+
+// equivalent orientation (0-23) and whether the tile is mirrored along x before
+//( Orient(rot, mir) equals Orient(OrientationRot(index), Bool3{x: mirrored}) )
+func Orientation (rot Byte3, mir Bool3) (uint8, bool) {
+    m := rotMatrix(rot).mul(mirMatrix(mir))
+    mirrored := m[0][0] * (m[1][1] * m[2][2] - m[1][2] * m[2][1]) -
+                m[0][1] * (m[1][0] * m[2][2] - m[1][2] * m[2][0]) +
+                m[0][2] * (m[1][0] * m[2][1] - m[1][1] * m[2][0]) < 0
+    if mirrored {m = m.mul(mirrorX)}
+    for i := range orientMatrices {
+        if orientMatrices[i] == m {return uint8(i), mirrored}
+    }
+    panic("orientation missing from the table")
+}
+
+
+// smallest rotation giving an orientation (0-23)
+func OrientationRot (index uint8) Byte3 {
+    var rot Byte3
+    rot.SetByte(orientRots[index % 24])
+    return rot
+}
+
+
+// move every voxel of the tile with a matrix
+func (tile *Tile) transform (m orientMatrix) Tile {
+    var out Tile
+    for z := 0; z < 8; z += 1 {
+    for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+        color := tile.GetVoxel(x, y, z)
+        if color == 0 {continue}
+
+        // coordinates around the center are odd numbers
+        var p [3]int
+        q := [3]int{x * 2 - 7, y * 2 - 7, z * 2 - 7}
+        for i := range p {
+            p[i] = (m[i][0] * q[0] + m[i][1] * q[1] + m[i][2] * q[2] + 7) / 2
+        }
+        out.SetVoxel(p[0], p[1], p[2], color)
+    }}}
+    return out
+}
+
+
+// copy of the tile after quarter turns around an axis
+func (tile *Tile) RotateX (turns uint) Tile {return tile.Orient(Byte3{x: uint8(turns & 0x3)}, Bool3{})}
+func (tile *Tile) RotateY (turns uint) Tile {return tile.Orient(Byte3{y: uint8(turns & 0x3)}, Bool3{})}
+func (tile *Tile) RotateZ (turns uint) Tile {return tile.Orient(Byte3{z: uint8(turns & 0x3)}, Bool3{})}
+
+
+// copy of the tile mirrored along the axes
+func (tile *Tile) Mirror (mir Bool3) Tile {
+    return tile.transform(mirMatrix(mir))
+}
+
+
+// copy of the tile mirrored then rotated, as drawn for a cell or a sprite
+func (tile *Tile) Orient (rot Byte3, mir Bool3) Tile {
+    return tile.transform(rotMatrix(rot).mul(mirMatrix(mir)))
+}
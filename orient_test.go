@@ -0,0 +1,95 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// tile with a single voxel
+func voxelTile (x, y, z int, color uint) Tile {
+    var tile Tile
+    tile.SetVoxel(x, y, z, color)
+    return tile
+}
+
+
+// position of the voxel (1, 2, 3) after mirroring then rotating,
+// computed by hand with the quarter turns described in orient.go
+func TestOrientVoxel (t *testing.T) {
+    cases := []struct {
+        name    string
+        rot     Byte3
+        mir     Bool3
+        x, y, z int
+    }{
+        {"identity"         , Byte3{}                , Bool3{}                , 1, 2, 3},
+        {"x"                , Byte3{x: 1}            , Bool3{}                , 1, 4, 2},
+        {"x twice"          , Byte3{x: 2}            , Bool3{}                , 1, 5, 4},
+        {"x 3 times"        , Byte3{x: 3}            , Bool3{}                , 1, 3, 5},
+        {"y"                , Byte3{y: 1}            , Bool3{}                , 3, 2, 6},
+        {"y twice"          , Byte3{y: 2}            , Bool3{}                , 6, 2, 4},
+        {"z"                , Byte3{z: 1}            , Bool3{}                , 5, 1, 3},
+        {"z 3 times"        , Byte3{z: 3}            , Bool3{}                , 2, 6, 3},
+        {"x y z"            , Byte3{x: 1, y: 1, z: 1}, Bool3{}                , 3, 2, 6},
+        {"mirror x"         , Byte3{}                , Bool3{x: true}         , 6, 2, 3},
+        {"mirror y"         , Byte3{}                , Bool3{y: true}         , 1, 5, 3},
+        {"mirror z"         , Byte3{}                , Bool3{z: true}         , 1, 2, 4},
+        {"mirror xyz"       , Byte3{}                , Bool3{true, true, true}, 6, 5, 4},
+        {"mirror y, x z"    , Byte3{x: 1, z: 1}      , Bool3{y: true}         , 3, 1, 5},
+        {"mirror x, y twice", Byte3{y: 2}            , Bool3{x: true}         , 1, 2, 4},
+    }
+    src := voxelTile(1, 2, 3, 2)
+    for _, c := range cases {
+        got  := src.Orient(c.rot, c.mir)
+        want := voxelTile(c.x, c.y, c.z, 2)
+        if got.rows != want.rows {
+            t.Errorf("%s: voxel not at (%d, %d, %d)", c.name, c.x, c.y, c.z)
+        }
+    }
+
+    // helpers give the same tiles
+    if a, b := src.RotateX(1), src.Orient(Byte3{x: 1}, Bool3{}); a.rows != b.rows {t.Error("RotateX differs from Orient")}
+    if a, b := src.RotateY(5), src.Orient(Byte3{y: 1}, Bool3{}); a.rows != b.rows {t.Error("RotateY does not wrap after 4 turns")}
+    if a, b := src.RotateZ(4), src; a.rows != b.rows {t.Error("RotateZ by 4 turns moves the tile")}
+    if a, b := src.Mirror(Bool3{z: true}), voxelTile(1, 2, 4, 2); a.rows != b.rows {t.Error("Mirror differs from Orient")}
+}
+
+
+// tile without any symmetry: a voxel off the diagonals and two corners
+func asymmetricTile () Tile {
+    tile := voxelTile(1, 2, 3, 1)
+    tile.SetVoxel(0, 0, 0, 2)
+    tile.SetVoxel(7, 0, 0, 3)
+    return tile
+}
+
+
+// every rotation byte and mirroring gives one of the 48 distinct tiles
+func TestOrientations (t *testing.T) {
+    tile    := asymmetricTile()
+    rotated := make(map[[nbRows]uint16]bool)
+    all     := make(map[[nbRows]uint16]bool)
+    for b := 0; b < 64; b += 1 {
+        for m := 0; m < 8; m += 1 {
+            var rot Byte3; rot.SetByte(uint8(b))
+            var mir Bool3; mir.SetByte(uint8(m))
+            oriented := tile.Orient(rot, mir)
+            if m == 0 {rotated[oriented.rows] = true}
+            all[oriented.rows] = true
+
+            // the table gives the same tile
+            index, mirrored := Orientation(rot, mir)
+            same := tile.Orient(OrientationRot(index), Bool3{x: mirrored})
+            if same.rows != oriented.rows {
+                t.Errorf("rotation %02X mirror %d: orientation %d mirrored %v differs", b, m, index, mirrored)
+            }
+        }
+    }
+    if len(rotated) != 24 {t.Errorf("%d distinct rotations instead of 24", len(rotated))}
+    if len(all) != 48 {t.Errorf("%d distinct orientations instead of 48", len(all))}
+
+    // the rotation byte kept for an orientation gives that orientation
+    for i := uint8(0); i < 24; i += 1 {
+        if orientOfRot[orientRots[i]] != i {t.Errorf("orientation %d is not given by its rotation", i)}
+    }
+}
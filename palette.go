@@ -8,7 +8,7 @@ package main
 import (
     "fmt"
     "encoding/hex"
-    //"github.com/go-gl/gl/v4.6-core/gl"
+    "github.com/go-gl/gl/v4.6-core/gl"
 	//"github.com/go-gl/glfw/v3.3/glfw"
 )
 
@@ -19,30 +19,54 @@ const (
     nbColors4Pal  =  3
     nbComps4Color =  3
     nbComps       = nbColors * nbComps4Color
+    maxFade       =  4 // steps to fade a color to black or white
 )
 
 
 // 3D tile made of 4 colors
 type Palette struct {
     indices [nbColors4Pal                ]uint
-    colors  [nbColors4Pal * nbComps4Color]float32
+    colors  [nbColors4Pal * nbComps4Color]float32 // displayed colors
+
+    shift uint8 // the colors are rotated by the color cycling
+    fade  int   // toward black when negative, white when positive
 }
 
 
 // set the colors of this palette
-func (palette Palette) SetColor (index, color uint) {
+func (palette *Palette) SetColor (index, color uint) {
     palette.indices[index] = color
+    palette.refresh()
+}
+
+
+// rotate the colors and fade them, the indices are kept
+func (palette *Palette) Animate (shift uint8, fade int) {
+    palette.shift = shift % nbColors4Pal
+    palette.fade  = fade
+    palette.refresh()
+}
+
 
+// compute the displayed colors from the indices and the animation
+func (palette *Palette) refresh () {
     const nb = uint(nbComps4Color)
-    for i := uint(0); i < nb; i += 1 {
-        palette.colors[index * nb + i] = Colors[color * nb + i]
+    target, amount := float32(0), float32(-palette.fade) / maxFade
+    if palette.fade > 0 {target, amount = 1, -amount}
+
+    for index := uint(0); index < nbColors4Pal; index += 1 {
+        color := palette.indices[(index + uint(palette.shift)) % nbColors4Pal]
+        for i := uint(0); i < nb; i += 1 {
+            c := Colors[color * nb + i]
+            palette.colors[index * nb + i] = c + (target - c) * amount
+        }
     }
 }
 
 
-// set uniforms to use this palette
-func (palette Palette) Use () {
-    // TODO
+// set the uniform of 3 vec3 at location to use this palette
+func (palette *Palette) Use (location int32) {
+    gl.Uniform3fv(location, nbColors4Pal, &palette.colors[0])
 }
 
 
@@ -0,0 +1,290 @@
+package main
+
+/*
+    Picture registers, programs drive the tile maps, the palettes
+    and the sprites by writing in them
+
+    $00 STATUS   bit 7 set during the vertical blank, cleared when read
+                 bit 6 set when sprites touched something this frame
+                 bit 5 set when sprites are dropped by the sprite limit
+    $01 SCROLLX  $02 SCROLLY  $03 SCROLLZ
+    $04 MAP      tile map to edit (0 or 1)
+    $05 CELLHI   $06 CELLLO   cell to edit (z << 8 | y << 4 | x)
+    $07 ROT      rotation of the cell (6 bits)
+    $08 MIRPAL   mirroring (bits 0-2) and palette (bits 3-4) of the cell
+    $09 TILE     tile of the cell, writing it stores ROT and MIRPAL
+                 in the cell and moves to the next cell
+    $0A PAL      palette to edit (0-3 tile maps, 4-7 sprites)
+    $0B $0C $0D  COLOR1 COLOR2 COLOR3 index of the colors of the palette
+    $0E COLSEL   sprite whose collisions are read in COLSTAT
+    $0F COLSTAT  bit 7 the sprite touches another sprite, bit 6 it touches
+                 the tile maps, bits 0-5 the first other sprite touched,
+                 $3F when it touches no sprite (only bit 7 tells sprite 63)
+    $10 SPRITE   sprite to edit (0-63)
+    $11 STILE    $12 SPAL (0-3)   $13 SX  $14 SY  $15 SZ
+    $16 SROT     $17 SMIR
+    $18 ANIM     palette to animate (0-7), bit 7 animates all of them
+    $19 CYCLE    frames between two rotations of the colors (bits 0-5,
+                 0 stops the cycling), bit 7 rotates them backward
+    $1A FADE     fade to reach (bits 0-2, 0-4 steps), bit 7 toward white
+    $1B FSPEED   frames between two steps of the fade (0 every frame)
+    $1C FLEVEL   current fade, same format as FADE, writing it jumps there
+    $1D OAMDMA   copy the 512 bytes starting at $xx00 in the attribute
+                 table of the sprites, the processor waits meanwhile
+    $1E CONTROL  bit 0 collisions of voxels instead of boxes
+                 bit 1 limit the sprites of every slab (see spritelimit.go)
+                 bit 2 SFIRST moves to the first dropped sprite every frame
+                 bits 4-5 axis of the slabs (0 y, 1 x, 2 z)
+    $1F SLIMIT   sprites allowed in a slab (0 allows 8)
+    $20 SFIRST   sprite with the highest priority, the next ones follow
+
+    animations are applied at the start of every frame and only change
+    the displayed colors, the tiles and the color indices are kept
+*/
+
+
+const (
+    nbPalettes = 8
+    nbSprites  = 64
+    ppuSize    = 0x40 // number of registers
+    colNone    = 0x3F // sprite of COLSTAT when no other sprite is touched
+)
+
+// registers of the PPU
+const (
+    regStatus  = 0x00
+    regScrollX = 0x01
+    regScrollY = 0x02
+    regScrollZ = 0x03
+    regMap     = 0x04
+    regCellHi  = 0x05
+    regCellLo  = 0x06
+    regRot     = 0x07
+    regMirPal  = 0x08
+    regTile    = 0x09
+    regPal     = 0x0A
+    regColor1  = 0x0B
+    regColor3  = 0x0D
+    regColSel  = 0x0E
+    regColStat = 0x0F
+    regSprite  = 0x10
+    regSTile   = 0x11
+    regSPal    = 0x12
+    regSX      = 0x13
+    regSY      = 0x14
+    regSZ      = 0x15
+    regSRot    = 0x16
+    regSMir    = 0x17
+    regAnim    = 0x18
+    regCycle   = 0x19
+    regFade    = 0x1A
+    regFSpeed  = 0x1B
+    regFLevel  = 0x1C
+    regOAMDMA  = 0x1D
+    regControl = 0x1E
+    regSLimit  = 0x1F
+    regSFirst  = 0x20
+)
+
+
+// animation of the colors of a palette
+type paletteAnim struct {
+    cycle   uint8 // CYCLE register
+    target  int   // fade to reach, same sign as Palette.fade
+    speed   uint8 // frames between two steps of the fade
+    elapsed [2]uint8 // frames since the last rotation and the last step
+}
+
+
+// convert a fade register to a signed fade and back
+func fadeOf (value uint8) int {
+    level := int(value & 0x7)
+    if level > maxFade {level = maxFade}
+    if value & 0x80 != 0 {return level}
+    return -level
+}
+
+func fadeByte (fade int) uint8 {
+    if fade > 0 {return 0x80 | uint8(fade)}
+    return uint8(-fade)
+}
+
+
+type PPU struct {
+    bank     Mapper // provides the tiles
+    maps     [2]TileMap
+    palettes [nbPalettes]Palette
+    anims    [nbPalettes]paletteAnim
+    sprites  [nbSprites ]Sprite
+    scroll   Vector3
+    vblank   bool
+    hits     Collisions // found at the start of the frame
+    overflow bool       // sprites dropped at the start of the frame
+
+    regs     [ppuSize]uint8 // last values written
+    dma      func (page uint8) // copies a page in the attribute table
+}
+
+
+// tiles currently visible
+func (ppu *PPU) Tiles () *TileBank {
+    return ppu.bank.Tiles()
+}
+
+
+// index of the cell selected by the registers
+func (ppu *PPU) cell () uint {
+    return (uint(ppu.regs[regCellHi]) << 8 | uint(ppu.regs[regCellLo])) % nbTiles
+}
+
+
+// read a register and acknowledge the vertical blank
+func (ppu *PPU) Read (addr uint) uint8 {
+    val := ppu.Peek(addr)
+    if addr == regStatus {ppu.vblank = false}
+    return val
+}
+
+
+// read a register without side effects
+func (ppu *PPU) Peek (addr uint) uint8 {
+    switch addr {
+    case regStatus:
+        var status uint8
+        if ppu.vblank     {status |= 0x80}
+        if ppu.hits.Any() {status |= 0x40}
+        if ppu.overflow   {status |= 0x20}
+        return status
+    case regColStat:
+        i := int(ppu.regs[regColSel] % nbSprites)
+        status := uint8(colNone)
+        if ppu.hits.Sprites[i] != 0 {
+            for j := 0; j < nbSprites; j += 1 {
+                if ppu.hits.SpriteHit(i, j) {status = 0x80 | uint8(j); break}
+            }
+        }
+        if ppu.hits.MapHit(i) {status |= 0x40}
+        return status
+    case regTile:
+        til, _, _, _ := ppu.maps[ppu.regs[regMap] & 0x1].Get(ppu.cell())
+        return til
+    case regCycle, regFade, regFSpeed, regFLevel:
+        // registers of the palette animated
+        i    := ppu.regs[regAnim] % nbPalettes
+        anim := &ppu.anims[i]
+        return [...]uint8{anim.cycle, fadeByte(anim.target), anim.speed, fadeByte(ppu.palettes[i].fade)}[addr - regCycle]
+    }
+    return ppu.regs[addr]
+}
+
+
+// write a register and update the scene
+func (ppu *PPU) Write (addr uint, value uint8) {
+    if addr == regStatus {return}
+    ppu.regs[addr] = value
+
+    sprite := &ppu.sprites[ppu.regs[regSprite] % nbSprites]
+    switch {
+    case addr == regScrollX:
+        ppu.scroll.x = uint(value)
+    case addr == regScrollY:
+        ppu.scroll.y = uint(value)
+    case addr == regScrollZ:
+        ppu.scroll.z = uint(value)
+    case addr == regTile:
+        mirPal := ppu.regs[regMirPal]
+        cell   := ppu.cell()
+        ppu.maps[ppu.regs[regMap] & 0x1].Set(
+            cell, value, ppu.regs[regRot] & 0x3F, mirPal & 0x7, (mirPal >> 3) & 0x3)
+
+        // move to the next cell
+        cell = (cell + 1) % nbTiles
+        ppu.regs[regCellHi] = uint8(cell >> 8)
+        ppu.regs[regCellLo] = uint8(cell)
+    case regColor1 <= addr && addr <= regColor3:
+        pal := &ppu.palettes[ppu.regs[regPal] % nbPalettes]
+        pal.SetColor(addr - regColor1, uint(value) % nbColors)
+    case addr == regSTile:
+        sprite.id_tile = uint(value)
+    case addr == regSPal:
+        sprite.id_pal  = uint(value & 0x3)
+    case addr == regSX:
+        sprite.pos.x = uint(value)
+    case addr == regSY:
+        sprite.pos.y = uint(value)
+    case addr == regSZ:
+        sprite.pos.z = uint(value)
+    case addr == regSRot:
+        sprite.rot.SetByte(value)
+    case addr == regSMir:
+        sprite.mir.SetByte(value)
+    case regCycle <= addr && addr <= regFLevel:
+        for i := range ppu.anims {
+            if ppu.regs[regAnim] & 0x80 == 0 && uint8(i) != ppu.regs[regAnim] % nbPalettes {continue}
+            anim, pal := &ppu.anims[i], &ppu.palettes[i]
+            switch addr {
+            case regCycle:
+                anim.cycle, anim.elapsed[0] = value, 0
+            case regFade:
+                anim.target = fadeOf(value)
+            case regFSpeed:
+                anim.speed, anim.elapsed[1] = value, 0
+            case regFLevel:
+                pal.Animate(pal.shift, fadeOf(value))
+            }
+        }
+    case addr == regOAMDMA:
+        if ppu.dma != nil {ppu.dma(value)}
+    }
+}
+
+
+// attribute table of the sprites seen from the bus
+type OAM struct {
+    ppu *PPU
+}
+
+func (oam OAM) Read (addr uint) uint8 {
+    return oam.ppu.sprites[addr / oamEntrySize % nbSprites].GetAttribute(addr % oamEntrySize)
+}
+
+func (oam OAM) Write (addr uint, value uint8) {
+    oam.ppu.sprites[addr / oamEntrySize % nbSprites].SetAttribute(addr % oamEntrySize, value)
+}
+
+
+// find the collisions of the sprites for the frame
+func (ppu *PPU) UpdateCollisions () {
+    precision := PrecisionBox
+    if ppu.regs[regControl] & 0x1 != 0 {precision = PrecisionVoxel}
+    ppu.hits = ppu.Collide(precision)
+}
+
+
+// advance the animations of the palettes by a frame
+func (ppu *PPU) Animate () {
+    for i := range ppu.anims {
+        anim, pal := &ppu.anims[i], &ppu.palettes[i]
+        shift, fade := pal.shift, pal.fade
+
+        if period := anim.cycle & 0x3F; period != 0 {
+            if anim.elapsed[0] += 1; anim.elapsed[0] >= period {
+                anim.elapsed[0] = 0
+                if anim.cycle & 0x80 != 0 {
+                    shift += nbColors4Pal - 1
+                } else {
+                    shift += 1
+                }
+            }
+        }
+
+        if fade != anim.target {
+            if anim.elapsed[1] += 1; anim.elapsed[1] > anim.speed {
+                anim.elapsed[1] = 0
+                if fade < anim.target {fade += 1} else {fade -= 1}
+            }
+        }
+
+        if shift != pal.shift || fade != pal.fade {pal.Animate(shift, fade)}
+    }
+}
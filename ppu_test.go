@@ -0,0 +1,98 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// a register of the PPU and the value written in it
+type ppuWrite struct {
+    reg   uint
+    value uint8
+}
+
+
+// the palettes rotate and fade at the speed of their registers
+func TestPPUAnimate (t *testing.T) {
+    cases := []struct {
+        name   string
+        writes []ppuWrite // after ANIM selects palette 2
+        frames int
+        shift  uint8
+        fade   int
+    }{
+        {"still"              , nil, 10, 0, 0},
+        {"cycle stopped"      , []ppuWrite{{regCycle, 0x80}}, 10, 0, 0},
+        {"cycle every frame"  , []ppuWrite{{regCycle, 0x01}}, 4, 1, 0},
+        {"cycle every 3"      , []ppuWrite{{regCycle, 0x03}}, 5, 1, 0},
+        {"cycle every 3, done", []ppuWrite{{regCycle, 0x03}}, 6, 2, 0},
+        {"cycle backward"     , []ppuWrite{{regCycle, 0x81}}, 1, 2, 0},
+        {"cycle restarted"    , []ppuWrite{{regCycle, 0x02}, {regCycle, 0x02}}, 1, 0, 0},
+        {"fade every frame"   , []ppuWrite{{regFade, 0x04}}, 3, 0, -3},
+        {"fade to black"      , []ppuWrite{{regFade, 0x04}}, 10, 0, -4},
+        {"fade to white"      , []ppuWrite{{regFade, 0x84}}, 10, 0, 4},
+        {"fade every 3"       , []ppuWrite{{regFSpeed, 2}, {regFade, 0x84}}, 8, 0, 2},
+        {"fade past black"    , []ppuWrite{{regFade, 0x07}}, 10, 0, -4},
+        {"level jumps"        , []ppuWrite{{regFade, 0x83}, {regFLevel, 0x83}}, 0, 0, 3},
+        {"level then fades"   , []ppuWrite{{regFLevel, 0x83}}, 2, 0, 1},
+        {"white to black"     , []ppuWrite{{regFLevel, 0x84}, {regFade, 0x04}}, 5, 0, -1},
+        {"cycle and fade"     , []ppuWrite{{regCycle, 0x01}, {regFade, 0x02}}, 2, 2, -2},
+    }
+    for _, c := range cases {
+        ppu := &PPU{}
+        ppu.Write(regAnim, 2)
+        for _, w := range c.writes {ppu.Write(w.reg, w.value)}
+        for i := 0; i < c.frames; i += 1 {ppu.Animate()}
+
+        pal := &ppu.palettes[2]
+        if pal.shift != c.shift || pal.fade != c.fade {
+            t.Errorf("%s: shift %d fade %d instead of %d and %d", c.name, pal.shift, pal.fade, c.shift, c.fade)
+        }
+        if level := ppu.Read(regFLevel); level != fadeByte(c.fade) {t.Errorf("%s: FLEVEL reads $%02X", c.name, level)}
+        for i := range ppu.palettes {
+            if p := &ppu.palettes[i]; i != 2 && (p.shift != 0 || p.fade != 0) {t.Errorf("%s: palette %d animated", c.name, i)}
+        }
+    }
+
+    // bit 7 of ANIM animates every palette
+    ppu := &PPU{}
+    ppu.Write(regAnim, 0x80)
+    ppu.Write(regFLevel, 0x02)
+    for i := range ppu.palettes {
+        if ppu.palettes[i].fade != -2 {t.Errorf("all: palette %d fades by %d", i, ppu.palettes[i].fade)}
+    }
+}
+
+
+// the displayed colors reach black and white at the end of the fades
+func TestPPUFadeColors (t *testing.T) {
+    saved := Colors
+    defer func () {Colors = saved}()
+    copy(Colors[3:], []float32{0.2, 0.4, 0.6, 1, 0.5, 0})
+
+    ppu := &PPU{}
+    ppu.Write(regPal, 1)
+    ppu.Write(regColor1, 1)
+    ppu.Write(regColor1 + 1, 2)
+    ppu.Write(regAnim, 1)
+    cases := []struct {
+        level  uint8
+        colors []float32 // first two colors displayed
+    }{
+        {0x00, []float32{0.2, 0.4, 0.6, 1, 0.5, 0}},
+        {0x02, []float32{0.1, 0.2, 0.3, 0.5, 0.25, 0}},
+        {0x04, []float32{0, 0, 0, 0, 0, 0}},
+        {0x82, []float32{0.6, 0.7, 0.8, 1, 0.75, 0.5}},
+        {0x84, []float32{1, 1, 1, 1, 1, 1}},
+    }
+    for _, c := range cases {
+        ppu.Write(regFLevel, c.level)
+        pal := &ppu.palettes[1]
+        for i, want := range c.colors {
+            if d := pal.colors[i] - want; d > 1e-6 || d < -1e-6 {
+                t.Errorf("level $%02X: component %d is %g instead of %g", c.level, i, pal.colors[i], want)
+            }
+        }
+        if pal.indices != [nbColors4Pal]uint{1, 2, 0} {t.Errorf("level $%02X: indices %v changed", c.level, pal.indices)}
+    }
+}
@@ -2,6 +2,7 @@ package main
 
 
 import (
+    "fmt"
     "math/bits"
 )
 
@@ -10,40 +11,114 @@ type Processor struct {
     reg  [4]uint8 // A, X, Y, Z
     flag [4]bool  // zero, negative, carry, overflow
     ptr     uint
-    ram    *Memory
+    bus    *Bus
     stack  *Stack
+
+    cycles  uint64 // cycles executed since power on
+    frame   uint64 // cycle at which the current frame ends
+
+    masked  bool // maskable interrupts are ignored
+    irq     bool // maskable interrupt pending
+    nmi     bool // non-maskable interrupt pending
+
+    tracer *Tracer // records every instruction when set
+    fault   error  // halts the processor once set
 }
 
 
+// addresses of the interrupt handlers
+//( a vector set to $0000 means the program does not handle the interrupt )
+const (
+    vecNMI = 0xFFFA // vertical blank
+    vecIRQ = 0xFFFE // maskable interrupt, raised by the controllers
+)
+
+// cycles spent to enter an interrupt handler
+const interruptCycles = 7
+
+
+// clock of the processor
+const (
+    cpuClock       = 1800000         // cycles per second
+    cyclesPerFrame = cpuClock / FPS  // cycles executed between two frames
+)
+
+
+// flags set by the instructions
+const (
+    flagZero     = 0
+    flagNegative = 1
+    flagCarry    = 2
+    flagOverflow = 3
+)
+
+
 // read and execute one instruction from the memory
-func (cpu Processor) Cycle () {
+//
+// flags:
+//   LOD                 zero, negative
+//   INC DEC             zero, negative, carry when wrapping, signed overflow
+//   SHL SHR ROL ROR     zero, negative, carry is the bit shifted out,
+//                       overflow when the sign changes
+//   NOT AND IOR XOR     zero, negative, carry and overflow cleared
+//   ADD                 A + M + carry, sets carry on unsigned overflow
+//   SUB                 A - M - carry, sets carry on borrow
+//   CMP                 like SUB without carry in, A is not modified
+//   overflow is set by ADD, SUB and CMP when the signed result does not fit
+func (cpu *Processor) Cycle () {
+    if cpu.fault != nil {return}
+    start := cpu.cycles
+    at    := cpu.ptr
+
+    // service pending interrupts before the next instruction
+    serviced := false
+    if cpu.nmi {
+        cpu.nmi  = false
+        serviced = cpu.interrupt(vecNMI)
+    } else if cpu.irq && !cpu.masked {
+        cpu.irq  = false
+        serviced = cpu.interrupt(vecIRQ)
+    }
+    if cpu.tracer != nil {cpu.tracer.begin(cpu, serviced, start)}
+
     // read the byte at the specified location
-    inst := cpu.ram.GetByte(cpu.ptr)
+    inst := cpu.bus.GetByte(cpu.ptr)
     reg  := inst & 0x3 // register to use
     cpu.ptr += 1 // move to next byte
+    cpu.cycles += uint64(opCycles[inst])
 
     // lower values are NOP
-    if between(0x40, inst, 0xA8)  {
+    if between(0xA8, inst, 0xAB) {
+        if        inst == 0xA8 { // RTI
+            cpu.setFlagsByte(cpu.stack.Pull())
+            cpu.ptr = cpu.stack.PullAddress()
+        } else if inst == 0xA9 { // DSI
+            cpu.masked = true
+        } else                 { // ENI
+            cpu.masked = false
+        }
+    } else if between(0x40, inst, 0xA8)  {
         if        inst < 0x48 { // STR
             if inst < 0x44 { // STR from registers
-                addr := cpu.ram.GetAddress(cpu.ptr)
-                cpu.reg[reg] = uint8(cpu.ram.GetByte(addr))
-                cpu.ptr += 2
+                addr := cpu.bus.GetAddress(cpu.ptr)
+                cpu.write(addr, uint(cpu.reg[reg]))
             } else         { // STR with indexing
-                cpu.reg[ 0 ] = uint8(cpu.readMR(inst))
+                cpu.writeMR(inst, uint(cpu.reg[0]))
             }
+            cpu.ptr += 2
         } else if inst < 0x54 { // LOD
             var val uint
             if inst < 0x4C        { // LOD in registers
-                addr := cpu.ram.GetAddress(cpu.ptr)
-                val   = cpu.ram.GetByte(addr)
+                addr := cpu.bus.GetAddress(cpu.ptr)
+                val   = cpu.bus.Read(addr)
                 cpu.reg[reg] = uint8(val)
                 cpu.ptr += 2
             } else if inst < 0x50 { // LOD with indexing
                 val = cpu.readMR(inst)
                 cpu.reg[ 0 ] = uint8(val)
+                cpu.ptr += 2
             } else                { // LOD numbers
-                val = cpu.ram.GetByte(cpu.ptr)
+                val = cpu.bus.GetByte(cpu.ptr)
                 cpu.reg[reg] = uint8(val)
                 cpu.ptr += 1
             }
@@ -54,8 +129,8 @@ func (cpu Processor) Cycle () {
             cpu.reg[reg] = cpu.stack.Pull()
         } else if inst < 0x60 { // JMP & RTN
             if inst < 0x5E { // JMP
-                addr := cpu.ram.GetAddress(cpu.ptr)
-                if inst == 0x5D {cpu.stack.PushAddress(cpu.ptr + 1)}
+                addr := cpu.bus.GetAddress(cpu.ptr)
+                if inst == 0x5D {cpu.stack.PushAddress(cpu.ptr + 2)}
                 cpu.ptr = addr
             } else         { // RTN
                 cpu.ptr = cpu.stack.PullAddress()
@@ -65,16 +140,32 @@ func (cpu Processor) Cycle () {
             cpu.reg[reg] = cpu.reg[reg2]
         } else if inst < 0xA8 { // unary operations
             val := cpu.readMR(inst)
-            if        inst < 0x78 { val  += 1 // INC
-            } else if inst < 0x80 { val  -= 1 // DEC
-            } else if inst < 0x88 { val <<= 1 // SHL
-            } else if inst < 0x90 { val >>= 1 // SHR
-            } else if inst < 0x98 { val = uint(bits.RotateLeft8(uint8(val),  1)) // ROL
-            } else if inst < 0xA0 { val = uint(bits.RotateLeft8(uint8(val), -1)) // ROR
-            } else                { val = ^val // NOT
+            res := val
+            carry, over := false, false
+            if        inst < 0x78 { res += 1 // INC
+                carry, over = val == 0xFF, val == 0x7F
+            } else if inst < 0x80 { res -= 1 // DEC
+                carry, over = val == 0x00, val == 0x80
+            } else if inst < 0x88 { res <<= 1 // SHL
+                carry = (val & 0x80) != 0
+            } else if inst < 0x90 { res >>= 1 // SHR
+                carry = (val & 0x01) != 0
+            } else if inst < 0x98 { res = uint(bits.RotateLeft8(uint8(val),  1)) // ROL
+                carry = (val & 0x80) != 0
+            } else if inst < 0xA0 { res = uint(bits.RotateLeft8(uint8(val), -1)) // ROR
+                carry = (val & 0x01) != 0
+            } else                { res = ^val // NOT
             }
-            cpu.writeMR(inst, val)
-            cpu.upFlags(val)
+            res &= 0xFF
+
+            // shifts and rotations overflow when the sign changes
+            if between(0x80, inst, 0xA0) {over = ((val ^ res) & 0x80) != 0}
+
+            cpu.writeMR(inst, res)
+            cpu.skipMR (inst)
+            cpu.upFlags(res)
+            cpu.flag[flagCarry   ] = carry
+            cpu.flag[flagOverflow] = over
         }
     } else if between(0xB0, inst, 0xF0) {
         if        inst < 0xB8 { // BRC
@@ -82,7 +173,7 @@ func (cpu Processor) Cycle () {
             cond := cpu.flag[reg]
             not  := inst >= 0xB4
             if (cond && !not) || (!cond && not) {
-                cpu.ptr = cpu.ram.GetAddress(cpu.ptr)
+                cpu.ptr = cpu.bus.GetAddress(cpu.ptr)
             } else {
                 cpu.ptr += 2
             }
@@ -93,64 +184,189 @@ func (cpu Processor) Cycle () {
         } else if inst < 0xE8 { // operations that store result in accumulator
             val1 := uint(cpu.reg[0])
             val2 := cpu.readMR(inst)
-            if        inst < 0xC8 { val1 += val2 // ADD
-            } else if inst < 0xD0 { val1 -= val2 // SUB
-            } else if inst < 0xD8 { val1 &= val2 // AND
-            } else if inst < 0xE0 { val1 |= val2 // IOR
-            } else                { val1 ^= val2 // XOR
+            if        inst < 0xC8 { val1 = cpu.add(val1, val2, cpu.flag[flagCarry]) // ADD
+            } else if inst < 0xD0 { val1 = cpu.sub(val1, val2, cpu.flag[flagCarry]) // SUB
+            } else {
+                if        inst < 0xD8 { val1 &= val2 // AND
+                } else if inst < 0xE0 { val1 |= val2 // IOR
+                } else                { val1 ^= val2 // XOR
+                }
+                cpu.upFlags(val1)
+                cpu.flag[flagCarry   ] = false
+                cpu.flag[flagOverflow] = false
             }
             cpu.reg[0] = uint8(val1)
-            cpu.upFlags(val1)
+            cpu.skipMR (inst)
         } else { // CMP
-
+            cpu.sub(uint(cpu.reg[0]), cpu.readMR(inst), false)
+            cpu.skipMR(inst)
         }
     }
+
+    if cpu.tracer != nil {cpu.tracer.end(cpu)}
+
+    // a fault of the stack halts the processor on this instruction
+    if err := cpu.stack.Err(); err != nil {
+        cpu.fault = fmt.Errorf("Processor halted at $%04X: %v", at, err)
+    }
+}
+
+
+// add two bytes and a carry, update the flags
+func (cpu *Processor) add (val1, val2 uint, carry bool) uint {
+    res := val1 + val2
+    if carry {res += 1}
+
+    cpu.upFlags(res & 0xFF)
+    cpu.flag[flagCarry   ] = res > 0xFF
+    cpu.flag[flagOverflow] = (^(val1 ^ val2) & (val1 ^ res) & 0x80) != 0
+    return res & 0xFF
+}
+
+
+// subtract two bytes and a borrow, update the flags
+func (cpu *Processor) sub (val1, val2 uint, borrow bool) uint {
+    sub := val2
+    if borrow {sub += 1}
+    res := (val1 - sub) & 0xFF
+
+    cpu.upFlags(res)
+    cpu.flag[flagCarry   ] = val1 < sub
+    cpu.flag[flagOverflow] = ((val1 ^ val2) & (val1 ^ res) & 0x80) != 0
+    return res
 }
 
 // helper to read from memory or registers
-func (cpu Processor) readMR (inst uint) uint {
+//( the pointer is not moved s.t. the same operand can be written back )
+func (cpu *Processor) readMR (inst uint) uint {
     reg := inst & 0x3
     if (inst & 0x4) == 0 { // read from a register
         return uint(cpu.reg[reg])
     } else {
-        addr := cpu.ram.GetAddress(cpu.ptr)
+        addr := cpu.bus.GetAddress(cpu.ptr)
         if reg == 0 { // read from memory
-            return cpu.ram.GetByte(addr)
+            return cpu.bus.Read(addr)
         } else { // read from memory with index
-            return cpu.ram.GetByte(addr + uint(cpu.reg[reg]))
+            return cpu.bus.Read(addr + uint(cpu.reg[reg]))
         }
-        cpu.ptr += 2
     }
-    return 0
 }
 
 // helper to write to memory or registers
-func (cpu Processor) writeMR (inst, value uint) {
+func (cpu *Processor) writeMR (inst, value uint) {
     reg := inst & 0x3
     if (inst & 0x4) == 0 { // write to a register
         cpu.reg[reg] = uint8(value)
     } else {
-        addr := cpu.ram.GetAddress(cpu.ptr)
+        addr := cpu.bus.GetAddress(cpu.ptr)
         if reg == 0 { // write to memory
-            cpu.ram.Write(addr, value)
+            cpu.write(addr, value)
         } else { // write to memory with index
-            cpu.ram.Write(addr + uint(cpu.reg[reg]), value)
+            cpu.write(addr + uint(cpu.reg[reg]), value)
         }
-        cpu.ptr += 2
     }
 }
 
+// helper to write in memory, recorded by the tracer
+func (cpu *Processor) write (addr, value uint) {
+    if cpu.tracer != nil {cpu.tracer.write(addr, value)}
+    cpu.bus.Write(addr, value)
+}
+
+// helper to move after the address used by a memory operand
+func (cpu *Processor) skipMR (inst uint) {
+    if (inst & 0x4) != 0 {cpu.ptr += 2}
+}
+
+
+// set base flags from a byte
+func (cpu *Processor) upFlags (value uint) {
+    cpu.flag[flagZero    ] = value == 0
+    cpu.flag[flagNegative] = (value & 0x80) != 0
+}
+
+
+// request a maskable interrupt, serviced once interrupts are enabled
+func (cpu *Processor) RequestIRQ () {
+    cpu.irq = true
+}
+
+
+// request a non-maskable interrupt, serviced before the next instruction
+func (cpu *Processor) RequestNMI () {
+    cpu.nmi = true
+}
+
+
+// jump to an interrupt handler after saving the pointer and the flags
+//( return false if the program does not handle the interrupt )
+func (cpu *Processor) interrupt (vector uint) bool {
+    handler := cpu.bus.GetAddress(vector)
+    if handler == 0 {return false}
+
+    // the pointer and the flags take three bytes on the stack
+    if !cpu.stack.InBound(3) {
+        cpu.stack.fault(errStackOverflow)
+        return false
+    }
+    cpu.stack.PushAddress(cpu.ptr)
+    cpu.stack.Push(cpu.flagsByte())
+    cpu.masked  = true
+    cpu.ptr     = handler
+    cpu.cycles += interruptCycles
+    return true
+}
+
+
+// pack the flags in a byte (zero, negative, carry, overflow, masked)
+func (cpu *Processor) flagsByte () uint8 {
+    var b uint8
+    for i, f := range cpu.flag {
+        if f {b |= 1 << uint(i)}
+    }
+    if cpu.masked {b |= 0x10}
+    return b
+}
+
+
+// unpack the flags from a byte
+func (cpu *Processor) setFlagsByte (b uint8) {
+    for i := range cpu.flag {
+        cpu.flag[i] = (b & (1 << uint(i))) != 0
+    }
+    cpu.masked = (b & 0x10) != 0
+}
+
+
+// execute instructions until the cycle budget of a frame is spent
+//( the frame starts with the vertical blank interrupt,
+//  the cycles of an instruction crossing the end of the frame are deducted
+//  from the next one s.t. frames do not drift,
+//  the fault that halted the processor is returned )
+func (cpu *Processor) RunFrame () error {
+    cpu.RequestNMI()
+    cpu.frame += cyclesPerFrame
+    for cpu.cycles < cpu.frame && cpu.fault == nil {
+        cpu.Cycle()
+    }
+    return cpu.fault
+}
+
+
+// fault that halted the processor, nil while it runs
+func (cpu *Processor) Fault () error {
+    return cpu.fault
+}
+
 
-// set base flags
-func (cpu Processor) upFlags (value uint) {
-    cpu.flag[0] = value == 0
-    cpu.flag[1] = (value & 0x080) != 0
-    cpu.flag[2] = (value & 0x100) != 0
+// number of cycles executed since power on
+func (cpu *Processor) Cycles () uint64 {
+    return cpu.cycles
 }
 
 
 // specify if the pointer has reached the end of memory
-func (cpu Processor) ReachedEnd () bool {
+func (cpu *Processor) ReachedEnd () bool {
     if cpu.ptr >= 0x10000 {
         cpu.ptr = 0x0
         return true
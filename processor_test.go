@@ -0,0 +1,375 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+)
+
+
+// one instruction executed from $0100 on a flat memory
+type cpuCase struct {
+    code   string         // instruction to assemble
+    reg    [4]uint8       // A, X, Y, Z before
+    flag   string         // flags set before (Z N C V)
+    masked bool
+    mem    map[uint]uint8 // memory before
+    stack  []uint8        // stack before
+
+    wantReg    [4]uint8
+    wantFlag   string
+    wantMasked bool
+    wantMem    map[uint]uint8
+    wantStack  []uint8
+    wantPtr    uint
+    cycles     uint64
+}
+
+
+var cpuCases = []cpuCase {
+    // no operation
+    {code: "NOP", wantPtr: 0x101, cycles: 2},
+
+    // store
+    {code: "STR A, $0200", reg: [4]uint8{0x42}, wantReg: [4]uint8{0x42},
+        wantMem: map[uint]uint8{0x200: 0x42}, wantPtr: 0x103, cycles: 5},
+    {code: "STR Y, $0200", reg: [4]uint8{1, 2, 3, 4}, wantReg: [4]uint8{1, 2, 3, 4},
+        wantMem: map[uint]uint8{0x200: 3}, wantPtr: 0x103, cycles: 5},
+    {code: "STR $0200", reg: [4]uint8{7}, wantReg: [4]uint8{7},
+        wantMem: map[uint]uint8{0x200: 7}, wantPtr: 0x103, cycles: 5},
+    {code: "STR $0200, Z", reg: [4]uint8{7, 0, 0, 5}, wantReg: [4]uint8{7, 0, 0, 5},
+        wantMem: map[uint]uint8{0x205: 7}, wantPtr: 0x103, cycles: 6},
+
+    // load
+    {code: "LOD X, $0200", mem: map[uint]uint8{0x200: 0x80}, wantReg: [4]uint8{0, 0x80},
+        wantFlag: "N", wantPtr: 0x103, cycles: 5},
+    {code: "LOD A, $0200", reg: [4]uint8{9}, flag: "N",
+        wantFlag: "Z", wantPtr: 0x103, cycles: 5},
+    {code: "LOD $0200", mem: map[uint]uint8{0x200: 0x11}, wantReg: [4]uint8{0x11},
+        wantPtr: 0x103, cycles: 5},
+    {code: "LOD $0200, X", reg: [4]uint8{0, 3}, mem: map[uint]uint8{0x203: 0x22},
+        wantReg: [4]uint8{0x22, 3}, wantPtr: 0x103, cycles: 6},
+    {code: "LOD Z, #$00", reg: [4]uint8{0, 0, 0, 4}, wantFlag: "Z", wantPtr: 0x102, cycles: 3},
+    {code: "LOD Y, #$FF", wantReg: [4]uint8{0, 0, 0xFF}, wantFlag: "N", wantPtr: 0x102, cycles: 3},
+
+    // stack
+    {code: "PSH Y", reg: [4]uint8{0, 0, 0x33}, wantReg: [4]uint8{0, 0, 0x33},
+        wantStack: []uint8{0x33}, wantPtr: 0x101, cycles: 3},
+    {code: "PLL X", stack: []uint8{0x44}, wantReg: [4]uint8{0, 0x44}, wantPtr: 0x101, cycles: 3},
+
+    // jumps
+    {code: "JMP $1234", wantPtr: 0x1234, cycles: 4},
+    {code: "CALL $1234", wantStack: []uint8{0x01, 0x03}, wantPtr: 0x1234, cycles: 6},
+    {code: "RTN", stack: []uint8{0x12, 0x34}, wantPtr: 0x1234, cycles: 4},
+    {code: ".byte $5F", stack: []uint8{0x12, 0x34}, wantPtr: 0x1234, cycles: 4}, // executed as RTN
+
+    // transfer
+    {code: "TRS A, X", reg: [4]uint8{1, 2}, wantReg: [4]uint8{2, 2}, wantPtr: 0x101, cycles: 2},
+    {code: "TRS Z, Y", reg: [4]uint8{0, 0, 3}, wantReg: [4]uint8{0, 0, 3, 3}, wantPtr: 0x101, cycles: 2},
+
+    // unary operations on registers
+    {code: "INC A", reg: [4]uint8{0xFF}, wantFlag: "ZC", wantPtr: 0x101, cycles: 2},
+    {code: "INC X", reg: [4]uint8{0, 0x7F}, wantReg: [4]uint8{0, 0x80},
+        wantFlag: "NV", wantPtr: 0x101, cycles: 2},
+    {code: "DEC Y", wantReg: [4]uint8{0, 0, 0xFF}, wantFlag: "NC", wantPtr: 0x101, cycles: 2},
+    {code: "DEC A", reg: [4]uint8{0x80}, wantReg: [4]uint8{0x7F}, wantFlag: "V", wantPtr: 0x101, cycles: 2},
+    {code: "SHL A", reg: [4]uint8{0x81}, wantReg: [4]uint8{0x02}, wantFlag: "CV", wantPtr: 0x101, cycles: 2},
+    {code: "SHR A", reg: [4]uint8{0x01}, flag: "V", wantFlag: "ZC", wantPtr: 0x101, cycles: 2},
+    {code: "ROL A", reg: [4]uint8{0x80}, wantReg: [4]uint8{0x01}, wantFlag: "CV", wantPtr: 0x101, cycles: 2},
+    {code: "ROR A", reg: [4]uint8{0x01}, wantReg: [4]uint8{0x80}, wantFlag: "NCV", wantPtr: 0x101, cycles: 2},
+    {code: "NOT Z", reg: [4]uint8{0, 0, 0, 0x0F}, flag: "CV", wantReg: [4]uint8{0, 0, 0, 0xF0},
+        wantFlag: "N", wantPtr: 0x101, cycles: 2},
+
+    // unary operations on memory
+    {code: "INC $0200", mem: map[uint]uint8{0x200: 0x41}, wantMem: map[uint]uint8{0x200: 0x42},
+        wantPtr: 0x103, cycles: 6},
+    {code: "DEC $0200, X", reg: [4]uint8{0, 1}, mem: map[uint]uint8{0x201: 1}, wantReg: [4]uint8{0, 1},
+        wantMem: map[uint]uint8{0x201: 0}, wantFlag: "Z", wantPtr: 0x103, cycles: 7},
+    {code: "SHR $0200", mem: map[uint]uint8{0x200: 0x80}, wantMem: map[uint]uint8{0x200: 0x40},
+        wantFlag: "V", wantPtr: 0x103, cycles: 6},
+
+    // interrupts
+    {code: "RTI", stack: []uint8{0x12, 0x34, 0x05}, masked: true,
+        wantFlag: "ZC", wantPtr: 0x1234, cycles: 5},
+    {code: "RTI", stack: []uint8{0x12, 0x34, 0x1A}, wantFlag: "NV", wantMasked: true,
+        wantPtr: 0x1234, cycles: 5},
+    {code: "DSI", wantMasked: true, wantPtr: 0x101, cycles: 2},
+    {code: "ENI", masked: true, wantPtr: 0x101, cycles: 2},
+
+    // branches
+    {code: "BRC Z, $1234", flag: "Z", wantFlag: "Z", wantPtr: 0x1234, cycles: 4},
+    {code: "BRC N, $1234", wantPtr: 0x103, cycles: 4},
+    {code: "BRN C, $1234", wantPtr: 0x1234, cycles: 4},
+    {code: "BRN V, $1234", flag: "V", wantFlag: "V", wantPtr: 0x103, cycles: 4},
+
+    // flags
+    {code: "SET V", wantFlag: "V", wantPtr: 0x101, cycles: 2},
+    {code: "CLR C", flag: "ZC", wantFlag: "Z", wantPtr: 0x101, cycles: 2},
+
+    // addition
+    {code: "ADD X", reg: [4]uint8{0x7F, 1}, wantReg: [4]uint8{0x80, 1},
+        wantFlag: "NV", wantPtr: 0x101, cycles: 2},
+    {code: "ADD X", reg: [4]uint8{0xFF, 0}, flag: "C", wantReg: [4]uint8{0, 0},
+        wantFlag: "ZC", wantPtr: 0x101, cycles: 2},
+    {code: "ADD $0200", reg: [4]uint8{0x80}, mem: map[uint]uint8{0x200: 0x80},
+        wantFlag: "ZCV", wantPtr: 0x103, cycles: 5},
+    {code: "ADD $0200, Y", reg: [4]uint8{1, 0, 2}, mem: map[uint]uint8{0x202: 2},
+        wantReg: [4]uint8{3, 0, 2}, wantPtr: 0x103, cycles: 6},
+
+    // subtraction
+    {code: "SUB X", reg: [4]uint8{5, 3}, wantReg: [4]uint8{2, 3}, wantPtr: 0x101, cycles: 2},
+    {code: "SUB X", reg: [4]uint8{5, 3}, flag: "C", wantReg: [4]uint8{1, 3}, wantPtr: 0x101, cycles: 2},
+    {code: "SUB X", reg: [4]uint8{0, 1}, wantReg: [4]uint8{0xFF, 1}, wantFlag: "NC", wantPtr: 0x101, cycles: 2},
+    {code: "SUB $0200", reg: [4]uint8{0x80}, mem: map[uint]uint8{0x200: 1},
+        wantReg: [4]uint8{0x7F}, wantFlag: "V", wantPtr: 0x103, cycles: 5},
+
+    // logic
+    {code: "AND X", reg: [4]uint8{0xF0, 0x3C}, flag: "CV", wantReg: [4]uint8{0x30, 0x3C},
+        wantPtr: 0x101, cycles: 2},
+    {code: "IOR $0200", reg: [4]uint8{0x0F}, mem: map[uint]uint8{0x200: 0xF0},
+        wantReg: [4]uint8{0xFF}, wantFlag: "N", wantPtr: 0x103, cycles: 5},
+    {code: "XOR $0200, Z", reg: [4]uint8{0x55, 0, 0, 1}, flag: "C", mem: map[uint]uint8{0x201: 0x55},
+        wantReg: [4]uint8{0, 0, 0, 1}, wantFlag: "Z", wantPtr: 0x103, cycles: 6},
+
+    // comparison ignores the carry and keeps the accumulator
+    {code: "CMP X", reg: [4]uint8{3, 3}, flag: "C", wantReg: [4]uint8{3, 3},
+        wantFlag: "Z", wantPtr: 0x101, cycles: 2},
+    {code: "CMP X", reg: [4]uint8{2, 3}, wantReg: [4]uint8{2, 3},
+        wantFlag: "NC", wantPtr: 0x101, cycles: 2},
+    {code: "CMP $0200", reg: [4]uint8{0x80}, mem: map[uint]uint8{0x200: 1},
+        wantReg: [4]uint8{0x80}, wantFlag: "V", wantPtr: 0x103, cycles: 5},
+}
+
+
+// flags named by their letters
+func parseFlags (names string) [4]bool {
+    var flag [4]bool
+    for _, c := range names {
+        flag[strings.IndexRune("ZNCV", c)] = true
+    }
+    return flag
+}
+
+
+func TestProcessorOpcodes (t *testing.T) {
+    for _, c := range cpuCases {
+        image, err := Assemble(c.code, "    .org $0100\n    " + c.code)
+        if err != nil {t.Fatal(err)}
+
+        var stack Stack
+        bus := NewFlatBus()
+        bus.Load(0, image)
+        for addr, val := range c.mem {bus.Write(addr, uint(val))}
+        for _, val := range c.stack {stack.Push(val)}
+        cpu := Processor{reg: c.reg, flag: parseFlags(c.flag), masked: c.masked,
+            ptr: 0x100, bus: bus, stack: &stack}
+
+        cpu.Cycle()
+
+        if cpu.reg != c.wantReg {
+            t.Errorf("%s: registers %v instead of %v", c.code, cpu.reg, c.wantReg)
+        }
+        if cpu.flag != parseFlags(c.wantFlag) {
+            t.Errorf("%s: flags %v instead of %q", c.code, cpu.flag, c.wantFlag)
+        }
+        if cpu.masked != c.wantMasked {
+            t.Errorf("%s: masked %v instead of %v", c.code, cpu.masked, c.wantMasked)
+        }
+        if cpu.ptr != c.wantPtr {
+            t.Errorf("%s: pointer $%04X instead of $%04X", c.code, cpu.ptr, c.wantPtr)
+        }
+        if !bytes.Equal(stack.data[:stack.ptr], c.wantStack) {
+            t.Errorf("%s: stack % X instead of % X", c.code, stack.data[:stack.ptr], c.wantStack)
+        }
+
+        // memory written is checked, any other byte must be left untouched
+        for addr := uint(0x200); addr < 0x300; addr += 1 {
+            want, ok := c.wantMem[addr]
+            if !ok {want = c.mem[addr]}
+            if got := uint8(bus.GetByte(addr)); got != want {
+                t.Errorf("%s: $%04X is $%02X instead of $%02X", c.code, addr, got, want)
+            }
+        }
+
+        if cpu.cycles != c.cycles {
+            t.Errorf("%s: %d cycles instead of %d", c.code, cpu.cycles, c.cycles)
+        }
+        if op := image[0x100]; uint64(opCycles[op]) != c.cycles {
+            t.Errorf("%s: opcode $%02X costs %d cycles in the table instead of %d", c.code, op, opCycles[op], c.cycles)
+        }
+    }
+}
+
+
+// bytes without mnemonic are executed as NOP
+func TestProcessorUnknownOpcodes (t *testing.T) {
+    for inst := uint(0); inst < 0x100; inst += 1 {
+        if decodeOp(inst) != nil || inst == opRTN + 1 {continue}
+
+        bus := NewFlatBus()
+        bus.Write(0x100, inst)
+        cpu := Processor{reg: [4]uint8{1, 2, 3, 4}, ptr: 0x100, bus: bus, stack: &Stack{}}
+        cpu.Cycle()
+        if cpu.ptr != 0x101 || cpu.cycles != 2 || cpu.reg != [4]uint8{1, 2, 3, 4} {
+            t.Errorf("$%02X: not executed as NOP", inst)
+        }
+    }
+}
+
+
+// faults of the stack halt the processor instead of crashing
+func TestProcessorStackFault (t *testing.T) {
+    bus := NewFlatBus()
+    bus.Write(0x100, opRTI)
+    cpu := Processor{ptr: 0x100, bus: bus, stack: &Stack{}}
+    if err := cpu.RunFrame(); err == nil || cpu.Fault() == nil {
+        t.Fatal("RTI with an empty stack did not halt the processor")
+    }
+    cycles := cpu.cycles
+    cpu.Cycle()
+    if cpu.cycles != cycles {t.Error("halted processor executed an instruction")}
+
+    // nested interrupts fill the stack, the last one cannot be entered
+    bus = NewFlatBus()
+    bus.Write(vecNMI    , 0x01)
+    bus.Write(vecNMI + 1, 0x00)
+    cpu = Processor{ptr: 0x100, bus: bus, stack: &Stack{}}
+    for i := 0; i < 0x100 / 3; i += 1 {
+        cpu.RequestNMI()
+        cpu.Cycle()
+    }
+    if cpu.Fault() != nil || cpu.stack.ptr != 0x100 / 3 * 3 {
+        t.Fatalf("stack filled with %d bytes: %v", cpu.stack.ptr, cpu.Fault())
+    }
+    cpu.RequestNMI()
+    cpu.Cycle()
+    if cpu.Fault() == nil || cpu.stack.ptr != 0x100 / 3 * 3 {
+        t.Errorf("interrupt entered with a full stack: %d bytes, %v", cpu.stack.ptr, cpu.Fault())
+    }
+}
+
+
+// the cycles past the end of a frame are deducted from the next one
+func TestProcessorFrameCycles (t *testing.T) {
+    // 11 cycles per iteration, most frames end in the middle of an instruction
+    image, err := Assemble("loop", "loop: INC $0200, X\n JMP loop")
+    if err != nil {t.Fatal(err)}
+    bus := NewFlatBus()
+    bus.Load(0, image)
+    cpu := Processor{bus: bus, stack: &Stack{}}
+
+    for n := uint64(1); n <= 600; n += 1 {
+        if err := cpu.RunFrame(); err != nil {t.Fatal(err)}
+        if end := n * cyclesPerFrame; cpu.cycles < end || cpu.cycles >= end + 7 {
+            t.Fatalf("frame %d ends at cycle %d instead of %d", n, cpu.cycles, end)
+        }
+    }
+}
+
+
+// a maskable interrupt waits for ENI, its handler returns with RTI
+// and the flags of the program are restored
+func TestProcessorIRQ (t *testing.T) {
+    image, err := Assemble("irq", `
+        .org $0100
+        NOP
+        NOP
+        ENI
+loop:   JMP loop
+
+        .org $0300
+        INC $0200
+        SET V
+        CLR C
+        RTI
+
+        .org $FFFE
+        .word $0300
+`)
+    if err != nil {t.Fatal(err)}
+    bus := NewFlatBus()
+    bus.Load(0, image)
+    cpu := Processor{ptr: 0x100, bus: bus, stack: &Stack{}, masked: true, flag: parseFlags("C")}
+    cpu.RequestIRQ()
+
+    steps := []struct {
+        ptr    uint
+        masked bool
+        count  uint // times the handler was entered
+        stack  int
+    }{
+        {0x101, true , 0, 0}, // NOP, masked
+        {0x102, true , 0, 0}, // NOP, masked
+        {0x103, false, 0, 0}, // ENI
+        {0x303, true , 1, 3}, // interrupt and INC
+        {0x304, true , 1, 3}, // SET V
+        {0x305, true , 1, 3}, // CLR C
+        {0x103, false, 1, 0}, // RTI
+        {0x103, false, 1, 0}, // JMP loop
+    }
+    for i, step := range steps {
+        cpu.Cycle()
+        if cpu.ptr != step.ptr || cpu.masked != step.masked || bus.GetByte(0x200) != step.count || cpu.stack.ptr != step.stack {
+            t.Fatalf("step %d: pointer $%04X masked %v count %d stack %d instead of $%04X %v %d %d", i,
+                cpu.ptr, cpu.masked, bus.GetByte(0x200), cpu.stack.ptr, step.ptr, step.masked, step.count, step.stack)
+        }
+    }
+    if cpu.flag != parseFlags("C") {t.Errorf("flags %v not restored by RTI", cpu.flag)}
+
+    // an interrupt requested in the handler waits for RTI
+    cpu.RequestIRQ()
+    cpu.Cycle()
+    cpu.RequestIRQ()
+    for i := 0; i < 3; i += 1 {cpu.Cycle()}
+    if bus.GetByte(0x200) != 2 || cpu.ptr != 0x103 {t.Fatalf("count %d at $%04X after the first handler", bus.GetByte(0x200), cpu.ptr)}
+    for i := 0; i < 4; i += 1 {cpu.Cycle()}
+    if bus.GetByte(0x200) != 3 || cpu.ptr != 0x103 || cpu.masked {
+        t.Errorf("count %d at $%04X masked %v after the second handler", bus.GetByte(0x200), cpu.ptr, cpu.masked)
+    }
+
+    // DSI keeps the request pending
+    cpu.masked = true
+    cpu.RequestIRQ()
+    for i := 0; i < 5; i += 1 {cpu.Cycle()}
+    if bus.GetByte(0x200) != 3 || !cpu.irq {t.Errorf("masked interrupt serviced")}
+}
+
+
+// pressing a button interrupts the program of the console
+func TestInputIRQ (t *testing.T) {
+    image, err := Assemble("input", `
+        .org $4000
+loop:   JMP loop
+irq:    LOD A, $3000
+        STR A, $0200
+        INC $0201
+        RTI
+
+        .org $FFFE
+        .word irq
+`)
+    if err != nil {t.Fatal(err)}
+    con := NewConsole()
+    cart := &Cartridge{Origin: addrROM, Entry: addrROM, Program: image[addrROM:], Tiles: make([]TileBank, 1)}
+    if err := con.Insert(cart); err != nil {t.Fatal(err)}
+
+    frames := []struct {
+        buttons uint8
+        count   uint // interrupts serviced so far
+        read    uint // buttons read by the handler
+    }{
+        {0                   , 0, 0},
+        {ButtonA             , 1, ButtonA},
+        {ButtonA             , 1, ButtonA},
+        {ButtonA | ButtonLeft, 2, ButtonA | ButtonLeft},
+        {ButtonLeft          , 2, ButtonA | ButtonLeft}, // released
+        {ButtonB             , 3, ButtonB},
+    }
+    for i, f := range frames {
+        con.input.SetButtons(0, f.buttons)
+        if err := con.Frame(); err != nil {t.Fatal(err)}
+        if count, read := con.bus.GetByte(0x201), con.bus.GetByte(0x200); count != f.count || read != f.read {
+            t.Errorf("frame %d: %d interrupts reading $%02X instead of %d reading $%02X", i, count, read, f.count, f.read)
+        }
+    }
+}
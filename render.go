@@ -0,0 +1,140 @@
+package main
+
+/*
+    Backends drawing the scene of the PPU, the tile maps are drawn
+    first and the sprites over them
+
+    the screen is a volume of 128×128×128 voxels, x goes right,
+    y goes down and z goes away from the viewer, positions are
+    bytes and wrap around s.t. tiles can enter the screen partially
+*/
+
+// use OpenGL 4.6
+import (
+    "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+
+const screenSize = mapSize * 8
+
+
+// backend drawing tiles placed in the screen
+type Renderer interface {
+    Begin () // start a new frame
+    DrawTile (tile *Tile, palette *Palette, pos Vector3, rot Byte3, mir Bool3)
+    DrawMesh (mesh *Mesh, palettes []Palette, pos Vector3)
+    Overlay () // next draws are in front of the previous ones
+    End   () // finish the frame
+}
+
+
+// draw the tile maps and the sprites with a renderer
+func (ppu *PPU) Draw (r Renderer) {
+    r.Begin()
+    DrawMapChunks(r, &ppu.maps[0], &ppu.maps[1], ppu.Tiles(), ppu.palettes[:4], ppu.scroll, MeshGreedy)
+    ppu.DrawSprites(r)
+    r.End()
+}
+
+
+// draw the sprites of the attribute table over the tile maps, the
+// sprites with priority are drawn in front of everything
+//( with the sprite limit, the dropped sprites are not drawn )
+func (ppu *PPU) DrawSprites (r Renderer) {
+    tiles := ppu.Tiles()
+    visible, _ := ppu.VisibleSprites()
+    for _, priority := range []bool{false, true} {
+        if priority {r.Overlay()}
+        for _, i := range visible {
+            sprite := &ppu.sprites[i]
+            if sprite.priority != priority {continue}
+            pal := &ppu.palettes[4 + sprite.id_pal % 4]
+            r.DrawTile(&tiles[sprite.id_tile % nbBankTiles], pal, sprite.pos, sprite.rot, sprite.mir)
+        }
+    }
+}
+
+
+// locations of the uniforms of the shaders
+const (
+    uniformOffset   = 0 // vec3, position of the mesh
+    uniformPalettes = 1 // 4 palettes of 3 vec3
+)
+
+
+// draw with OpenGL in the current context
+type GLRenderer struct {
+    Mode    MeshMode // mesher building the tiles
+    Program uint32   // made by CreateProgram
+    meshes  map[orientedTile]*Mesh
+}
+
+
+// tile drawn with one of the 48 distinct orientations
+type orientedTile struct {
+    tile     *Tile
+    index    uint8
+    mirrored bool
+}
+
+
+func (r *GLRenderer) Begin () {
+    gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+    gl.UseProgram(r.Program)
+}
+
+
+// build the mesh of the tile the first time it is drawn with an orientation
+func (r *GLRenderer) DrawTile (tile *Tile, palette *Palette, pos Vector3, rot Byte3, mir Bool3) {
+    if r.meshes == nil {r.meshes = make(map[orientedTile]*Mesh)}
+    index, mirrored := Orientation(rot, mir)
+    key  := orientedTile{tile, index, mirrored}
+    mesh := r.meshes[key]
+    if mesh == nil {
+        oriented := tile.Orient(OrientationRot(index), Bool3{x: mirrored})
+        mesh = &Mesh{}
+        mesh.SetVertices(oriented.BuildMesh(r.Mode))
+        r.meshes[key] = mesh
+    }
+    if len(mesh.Vertices) != 0 {r.DrawMesh(mesh, []Palette{*palette}, pos)}
+}
+
+
+// upload the vertices of the mesh when they change
+//( the palettes are bound for every draw, they change when animated )
+func (r *GLRenderer) DrawMesh (mesh *Mesh, palettes []Palette, pos Vector3) {
+    const sizeOfVert = nbCoords4Vert * sizeOfCoord
+    if !mesh.uploaded {
+        if mesh.VBO == 0 {gl.GenBuffers(1, &mesh.VBO)}
+        gl.BindBuffer(gl.ARRAY_BUFFER, mesh.VBO)
+        gl.BufferData(gl.ARRAY_BUFFER, len(mesh.Vertices), gl.Ptr(mesh.Vertices), gl.STATIC_DRAW)
+        mesh.uploaded = true
+    }
+
+    // the copy of the mesh that can be visible when it wraps around
+    var offset [3]float32
+    for i, c := range [3]uint{pos.x & 0xFF, pos.y & 0xFF, pos.z & 0xFF} {
+        offset[i] = float32(c)
+        if c >= screenSize {offset[i] -= 0x100}
+    }
+    gl.Uniform3fv(uniformOffset, 1, &offset[0])
+    for i := range palettes {
+        palettes[i].Use(uniformPalettes + int32(i * nbColors4Pal))
+    }
+
+    gl.BindBuffer(gl.ARRAY_BUFFER, mesh.VBO)
+    gl.EnableVertexAttribArray(0)
+    gl.EnableVertexAttribArray(1)
+    gl.VertexAttribPointer (0, 3, gl.UNSIGNED_BYTE, false, sizeOfVert, nil)
+    gl.VertexAttribIPointer(1, 1, gl.UNSIGNED_BYTE, sizeOfVert, gl.PtrOffset(3 * sizeOfCoord))
+    gl.DrawArrays(gl.TRIANGLES, 0, int32(len(mesh.Vertices) / nbCoords4Vert))
+    gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+}
+
+
+func (r *GLRenderer) Overlay () {
+    gl.Clear(gl.DEPTH_BUFFER_BIT)
+}
+
+
+func (r *GLRenderer) End () {}
@@ -0,0 +1,264 @@
+package main
+
+/*
+    Save states, snapshot of the whole console in a binary file
+    a state is restored on a console running the same cartridge
+
+    "VOXS", version, processor, stack, RAM, mapper registers,
+    checksum of the ROM, PPU, controllers, APU, CRC-32 of everything before
+*/
+
+import (
+    "io"
+    "fmt"
+    "bytes"
+    "hash/crc32"
+    "io/ioutil"
+    "encoding/binary"
+)
+
+
+const (
+    stateMagic   = "VOXS"
+    stateVersion = 6
+)
+
+
+// helper to write values in big endian
+type stateWriter struct {
+    bytes.Buffer
+}
+
+func (w *stateWriter) u8  (v uint8 ) {w.WriteByte(v)}
+func (w *stateWriter) u16 (v uint  ) {w.Write([]uint8{uint8(v >> 8), uint8(v)})}
+func (w *stateWriter) u64 (v uint64) {
+    var b [8]uint8
+    binary.BigEndian.PutUint64(b[:], v)
+    w.Write(b[:])
+}
+func (w *stateWriter) bool (v bool) {
+    if v {w.u8(1)} else {w.u8(0)}
+}
+
+
+// helper to read values in big endian, reading past the end is an error
+type stateReader struct {
+    data []uint8
+    err  error
+}
+
+func (r *stateReader) next (n int) []uint8 {
+    if r.err != nil || len(r.data) < n {
+        r.err  = fmt.Errorf("Invalid state: unexpected end of data")
+        return make([]uint8, n)
+    }
+    b := r.data[:n]
+    r.data = r.data[n:]
+    return b
+}
+
+func (r *stateReader) u8   () uint8  {return r.next(1)[0]}
+func (r *stateReader) u16  () uint   {return uint(binary.BigEndian.Uint16(r.next(2)))}
+func (r *stateReader) u64  () uint64 {return binary.BigEndian.Uint64(r.next(8))}
+func (r *stateReader) bool () bool   {return r.u8() != 0}
+
+
+// checksum of the ROM currently visible
+func (con *Console) romChecksum () uint32 {
+    rom := make([]uint8, sizeROM)
+    for i := range rom {
+        rom[i] = uint8(con.bus.GetByte(addrROM + uint(i)))
+    }
+    return crc32.ChecksumIEEE(rom)
+}
+
+
+// write a snapshot of the console
+func (con *Console) SaveState (out io.Writer) error {
+    var w stateWriter
+    w.WriteString(stateMagic)
+    w.u8(stateVersion)
+
+    // processor
+    cpu := &con.cpu
+    w.Write(cpu.reg[:])
+    w.u8  (cpu.flagsByte())
+    w.bool(cpu.irq)
+    w.bool(cpu.nmi)
+    w.u16 (cpu.ptr)
+    w.u64 (cpu.cycles)
+    w.u64 (cpu.frame)
+
+    // memories
+    w.Write(con.stack.data[:])
+    w.u16  (uint(con.stack.ptr))
+    w.Write(con.ram.data)
+
+    regs := con.mapper.Registers()
+    w.u8(uint8(len(regs)))
+    w.Write(regs)
+    var sum [4]uint8
+    binary.BigEndian.PutUint32(sum[:], con.romChecksum())
+    w.Write(sum[:])
+
+    // picture
+    ppu := &con.ppu
+    w.Write(ppu.regs[:])
+    w.bool (ppu.vblank)
+    for _, hits := range ppu.hits.Sprites {w.u64(hits)}
+    w.u64(ppu.hits.Map)
+    w.bool(ppu.overflow)
+    w.u16(ppu.scroll.x); w.u16(ppu.scroll.y); w.u16(ppu.scroll.z)
+    for i := range ppu.maps {
+        w.Write(ppu.maps[i].tils[:])
+        w.Write(ppu.maps[i].rots[:])
+        w.Write(ppu.maps[i].mirs[:])
+        w.Write(ppu.maps[i].pals[:])
+    }
+    for i := range ppu.palettes {
+        for _, color := range ppu.palettes[i].indices {
+            w.u8(uint8(color))
+        }
+        anim := &ppu.anims[i]
+        w.u8(ppu.palettes[i].shift)
+        w.u8(fadeByte(ppu.palettes[i].fade))
+        w.u8(anim.cycle); w.u8(fadeByte(anim.target)); w.u8(anim.speed)
+        w.Write(anim.elapsed[:])
+    }
+    for i := range ppu.sprites {
+        s := &ppu.sprites[i]
+        w.u8 (uint8(s.id_tile))
+        w.u8 (uint8(s.id_pal ))
+        w.u16(s.pos.x); w.u16(s.pos.y); w.u16(s.pos.z)
+        w.u8 (s.rot.GetByte())
+        w.u8 (s.mir.GetByte())
+        w.bool(s.priority)
+    }
+
+    // controllers and audio
+    w.Write(con.input.pads[:])
+    con.apu.save(&w)
+
+    binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(w.Bytes()))
+    w.Write(sum[:])
+    _, err := out.Write(w.Bytes())
+    return err
+}
+
+
+// restore a snapshot of the console
+//( the console is left untouched if the snapshot is invalid )
+func (con *Console) LoadState (in io.Reader) error {
+    data, err := ioutil.ReadAll(in)
+    if err != nil {return err}
+
+    // validate the whole file before modifying the console
+    if len(data) < len(stateMagic) + 5 || string(data[:4]) != stateMagic {
+        return fmt.Errorf("Invalid state: bad magic")
+    }
+    if data[4] != stateVersion {
+        return fmt.Errorf("Invalid state: unsupported version %d", data[4])
+    }
+    body := data[:len(data) - 4]
+    if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(body):]) {
+        return fmt.Errorf("Invalid state: checksum mismatch")
+    }
+
+    // restore into a copy of the console, a fault halting the processor is cleared
+    tmp := *con
+    tmp.cpu.fault = nil
+    tmp.stack.err = nil
+    tmp.ram = &Memory{append([]uint8(nil), con.ram.data...)}
+    r := &stateReader{data: body[5:]}
+
+    cpu := &tmp.cpu
+    copy(cpu.reg[:], r.next(4))
+    cpu.setFlagsByte(r.u8())
+    cpu.irq    = r.bool()
+    cpu.nmi    = r.bool()
+    cpu.ptr    = r.u16()
+    cpu.cycles = r.u64()
+    cpu.frame  = r.u64()
+
+    copy(tmp.stack.data[:], r.next(len(tmp.stack.data)))
+    tmp.stack.ptr = int(r.u16())
+    copy(tmp.ram.data, r.next(len(tmp.ram.data)))
+
+    regs := r.next(int(r.u8()))
+    sum  := binary.BigEndian.Uint32(r.next(4))
+
+    ppu := &tmp.ppu
+    copy(ppu.regs[:], r.next(ppuSize))
+    ppu.vblank = r.bool()
+    for i := range ppu.hits.Sprites {ppu.hits.Sprites[i] = r.u64()}
+    ppu.hits.Map = r.u64()
+    ppu.overflow = r.bool()
+    ppu.scroll.Set(r.u16(), r.u16(), r.u16())
+    for i := range ppu.maps {
+        copy(ppu.maps[i].tils[:], r.next(nbTiles))
+        copy(ppu.maps[i].rots[:], r.next(nbTiles))
+        copy(ppu.maps[i].mirs[:], r.next(nbTiles))
+        copy(ppu.maps[i].pals[:], r.next(nbTiles))
+        ppu.maps[i].Invalidate()
+    }
+    for i := range ppu.palettes {
+        for c := range ppu.palettes[i].indices {
+            ppu.palettes[i].indices[c] = uint(r.u8()) % nbColors
+        }
+        anim := &ppu.anims[i]
+        ppu.palettes[i].Animate(r.u8(), fadeOf(r.u8()))
+        anim.cycle, anim.target, anim.speed = r.u8(), fadeOf(r.u8()), r.u8()
+        copy(anim.elapsed[:], r.next(len(anim.elapsed)))
+    }
+    for i := range ppu.sprites {
+        s := &ppu.sprites[i]
+        s.id_tile = uint(r.u8())
+        s.id_pal  = uint(r.u8())
+        s.pos.Set(r.u16(), r.u16(), r.u16())
+        s.rot.SetByte(r.u8())
+        s.mir.SetByte(r.u8())
+        s.priority = r.bool()
+    }
+    copy(tmp.input.pads[:], r.next(nbPads))
+    tmp.apu.load(r)
+
+    if r.err != nil {return r.err}
+    if len(r.data) != 0 {return fmt.Errorf("Invalid state: %d bytes left", len(r.data))}
+    if tmp.stack.ptr > len(tmp.stack.data) {return fmt.Errorf("Invalid state: stack overflow")}
+
+    // the ROM must match once the banks are selected
+    old := con.mapper.Registers()
+    con.mapper.SetRegisters(regs)
+    if con.romChecksum() != sum {
+        con.mapper.SetRegisters(old)
+        return fmt.Errorf("Invalid state: saved with another cartridge")
+    }
+
+    // keep the devices mapped on the bus
+    copy(con.ram.data, tmp.ram.data)
+    con.cpu   = tmp.cpu
+    con.stack = tmp.stack
+    con.ppu   = tmp.ppu
+    con.input = tmp.input
+    con.apu   = tmp.apu
+    return nil
+}
+
+
+// write a snapshot of the console in a file
+func (con *Console) SaveStateFile (path string) error {
+    var buf bytes.Buffer
+    if err := con.SaveState(&buf); err != nil {return err}
+    return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+
+// restore a snapshot of the console from a file
+func (con *Console) LoadStateFile (path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {return err}
+    if err := con.LoadState(bytes.NewReader(data)); err != nil {
+        return fmt.Errorf("%s: %v", path, err)
+    }
+    return nil
+}
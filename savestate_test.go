@@ -0,0 +1,196 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "bytes"
+    "strings"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+)
+
+
+// console running a banked cartridge, every device away from its power on state
+func stateConsole (t *testing.T) *Console {
+    con := NewConsole()
+    if err := con.Insert(bankedCartridge(MapperMulti, 0x2000, 8, 2)); err != nil {t.Fatal(err)}
+
+    cpu := &con.cpu
+    cpu.reg , cpu.flag   = [4]uint8{0x12, 0x34, 0x56, 0x78}, [4]bool{true, false, true, false}
+    cpu.ptr , cpu.cycles = 0x6789, 123456
+    cpu.frame, cpu.nmi   = 150000, true
+    for _, v := range []uint8{1, 2, 3} {con.stack.Push(v)}
+    for i := range con.ram.data {con.ram.data[i] = uint8(i * 7)}
+
+    writes := []struct {
+        addr  uint
+        value uint8
+    }{
+        {0x4001, 3}, {0x4004, 1}, // banks of program and of tiles
+        {addrPPU + regScrollX, 0x21}, {addrPPU + regScrollZ, 0x43},
+        {addrPPU + regMap, 1}, {addrPPU + regCellLo, 0x42},
+        {addrPPU + regRot, 0x05}, {addrPPU + regMirPal, 0x1A}, {addrPPU + regTile, 9},
+        {addrPPU + regPal, 5}, {addrPPU + regColor1, 12}, {addrPPU + regColor3, 40},
+        {addrPPU + regSprite, 7}, {addrPPU + regSTile, 3}, {addrPPU + regSPal, 2},
+        {addrPPU + regSX, 0x80}, {addrPPU + regSY, 0x40}, {addrPPU + regSRot, 0x09},
+        {addrPPU + regAnim, 2}, {addrPPU + regCycle, 0x83}, {addrPPU + regFade, 0x82},
+        {addrPPU + regControl, 0x03}, {addrPPU + regSLimit, 4},
+        {addrAPU + regEnable, 0x0F}, {addrAPU + regFrame, 0x80},
+        {addrAPU + regP1Env, 0xBF}, {addrAPU + regP1Env + 2, 0x40}, {addrAPU + regP1Env + 3, 0x09},
+        {addrAPU + regTriLin, 0x7F}, {addrAPU + regTriHi, 0x08}, {addrAPU + regNoiEnv, 0x05},
+    }
+    for _, w := range writes {con.bus.Write(w.addr, uint(w.value))}
+    con.ppu.Animate()
+    con.ppu.UpdateCollisions()
+    con.input.SetButtons(1, ButtonA | ButtonB)
+    return con
+}
+
+
+func saveState (t *testing.T, con *Console) []uint8 {
+    var buf bytes.Buffer
+    if err := con.SaveState(&buf); err != nil {t.Fatal(err)}
+    return buf.Bytes()
+}
+
+
+// a state saved then restored brings every device back
+func TestStateRoundTrip (t *testing.T) {
+    con   := stateConsole(t)
+    saved := saveState(t, con)
+    want  := *con
+    ram   := append([]uint8(nil), con.ram.data...)
+    program, tiles := visibleBanks(con, 0x6000)
+
+    // the game goes on
+    con.cpu.reg, con.cpu.ptr, con.cpu.cycles = [4]uint8{}, 0x4000, 200000
+    con.cpu.setFlagsByte(0)
+    con.stack.Pull()
+    for i := range con.ram.data {con.ram.data[i] = 0}
+    for _, addr := range []uint{0x4001, 0x4004, addrPPU + regScrollX, addrPPU + regTile, addrPPU + regColor1,
+                                addrPPU + regSX, addrPPU + regSLimit, addrAPU + regEnable, addrAPU + regFrame} {
+        con.bus.Write(addr, 0)
+    }
+    con.ppu.Animate()
+    con.input.SetButtons(1, 0)
+    if bytes.Equal(saveState(t, con), saved) {t.Fatal("the state did not change")}
+
+    if err := con.LoadState(bytes.NewReader(saved)); err != nil {t.Fatal(err)}
+    cpu, ppu, apu := &con.cpu, &con.ppu, &con.apu
+    if cpu.reg != want.cpu.reg || cpu.flag != want.cpu.flag || cpu.ptr != want.cpu.ptr ||
+       cpu.cycles != want.cpu.cycles || cpu.frame != want.cpu.frame || cpu.irq != want.cpu.irq || cpu.nmi != want.cpu.nmi {
+        t.Error("processor not restored")
+    }
+    if con.stack.data != want.stack.data || con.stack.ptr != want.stack.ptr {t.Error("stack not restored")}
+    if !bytes.Equal(con.ram.data, ram) {t.Error("RAM not restored")}
+    if p, c := visibleBanks(con, 0x6000); p != program || c != tiles {
+        t.Errorf("banks %d and %d instead of %d and %d", p, c, program, tiles)
+    }
+    if ppu.regs != want.ppu.regs || ppu.scroll != want.ppu.scroll || ppu.sprites != want.ppu.sprites ||
+       ppu.hits != want.ppu.hits || ppu.overflow != want.ppu.overflow || ppu.vblank != want.ppu.vblank {
+        t.Error("PPU not restored")
+    }
+    for i := range ppu.maps {
+        m, w := &ppu.maps[i], &want.ppu.maps[i]
+        if m.tils != w.tils || m.rots != w.rots || m.mirs != w.mirs || m.pals != w.pals {t.Errorf("map %d not restored", i)}
+    }
+    if ppu.palettes != want.ppu.palettes || ppu.anims != want.ppu.anims {t.Error("palettes not restored")}
+    if con.input.pads != want.input.pads {t.Error("controllers not restored")}
+    if apu.pulses != want.apu.pulses || apu.tri != want.apu.tri || apu.noi != want.apu.noi || apu.five != want.apu.five ||
+       apu.sequence != want.apu.sequence || apu.counter != want.apu.counter || apu.cycles != want.apu.cycles {
+        t.Error("APU not restored")
+    }
+    if !bytes.Equal(saveState(t, con), saved) {t.Error("restored state saved differently")}
+}
+
+
+// invalid states are rejected and leave the console untouched
+func TestStateRejected (t *testing.T) {
+    valid := saveState(t, stateConsole(t))
+    cases := []struct {
+        name   string
+        change func (data []uint8) []uint8
+        err    string
+    }{
+        {"empty"      , func (d []uint8) []uint8 {return nil}, "bad magic"},
+        {"too short"  , func (d []uint8) []uint8 {return d[:6]}, "bad magic"},
+        {"bad magic"  , func (d []uint8) []uint8 {copy(d, "VOXT"); return reseal(d)}, "bad magic"},
+        {"old version", func (d []uint8) []uint8 {d[4] = stateVersion - 1; return reseal(d)}, "unsupported version"},
+        {"new version", func (d []uint8) []uint8 {d[4] = stateVersion + 1; return reseal(d)}, "unsupported version"},
+        {"cut"        , func (d []uint8) []uint8 {return d[:len(d) / 2]}, "checksum"},
+        {"truncated"  , func (d []uint8) []uint8 {return reseal(d[:len(d) / 2])}, "unexpected end"},
+        {"trailing"   , func (d []uint8) []uint8 {return reseal(append(d, 0))}, "bytes left"},
+        {"corrupted"  , func (d []uint8) []uint8 {d[100] ^= 0x10; return d}, "checksum"},
+    }
+    for _, c := range cases {
+        con    := stateConsole(t)
+        before := saveState(t, con)
+        data   := c.change(append([]uint8(nil), valid...))
+        err    := con.LoadState(bytes.NewReader(data))
+        if err == nil || !strings.Contains(err.Error(), c.err) {
+            t.Errorf("%s: %v instead of an error about %q", c.name, err, c.err)
+        }
+        if !bytes.Equal(saveState(t, con), before) {t.Errorf("%s: console modified", c.name)}
+    }
+
+    // a state of another cartridge
+    con := NewConsole()
+    if err := con.Insert(testCartridge()); err != nil {t.Fatal(err)}
+    if err := con.LoadState(bytes.NewReader(valid)); err == nil || !strings.Contains(err.Error(), "another cartridge") {
+        t.Errorf("other cartridge: %v", err)
+    }
+}
+
+
+// restoring a state starts again a processor halted by a fault
+func TestStateClearsFault (t *testing.T) {
+    image, err := Assemble("fault", `
+        .org $4000
+wait:   LOD A, $0200
+        BRC Z, wait
+fill:   PSH A
+        JMP fill
+`)
+    if err != nil {t.Fatal(err)}
+    con := NewConsole()
+    if err := con.Insert(&Cartridge{Entry: addrROM, Origin: addrROM, Program: image[addrROM:], Tiles: make([]TileBank, 1)}); err != nil {
+        t.Fatal(err)
+    }
+    saved := saveState(t, con)
+
+    con.ram.data[0x200] = 1
+    if err := con.Frame(); err == nil {t.Fatal("the stack did not overflow")}
+    if err := con.Frame(); err == nil {t.Fatal("the processor runs after a fault")}
+
+    if err := con.LoadState(bytes.NewReader(saved)); err != nil {t.Fatal(err)}
+    for i := 0; i < 3; i += 1 {
+        if err := con.Frame(); err != nil {t.Fatalf("frame %d after restoring: %v", i, err)}
+    }
+    if con.cpu.Fault() != nil || con.stack.Err() != nil {t.Error("fault kept")}
+}
+
+
+// the layout of a version never changes, a new layout needs a new version
+//( go test -run StateGolden -update writes the state of a new version )
+func TestStateGolden (t *testing.T) {
+    state := saveState(t, stateConsole(t))
+    path  := filepath.Join("testdata", "state", fmt.Sprintf("v%d.state", stateVersion))
+    if *update {
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {t.Fatal(err)}
+        if err := ioutil.WriteFile(path, state, 0644); err != nil {t.Fatal(err)}
+        return
+    }
+
+    golden, err := ioutil.ReadFile(path)
+    if err != nil {t.Fatal(err)}
+    if !bytes.Equal(state, golden) {
+        t.Fatalf("the layout of version %d changed, bump stateVersion and run with -update", stateVersion)
+    }
+
+    // the golden state is restored on the same cartridge
+    con := NewConsole()
+    if err := con.Insert(bankedCartridge(MapperMulti, 0x2000, 8, 2)); err != nil {t.Fatal(err)}
+    if err := con.LoadState(bytes.NewReader(golden)); err != nil {t.Fatal(err)}
+    if !bytes.Equal(saveState(t, con), golden) {t.Error("golden state restored differently")}
+}
@@ -1,9 +1,9 @@
 #version 460
 
-in vec3 color;
+in vec3 out_color;
 
-out vec4 out_color;
+out vec4 frag_color;
 
 void main () {
-	out_color = vec4(color, 1.0);
+	frag_color = vec4(out_color, 1.0);
 }
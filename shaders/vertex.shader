@@ -1,11 +1,17 @@
 #version 460
 
-layout (location = 0) in uvec3 position;
-layout (location = 1) in uint  color;
+layout (location = 0) in vec3 position;
+layout (location = 1) in uint color; // palette << 2 | color
+
+// position of the mesh in the screen and its palettes of 3 colors
+layout (location = 0) uniform vec3 offset;
+layout (location = 1) uniform vec3 palettes[4 * 3];
 
 out vec3 out_color;
 
 void main () {
-	gl_Position = vec4(vertex + offset, 1.0);
-	out_color = color;
+	// the screen of 128 voxels, y goes down and z away
+	vec3 p = (position + offset) / 64.0 - 1.0;
+	gl_Position = vec4(p.x, -p.y, p.z, 1.0);
+	out_color = palettes[(color >> 2) * 3 + (color & 3) - 1];
 }
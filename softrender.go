@@ -0,0 +1,371 @@
+package main
+
+/*
+    Software renderer, rasterizes the faces of the voxels in an image
+    without any GPU s.t. frames can be rendered headless
+
+    faces are shaded depending on their direction, faces turned away
+    from the camera are culled and the others are hidden with a depth
+    buffer, voxels outside the screen are clipped
+*/
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "image"
+    "image/png"
+    "image/color"
+)
+
+
+// point of view used to project the screen in an image
+type Camera struct {
+    Eye    [3]float64 // position of the camera
+    Target [3]float64 // point looked at
+    Up     [3]float64 // direction of the top of the image
+    FOV    float64    // vertical field of view in degrees, 0 for orthographic
+    Scale  float64    // half of the height seen by an orthographic camera
+}
+
+
+// orthographic camera facing the screen, a voxel per pixel in a 128×128 image
+func FrontCamera () Camera {
+    const c = screenSize / 2
+    return Camera {
+        Eye:    [3]float64{c, c, -screenSize},
+        Target: [3]float64{c, c, c},
+        Up:     [3]float64{0, -1, 0},
+        Scale:  c,
+    }
+}
+
+
+// perspective camera turning around the center of the screen
+//( yaw turns right around the y axis, pitch looks from above, in degrees )
+func OrbitCamera (yaw, pitch float64) Camera {
+    c    := float64(screenSize / 2)
+    dist := float64(screenSize) * 1.6
+    yaw, pitch = yaw * math.Pi / 180, pitch * math.Pi / 180
+    return Camera {
+        Eye: [3]float64 {
+            c - dist * math.Cos(pitch) * math.Sin(yaw),
+            c - dist * math.Sin(pitch),
+            c - dist * math.Cos(pitch) * math.Cos(yaw)},
+        Target: [3]float64{c, c, c},
+        Up:     [3]float64{0, -1, 0},
+        FOV:    50,
+    }
+}
+
+
+// light received by the faces, in the order of faceDefs
+var faceShades = [nbFaces4Vox]float64 {
+    0.85, // front
+    1.00, // top
+    0.70, // left
+    0.60, // back
+    0.45, // bottom
+    0.75, // right
+}
+
+
+// draw the voxels in an RGBA image
+type SoftRenderer struct {
+    Camera     Camera
+    Background color.RGBA
+
+    img   *image.RGBA
+    depth []float64 // nearest surface of every pixel, larger is nearer
+
+    // basis of the camera, computed when a frame begins
+    right, up, forward [3]float64
+    focal, scale float64
+}
+
+
+// create a renderer drawing images of the given size
+func NewSoftRenderer (width, height int, camera Camera) *SoftRenderer {
+    return &SoftRenderer {
+        Camera:     camera,
+        Background: color.RGBA{0, 0, 0, 0xFF},
+        img:        image.NewRGBA(image.Rect(0, 0, width, height)),
+        depth:      make([]float64, width * height),
+    }
+}
+
+
+// image of the last frame drawn
+func (r *SoftRenderer) Image () *image.RGBA {
+    return r.img
+}
+
+
+// clear the image and prepare the camera
+func (r *SoftRenderer) Begin () {
+    for i := 0; i < len(r.img.Pix); i += 4 {
+        bg := r.Background
+        r.img.Pix[i], r.img.Pix[i + 1], r.img.Pix[i + 2], r.img.Pix[i + 3] = bg.R, bg.G, bg.B, bg.A
+    }
+    r.Overlay()
+
+    cam := &r.Camera
+    r.forward = normalize(sub3(cam.Target, cam.Eye))
+    r.right   = normalize(cross3(r.forward, cam.Up))
+    r.up      = cross3(r.right, r.forward)
+    r.focal   = 0
+    r.scale   = cam.Scale
+    if cam.FOV   >  0 {r.focal = 1 / math.Tan(cam.FOV * math.Pi / 360)}
+    if r.scale <= 0 {r.scale = 1}
+}
+
+
+// draw the faces of the voxels that are not hidden by the tile itself
+func (r *SoftRenderer) DrawTile (tile *Tile, palette *Palette, pos Vector3, rot Byte3, mir Bool3) {
+    oriented := tile.Orient(rot, mir)
+    r.drawVertices(oriented.BuildMesh(MeshFaces), pos, func (index uint8) []float32 {
+        return palette.colors[(index - 1) * nbComps4Color:]
+    })
+}
+
+
+// draw a mesh whose colors select one of the palettes
+func (r *SoftRenderer) DrawMesh (mesh *Mesh, palettes []Palette, pos Vector3) {
+    r.drawVertices(mesh.Vertices, pos, func (index uint8) []float32 {
+        return palettes[index >> 2].colors[((index & 0x3) - 1) * nbComps4Color:]
+    })
+}
+
+
+// forget the depth of what is drawn s.t. the next draws cover it
+func (r *SoftRenderer) Overlay () {
+    for i := range r.depth {
+        r.depth[i] = math.Inf(-1)
+    }
+}
+
+
+func (r *SoftRenderer) End () {}
+
+
+// draw the triangles of a mesh placed in the screen
+//( positions wrap around 256, the voxels outside the screen are clipped )
+func (r *SoftRenderer) drawVertices (vertices []uint8, pos Vector3, colors func (uint8) []float32) {
+    var size [3]int
+    for i := 0; i < len(vertices); i += nbCoords4Vert {
+        for a := range size {
+            if int(vertices[i + a]) > size[a] {size[a] = int(vertices[i + a])}
+        }
+    }
+
+    // the mesh may enter the screen from both sides
+    origin := [3]int{int(pos.x & 0xFF), int(pos.y & 0xFF), int(pos.z & 0xFF)}
+    for copies := 0; copies < 8; copies += 1 {
+        var offset [3]float64
+        visible := true
+        for a := range origin {
+            p := origin[a]
+            if (copies >> uint(a)) & 0x1 != 0 {p -= 0x100}
+            if p >= screenSize || p + size[a] <= 0 {visible = false}
+            offset[a] = float64(p)
+        }
+        if !visible {continue}
+
+        const sizeOfTri = 3 * nbCoords4Vert
+        for i := 0; i + sizeOfTri <= len(vertices); i += sizeOfTri {
+            var tri [3][3]float64
+            for k := range tri {
+                for a := range tri[k] {
+                    tri[k][a] = float64(vertices[i + k * nbCoords4Vert + a]) + offset[a]
+                }
+            }
+            r.triangle(tri, colors(vertices[i + 3]))
+        }
+    }
+}
+
+
+// color of a voxel on a face receiving the given light
+func shade (rgb []float32, light float64) color.RGBA {
+    comp := func (v float32) uint8 {
+        return uint8(math.Round(math.Min(1, math.Max(0, float64(v) * light)) * 255))
+    }
+    return color.RGBA{comp(rgb[0]), comp(rgb[1]), comp(rgb[2]), 0xFF}
+}
+
+
+// project a point of the screen in the image
+//( returns the position in pixels and the value kept in the depth buffer )
+func (r *SoftRenderer) project (p [3]float64) (float64, float64, float64, bool) {
+    d := sub3(p, r.Camera.Eye)
+    x, y, z := dot3(d, r.right), dot3(d, r.up), dot3(d, r.forward)
+
+    var sx, sy, depth float64
+    if r.focal > 0 {
+        // points behind the camera cannot be projected
+        if z < 1e-3 {return 0, 0, 0, false}
+        sx, sy, depth = x * r.focal / z, y * r.focal / z, 1 / z
+    } else {
+        sx, sy, depth = x / r.scale, y / r.scale, -z
+    }
+
+    // keep the aspect ratio using the height of the image
+    w, h := float64(r.img.Rect.Dx()), float64(r.img.Rect.Dy())
+    return w / 2 + sx * h / 2, (1 - sy) * h / 2, depth, true
+}
+
+
+// point of the screen seen by a pixel at the given depth
+func (r *SoftRenderer) unproject (px, py, depth float64) [3]float64 {
+    w, h := float64(r.img.Rect.Dx()), float64(r.img.Rect.Dy())
+    sx, sy := (px - w / 2) / (h / 2), 1 - py / (h / 2)
+
+    var x, y, z float64
+    if r.focal > 0 {
+        z = 1 / depth
+        x, y = sx * z / r.focal, sy * z / r.focal
+    } else {
+        x, y, z = sx * r.scale, sy * r.scale, -depth
+    }
+    p := r.Camera.Eye
+    for a := range p {
+        p[a] += r.right[a] * x + r.up[a] * y + r.forward[a] * z
+    }
+    return p
+}
+
+
+// fill a face of a voxel, testing the depth of every pixel
+//( triangles crossing the plane of the camera are dropped )
+func (r *SoftRenderer) triangle (tri [3][3]float64, rgb []float32) {
+    // faces are aligned on the axes and wound outwards
+    normal := cross3(sub3(tri[1], tri[0]), sub3(tri[2], tri[0]))
+    n := 0
+    for a := 1; a < 3; a += 1 {
+        if math.Abs(normal[a]) > math.Abs(normal[n]) {n = a}
+    }
+    face := faceOf(n, normal[n] > 0)
+
+    // faces turned away from the camera are hidden
+    view := r.forward
+    if r.focal > 0 {view = sub3(tri[0], r.Camera.Eye)}
+    if dot3(normal, view) >= 0 {return}
+
+    // clip the faces of the voxels outside the screen
+    voxel := tri[0][n]
+    if normal[n] > 0 {voxel -= 1}
+    if voxel < 0 || voxel >= screenSize {return}
+    clip := false
+    for _, v := range tri {
+        for a := range v {
+            if v[a] < 0 || v[a] > screenSize {clip = true}
+        }
+    }
+
+    ax, ay, ad, ok1 := r.project(tri[0])
+    bx, by, bd, ok2 := r.project(tri[1])
+    cx, cy, cd, ok3 := r.project(tri[2])
+    if !ok1 || !ok2 || !ok3 {return}
+
+    area := (bx - ax) * (cy - ay) - (by - ay) * (cx - ax)
+    if area == 0 {return}
+    rgba := shade(rgb, faceShades[face])
+
+    // bounding box of the triangle inside the image
+    width, height := r.img.Rect.Dx(), r.img.Rect.Dy()
+    minX := int(math.Max(0, math.Floor(math.Min(ax, math.Min(bx, cx)))))
+    minY := int(math.Max(0, math.Floor(math.Min(ay, math.Min(by, cy)))))
+    maxX := int(math.Min(float64(width  - 1), math.Ceil(math.Max(ax, math.Max(bx, cx)))))
+    maxY := int(math.Min(float64(height - 1), math.Ceil(math.Max(ay, math.Max(by, cy)))))
+
+    for py := minY; py <= maxY; py += 1 {
+    for px := minX; px <= maxX; px += 1 {
+        // barycentric coordinates of the center of the pixel
+        x, y := float64(px) + 0.5, float64(py) + 0.5
+        wa := ((bx - x) * (cy - y) - (by - y) * (cx - x)) / area
+        wb := ((cx - x) * (ay - y) - (cy - y) * (ax - x)) / area
+        wc := 1 - wa - wb
+        if wa < 0 || wb < 0 || wc < 0 {continue}
+
+        i := py * width + px
+        d := wa * ad + wb * bd + wc * cd
+        if d <= r.depth[i] {continue}
+
+        // part of the face outside the screen
+        if clip {
+            p := r.unproject(x, y, d)
+            u, v := (n + 1) % 3, (n + 2) % 3
+            if p[u] < 0 || p[u] > screenSize || p[v] < 0 || p[v] > screenSize {continue}
+        }
+        r.depth[i] = d
+        r.img.SetRGBA(px, py, rgba)
+    }}
+}
+
+
+// index in faceDefs of the face whose normal follows an axis
+func faceOf (axis int, positive bool) int {
+    for f, def := range faceDefs {
+        dir := [3]int{def.ix, def.iy, def.iz}
+        if dir[axis] != 0 && (dir[axis] > 0) == positive {return f}
+    }
+    return 0
+}
+
+
+// helpers on vectors of floats
+func sub3   (a, b [3]float64) [3]float64 {return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}}
+func dot3   (a, b [3]float64) float64    {return a[0] * b[0] + a[1] * b[1] + a[2] * b[2]}
+func cross3 (a, b [3]float64) [3]float64 {
+    return [3]float64{a[1] * b[2] - a[2] * b[1], a[2] * b[0] - a[0] * b[2], a[0] * b[1] - a[1] * b[0]}
+}
+func normalize (a [3]float64) [3]float64 {
+    n := math.Sqrt(dot3(a, a))
+    if n == 0 {return a}
+    return [3]float64{a[0] / n, a[1] / n, a[2] / n}
+}
+
+
+// write an image in a PNG file
+func SavePNG (path string, img image.Image) error {
+    file, err := os.Create(path)
+    if err != nil {return err}
+    if err := png.Encode(file, img); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+
+// command line: vox-legacy render [-frames n] [-pc addr] [-size n] [-yaw d] [-pitch d] [-front] [-palette file] [-fit f] [-o out.png] image|game.vox
+func cmdRender (args []string) error {
+    flags  := flag.NewFlagSet("render", flag.ContinueOnError)
+    frames := flags.Int    ("frames", 1        , "number of frames to run before rendering")
+    entry  := flags.String ("pc"    , "$0000"  , "address of the first instruction of an image")
+    size   := flags.Int    ("size"  , 512      , "width and height of the image")
+    yaw    := flags.Float64("yaw"   , 30       , "rotation of the camera around the screen (degrees)")
+    pitch  := flags.Float64("pitch" , 25       , "elevation of the camera (degrees)")
+    front  := flags.Bool   ("front" , false    , "use an orthographic camera facing the screen")
+    output := flags.String ("o"     , "out.png", "image to write")
+    loadPalette := paletteFlags(flags)
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 || *size <= 0 {
+        return fmt.Errorf(
+            "usage: vox-legacy render [-frames n] [-pc addr] [-size n] [-yaw d] [-pitch d] [-front] [-palette file] [-fit f] [-o out.png] image|game.vox")
+    }
+    if err := loadPalette(); err != nil {return err}
+
+    con := NewConsole()
+    if err := con.LoadFile(flags.Arg(0), *entry); err != nil {return err}
+    for i := 0; i < *frames; i += 1 {
+        if err := con.Frame(); err != nil {return err}
+    }
+
+    camera := OrbitCamera(*yaw, *pitch)
+    if *front {camera = FrontCamera()}
+    r := NewSoftRenderer(*size, *size, camera)
+    con.ppu.Draw(r)
+    return SavePNG(*output, r.Image())
+}
@@ -3,13 +3,15 @@ package main
 /*
     Sprites composed of a tile and a palette
     Can be freely place in the world
-*/
 
-// use OpenGL 4.6
-import (
-    //"github.com/go-gl/gl/v4.6-core/gl"
-	//"github.com/go-gl/glfw/v3.3/glfw"
-)
+    attribute table (OAM), 8 bytes for each of the 64 sprites
+    $0 TILE   0 hides the sprite
+    $1 ATTR   palette (bits 0-1), priority (bit 2), mirroring (bits 3-5)
+    $2 X      $3 Y      $4 Z
+    $5 ROT    rotation byte
+    $6 $7     unused, read 0
+    a sprite with priority is drawn in front of the tile maps
+*/
 
 
 // Sprite to place on the screen
@@ -22,30 +24,44 @@ type Sprite struct {
     pos Vector3 // position of the mesh
     rot Byte3   // rotate the mesh
     mir Bool3   // flip the mesh
-
-    // OpenGL components to draw the associated mesh
-    vbo  uint32   // Vertex Buffer Object
-    pal *Palette  // pointer to the palette used
+    priority bool // in front of the tile maps
 }
 
 
-// draw the sprite in the scene with provided parameters
-func (sprite Sprite) Draw () {
-    sprite.pal.Use()
-
-    // TODO
-}
+const (
+    oamEntrySize = 8
+    oamSize      = nbSprites * oamEntrySize
+)
 
 
-// set a new tile to this sprite
-func (sprite Sprite) SetTile (id uint, tile *Tile) {
-    sprite.id_tile = id
-    sprite.vbo = tile.VBO
+// read a byte of the entry of the sprite in the attribute table
+func (sprite *Sprite) GetAttribute (index uint) uint8 {
+    switch index {
+    case 0: return uint8(sprite.id_tile)
+    case 1:
+        attr := uint8(sprite.id_pal & 0x3) | sprite.mir.GetByte() << 3
+        if sprite.priority {attr |= 0x4}
+        return attr
+    case 2: return uint8(sprite.pos.x)
+    case 3: return uint8(sprite.pos.y)
+    case 4: return uint8(sprite.pos.z)
+    case 5: return sprite.rot.GetByte()
+    }
+    return 0
 }
 
 
-// set a new palette to this sprite
-func (sprite Sprite) SetPalette (id uint, palette *Palette) {
-    sprite.id_pal = id
-    sprite.pal    = palette
+// write a byte of the entry of the sprite in the attribute table
+func (sprite *Sprite) SetAttribute (index uint, value uint8) {
+    switch index {
+    case 0: sprite.id_tile = uint(value)
+    case 1:
+        sprite.id_pal   = uint(value & 0x3)
+        sprite.priority = value & 0x4 != 0
+        sprite.mir.SetByte(value >> 3)
+    case 2: sprite.pos.x = uint(value)
+    case 3: sprite.pos.y = uint(value)
+    case 4: sprite.pos.z = uint(value)
+    case 5: sprite.rot.SetByte(value)
+    }
 }
@@ -0,0 +1,92 @@
+package main
+
+/*
+    Sprite limit, an optional mode where only a few sprites share the
+    same slab of the screen like the scanlines of older consoles
+
+    the screen is cut in 16 slabs of 8 voxels along an axis, a sprite
+    covers the one or two slabs its 8 voxels cross, sprites outside the
+    screen along the axis cover none
+
+    sprites are taken in order from SFIRST, wrapping after 63, a sprite
+    crossing a slab already full is dropped entirely and sets the
+    overflow flag, moving SFIRST to the first dropped sprite every frame
+    makes the dropped sprites flicker instead of disappearing
+*/
+
+
+const nbSlabs = screenSize / 8
+
+
+// sprites are limited in every slab
+func (ppu *PPU) limited () bool {
+    return ppu.regs[regControl] & 0x2 != 0
+}
+
+
+// number of sprites allowed in a slab
+func (ppu *PPU) slabLimit () int {
+    if n := ppu.regs[regSLimit]; n != 0 {return int(n)}
+    return 8
+}
+
+
+// coordinate of a sprite along the axis of the slabs
+func (ppu *PPU) slabCoord (sprite *Sprite) uint {
+    switch ppu.regs[regControl] >> 4 & 0x3 {
+    case 1: return sprite.pos.x & 0xFF
+    case 2: return sprite.pos.z & 0xFF
+    }
+    return sprite.pos.y & 0xFF
+}
+
+
+// active sprites in the order of priority, those dropped by the limit
+// are left out and reported with the second value
+func (ppu *PPU) VisibleSprites () ([]int, []int) {
+    var visible, dropped []int
+    if !ppu.limited() {
+        for i := range ppu.sprites {
+            if ppu.sprites[i].id_tile != 0 {visible = append(visible, i)}
+        }
+        return visible, nil
+    }
+
+    var count [nbSlabs]int
+    limit := ppu.slabLimit()
+    first := int(ppu.regs[regSFirst] % nbSprites)
+    for k := 0; k < nbSprites; k += 1 {
+        i      := (first + k) % nbSprites
+        sprite := &ppu.sprites[i]
+        if sprite.id_tile == 0 {continue}
+
+        // slabs of the first and last voxels of the sprite
+        p := ppu.slabCoord(sprite)
+        var slabs []uint
+        for _, s := range []uint{p >> 3, (p + 7) & 0xFF >> 3} {
+            if s < nbSlabs && (len(slabs) == 0 || slabs[0] != s) {slabs = append(slabs, s)}
+        }
+
+        full := false
+        for _, s := range slabs {
+            if count[s] >= limit {full = true}
+        }
+        if full {
+            dropped = append(dropped, i)
+            continue
+        }
+        for _, s := range slabs {count[s] += 1}
+        visible = append(visible, i)
+    }
+    return visible, dropped
+}
+
+
+// find the sprites dropped for the frame and rotate the priority
+func (ppu *PPU) UpdateSpriteLimit () {
+    _, dropped := ppu.VisibleSprites()
+    ppu.overflow = len(dropped) != 0
+    if ppu.overflow && ppu.regs[regControl] & 0x4 != 0 {
+        ppu.regs[regSFirst] = uint8(dropped[0])
+    }
+}
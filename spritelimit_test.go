@@ -0,0 +1,109 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+
+// PPU whose sprites 0 to len(positions) - 1 show tile 1 at the positions
+func limitPPU (control, limit, first uint8, positions ...Vector3) *PPU {
+    ppu := &PPU{}
+    ppu.Write(regControl, control)
+    ppu.Write(regSLimit , limit)
+    ppu.Write(regSFirst , first)
+    for i, pos := range positions {
+        ppu.sprites[i].id_tile = 1
+        ppu.sprites[i].pos     = pos
+    }
+    return ppu
+}
+
+
+// sprites at the same y
+func row (n int, y uint) []Vector3 {
+    positions := make([]Vector3, n)
+    for i := range positions {positions[i] = Vector3{uint(i) * 9, y, 0}}
+    return positions
+}
+
+
+// the sprites crossing a full slab are dropped, taken in order from SFIRST
+func TestVisibleSprites (t *testing.T) {
+    ys := func (y ...uint) []Vector3 {
+        positions := make([]Vector3, len(y))
+        for i := range y {positions[i] = Vector3{10, y[i], 10}}
+        return positions
+    }
+    cases := []struct {
+        name      string
+        control   uint8
+        limit     uint8
+        first     uint8
+        positions []Vector3
+        visible   []int
+        dropped   []int
+    }{
+        {"unlimited"        , 0x00, 1, 0, row(10, 0), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, nil},
+        {"8 by default"     , 0x02, 0, 0, row(10, 0), []int{0, 1, 2, 3, 4, 5, 6, 7}, []int{8, 9}},
+        {"limit of 2"       , 0x02, 2, 0, row(4, 8), []int{0, 1}, []int{2, 3}},
+        {"a slab each"      , 0x02, 1, 0, ys(0, 8, 16, 120), []int{0, 1, 2, 3}, nil},
+        {"two slabs"        , 0x02, 1, 0, ys(4, 8, 0), []int{0}, []int{1, 2}},
+        {"dropped entirely" , 0x02, 1, 0, ys(8, 4, 0), []int{0, 2}, []int{1}},
+        {"wrap at 250"      , 0x02, 1, 0, ys(250, 0, 120, 248), []int{0, 2, 3}, []int{1}},
+        {"outside"          , 0x02, 1, 0, ys(200, 200, 128, 0), []int{0, 1, 2, 3}, nil},
+        {"first"            , 0x02, 1, 2, ys(0, 0, 0, 0), []int{2}, []int{3, 0, 1}},
+        {"first wraps"      , 0x02, 1, 66, ys(0, 0, 0, 0), []int{2}, []int{3, 0, 1}},
+        {"slabs along x"    , 0x12, 1, 0, []Vector3{{0, 0, 0}, {8, 0, 0}, {4, 50, 50}}, []int{0, 1}, []int{2}},
+        {"slabs along z"    , 0x22, 1, 0, []Vector3{{0, 0, 0}, {0, 0, 4}, {0, 0, 8}}, []int{0, 2}, []int{1}},
+    }
+    for _, c := range cases {
+        ppu := limitPPU(c.control, c.limit, c.first, c.positions...)
+        visible, dropped := ppu.VisibleSprites()
+        if !reflect.DeepEqual(visible, c.visible) || !reflect.DeepEqual(dropped, c.dropped) {
+            t.Errorf("%s: %v visible %v dropped instead of %v and %v", c.name, visible, dropped, c.visible, c.dropped)
+        }
+    }
+
+    // hidden sprites take no place
+    ppu := limitPPU(0x02, 1, 0, ys(0, 0)...)
+    ppu.sprites[0].id_tile = 0
+    if visible, dropped := ppu.VisibleSprites(); !reflect.DeepEqual(visible, []int{1}) || dropped != nil {
+        t.Errorf("hidden: %v visible %v dropped", visible, dropped)
+    }
+}
+
+
+// moving SFIRST to the first dropped sprite shows every sprite in turn,
+// the overflow flag follows the dropped sprites of the frame
+func TestSpriteLimitRotation (t *testing.T) {
+    ppu := limitPPU(0x06, 2, 0, row(5, 0)...)
+    frames := []struct {
+        visible []int
+        first   uint8 // SFIRST for the next frame
+    }{
+        {[]int{0, 1}, 2},
+        {[]int{2, 3}, 4},
+        {[]int{4, 0}, 1},
+        {[]int{1, 2}, 3},
+    }
+    for i, f := range frames {
+        visible, _ := ppu.VisibleSprites()
+        ppu.UpdateSpriteLimit()
+        if !reflect.DeepEqual(visible, f.visible) || ppu.regs[regSFirst] != f.first {
+            t.Errorf("frame %d: %v visible SFIRST %d instead of %v and %d", i, visible, ppu.regs[regSFirst], f.visible, f.first)
+        }
+        if status := ppu.Read(regStatus); !ppu.overflow || status & 0x20 == 0 {t.Errorf("frame %d: STATUS $%02X", i, status)}
+    }
+
+    // SFIRST stays without bit 2 of CONTROL
+    ppu.Write(regControl, 0x02)
+    ppu.Write(regSFirst, 0)
+    ppu.UpdateSpriteLimit()
+    if first := ppu.regs[regSFirst]; first != 0 || !ppu.overflow {t.Errorf("SFIRST moved to %d", first)}
+
+    // no sprite dropped clears the overflow
+    ppu.Write(regSLimit, 5)
+    ppu.UpdateSpriteLimit()
+    if status := ppu.Read(regStatus); ppu.overflow || status & 0x20 != 0 {t.Errorf("STATUS $%02X without overflow", status)}
+}
@@ -1,37 +1,78 @@
 package main
 
 
+import (
+    "errors"
+)
+
+
+// pushing on a full stack or pulling from an empty one is a fault,
+// the pointer stays in bound and the first fault is kept until reset
+var (
+    errStackOverflow  = errors.New("stack overflow")
+    errStackUnderflow = errors.New("stack underflow")
+)
+
+
 type Stack struct {
     data [0x100]uint8
     ptr  int
+    err  error // first fault
 }
 
 
-func (s Stack) Push (value uint8) {
+func (s *Stack) Push (value uint8) {
+    if !s.InBound(1) {
+        s.fault(errStackOverflow)
+        return
+    }
     s.data[s.ptr] = value
     s.ptr += 1
 }
 
-func (s Stack) Pull () uint8 {
+func (s *Stack) Pull () uint8 {
+    if s.ptr <= 0 {
+        s.fault(errStackUnderflow)
+        return 0
+    }
     s.ptr -= 1
     val := s.data[s.ptr]
     return val
 }
 
-func (s Stack) PushAddress (addr uint) {
-    s.data[s.ptr    ] = uint8(addr >> 8)
-    s.data[s.ptr + 1] = uint8(addr)
-    s.ptr += 2
+func (s *Stack) PushAddress (addr uint) {
+    if !s.InBound(2) {
+        s.fault(errStackOverflow)
+        return
+    }
+    s.Push(uint8(addr >> 8))
+    s.Push(uint8(addr))
 }
 
-func (s Stack) PullAddress () uint {
-    s.ptr -= 2
-    high := uint(s.data[s.ptr    ])
-    low  := uint(s.data[s.ptr + 1])
+func (s *Stack) PullAddress () uint {
+    if s.ptr < 2 {
+        s.fault(errStackUnderflow)
+        return 0
+    }
+    low  := uint(s.Pull())
+    high := uint(s.Pull())
     return (high << 8) | low
 }
 
 
-func (s Stack) InBound () bool {
-    return 0 <= s.ptr && s.ptr < 0x100
+// specify if count more bytes can be pushed
+func (s *Stack) InBound (count int) bool {
+    return 0 <= s.ptr && s.ptr + count <= len(s.data)
+}
+
+
+// keep the first fault
+func (s *Stack) fault (err error) {
+    if s.err == nil {s.err = err}
+}
+
+
+// first fault since the stack was created
+func (s *Stack) Err () error {
+    return s.err
 }
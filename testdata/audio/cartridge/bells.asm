@@ -0,0 +1,25 @@
+; cartridge of the audio checks, in ROM and with the 5 steps sequence,
+; packed next to the other programs with
+;   vox-legacy pack -title bells -o testdata/audio/bells.vox testdata/audio/cartridge/bells.asm
+
+        .org $4000
+        LOD A, #$05
+        STR A, $3110        ; enable pulse 1 and the triangle
+        LOD A, #$80
+        STR A, $3111        ; 5 steps sequence
+
+        LOD A, #$64         ; pulse 1: quarter duty, looping envelope of period 4
+        STR A, $3100
+        LOD A, #$52
+        STR A, $3102
+        LOD A, #$09         ; period $152
+        STR A, $3103
+
+        LOD A, #$40         ; triangle: cut after 64 quarter frames
+        STR A, $3108
+        LOD A, #$A9
+        STR A, $310A
+        LOD A, #$08
+        STR A, $310B
+
+loop:   JMP loop
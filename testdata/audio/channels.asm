@@ -0,0 +1,36 @@
+; every channel playing at once, the sweep and the noise change over time
+
+        LOD A, #$0F
+        STR A, $3110        ; enable the 4 channels
+
+        LOD A, #$BF         ; pulse 1: half duty, halted, constant 15
+        STR A, $3100
+        LOD A, #$FD         ; period $0FD, about 440 Hz
+        STR A, $3102
+        LOD A, #$08
+        STR A, $3103
+
+        LOD A, #$7A         ; pulse 2: quarter duty, halted, constant 10
+        STR A, $3104
+        LOD A, #$A2         ; sweep down: period 2, shift 2
+        STR A, $3105
+        LOD A, #$80
+        STR A, $3106
+        LOD A, #$0B
+        STR A, $3107
+
+        LOD A, #$FF         ; triangle: linear counter held
+        STR A, $3108
+        LOD A, #$7E
+        STR A, $310A
+        LOD A, #$08
+        STR A, $310B
+
+        LOD A, #$03         ; noise: decaying envelope, short sequence
+        STR A, $310C
+        LOD A, #$85
+        STR A, $310E
+        LOD A, #$08
+        STR A, $310F
+
+loop:   JMP loop
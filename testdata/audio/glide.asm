@@ -0,0 +1,21 @@
+; pulse 1 gliding up, its period changes at every vertical blank
+
+        LOD A, #$01
+        STR A, $3110        ; enable pulse 1
+        LOD A, #$3C         ; eighth duty, halted, constant 12
+        STR A, $3100
+        LOD A, #$00
+        STR A, $3102
+        LOD A, #$09         ; period $100
+        STR A, $3103
+loop:   JMP loop
+
+nmi:    DEC count
+        LOD A, count
+        STR A, $3102        ; the period gets shorter at every frame
+        RTI
+
+count:  .byte 0
+
+        .org $FFFA
+        .word nmi
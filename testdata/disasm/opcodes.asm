@@ -0,0 +1,193 @@
+; every opcode of the processor in each of its addressing modes,
+; decoded by the disassembler and assembled back into the same bytes
+
+        .org $0100
+
+        ; no operand
+        NOP
+        RTN
+        RTI
+        DSI
+        ENI
+
+        ; store and load with a register
+        STR A, $1234
+        STR X, $1235
+        STR Y, $1236
+        STR Z, $1237
+        LOD A, $1234
+        LOD X, $1235
+        LOD Y, $1236
+        LOD Z, $1237
+
+        ; store and load the accumulator, indexed or not
+        STR $2000
+        STR $2000, X
+        STR $2000, Y
+        STR $2000, Z
+        LOD $2000
+        LOD $2000, X
+        LOD $2000, Y
+        LOD $2000, Z
+
+        ; load a number
+        LOD A, #$7F
+        LOD X, #$9F
+        LOD Y, #$BF
+        LOD Z, #$DF
+
+        ; stack
+        PSH A
+        PSH X
+        PSH Y
+        PSH Z
+        PLL A
+        PLL X
+        PLL Y
+        PLL Z
+
+        ; jumps
+        JMP $4000
+        CALL $FFF0
+
+        ; transfers, destination then source
+        TRS A, A
+        TRS A, X
+        TRS A, Y
+        TRS A, Z
+        TRS X, A
+        TRS X, X
+        TRS X, Y
+        TRS X, Z
+        TRS Y, A
+        TRS Y, X
+        TRS Y, Y
+        TRS Y, Z
+        TRS Z, A
+        TRS Z, X
+        TRS Z, Y
+        TRS Z, Z
+
+        ; unary and binary operations on a register, an address or an indexed address
+        INC A
+        INC X
+        INC Y
+        INC Z
+        INC $3000
+        INC $3000, X
+        INC $3000, Y
+        INC $3000, Z
+        DEC A
+        DEC X
+        DEC Y
+        DEC Z
+        DEC $3000
+        DEC $3000, X
+        DEC $3000, Y
+        DEC $3000, Z
+        SHL A
+        SHL X
+        SHL Y
+        SHL Z
+        SHL $3000
+        SHL $3000, X
+        SHL $3000, Y
+        SHL $3000, Z
+        SHR A
+        SHR X
+        SHR Y
+        SHR Z
+        SHR $3000
+        SHR $3000, X
+        SHR $3000, Y
+        SHR $3000, Z
+        ROL A
+        ROL X
+        ROL Y
+        ROL Z
+        ROL $3000
+        ROL $3000, X
+        ROL $3000, Y
+        ROL $3000, Z
+        ROR A
+        ROR X
+        ROR Y
+        ROR Z
+        ROR $3000
+        ROR $3000, X
+        ROR $3000, Y
+        ROR $3000, Z
+        NOT A
+        NOT X
+        NOT Y
+        NOT Z
+        NOT $3000
+        NOT $3000, X
+        NOT $3000, Y
+        NOT $3000, Z
+        ADD A
+        ADD X
+        ADD Y
+        ADD Z
+        ADD $3000
+        ADD $3000, X
+        ADD $3000, Y
+        ADD $3000, Z
+        SUB A
+        SUB X
+        SUB Y
+        SUB Z
+        SUB $3000
+        SUB $3000, X
+        SUB $3000, Y
+        SUB $3000, Z
+        AND A
+        AND X
+        AND Y
+        AND Z
+        AND $3000
+        AND $3000, X
+        AND $3000, Y
+        AND $3000, Z
+        IOR A
+        IOR X
+        IOR Y
+        IOR Z
+        IOR $3000
+        IOR $3000, X
+        IOR $3000, Y
+        IOR $3000, Z
+        XOR A
+        XOR X
+        XOR Y
+        XOR Z
+        XOR $3000
+        XOR $3000, X
+        XOR $3000, Y
+        XOR $3000, Z
+        CMP A
+        CMP X
+        CMP Y
+        CMP Z
+        CMP $3000
+        CMP $3000, X
+        CMP $3000, Y
+        CMP $3000, Z
+
+        ; branches and flags
+        BRC Z, $0100
+        BRC N, $0100
+        BRC C, $0100
+        BRC V, $0100
+        BRN Z, $0100
+        BRN N, $0100
+        BRN C, $0100
+        BRN V, $0100
+        SET Z
+        SET N
+        SET C
+        SET V
+        CLR Z
+        CLR N
+        CLR C
+        CLR V
@@ -5,12 +5,10 @@ package main
     https://youtu.be/x0H--CL2tUI
 */
 
-// use OpenGL 4.6
 import (
     "fmt"
+    "strings"
     "encoding/hex"
-    "github.com/go-gl/gl/v4.6-core/gl"
-	//"github.com/go-gl/glfw/v3.3/glfw"
 )
 
 
@@ -28,12 +26,11 @@ const (
 // 3D tile made of 4 colors
 type Tile struct {
     rows [nbRows]uint16
-    VBO  uint32
 }
 
 
 // load voxels from a HEX string
-func (tile Tile) LoadHEX (data string) error {
+func (tile *Tile) LoadHEX (data string) error {
     if len(data) != nbRows * 4 { // 4 HEX characters per row of the tile
         return fmt.Errorf(
             "Cannot construct tile: expecting %d given %d", nbRows * 4, len(data))
@@ -52,30 +49,27 @@ func (tile Tile) LoadHEX (data string) error {
 }
 
 
-// build mesh from pixel data
-func (tile Tile) MakeMesh () uint32 {
-    // delete the previously assigned buffers
-    gl.DeleteBuffers(2, &tile.VBO)
-
-    // struct helper
-    type faceDef struct {
-        ix, iy, iz int
-        face []uint
+// save voxels in a HEX string, read back by LoadHEX
+func (tile *Tile) HEX () string {
+    array := make([]uint8, nbRows * 2)
+    for i, row := range tile.rows {
+        array[i * 2    ] = uint8(row >> 8)
+        array[i * 2 + 1] = uint8(row)
     }
+    return strings.ToUpper(hex.EncodeToString(array))
+}
 
-    // constant helpers
-    var defs = [...]faceDef {
-        { 0, 0,-1, faceFront [:]},
-        { 0,-1, 0, faceTop   [:]},
-        {-1, 0, 0, faceLeft  [:]},
-        { 0, 0, 1, faceBack  [:]},
-        { 0, 1, 0, faceBottom[:]},
-        { 1, 0, 0, faceRight [:]}}
-
-    const (
-        nbComp    = nbVerts4Face * nbCoords4Vert
-        arraySize = nbVoxs * nbFaces4Vox * nbVerts4Face * nbCoords4Vert
-    )
+
+// build the vertices of the mesh (x, y, z, color index)
+func (tile *Tile) BuildMesh (mode MeshMode) []uint8 {
+    if mode == MeshGreedy {return tile.greedyMesh()}
+    return tile.faceMesh()
+}
+
+
+// two triangles for every face of a voxel next to an empty voxel
+func (tile *Tile) faceMesh () []uint8 {
+    const arraySize = nbVoxs * nbFaces4Vox * nbVerts4Face * nbCoords4Vert
 
     // buffers to fill in
     var (
@@ -93,45 +87,32 @@ func (tile Tile) MakeMesh () uint32 {
         if color != 0 {
 
             // for each face of the pixel
-            for _, def := range defs {
+            for _, def := range faceDefs {
 
                 // if the neighboring pixel is empty, create a new face
                 if tile.GetVoxel(x + def.ix, y + def.iy, z + def.iz) == 0 {
 
                     // copy vertices with an offset
                     s := countVerts * nbCoords4Vert
-                    for i := 0; i < nbComp; i += nbCoords4Vert {
-                        vertices[s + i    ] = uint8(def.face[i    ] + uint(x))
-                        vertices[s + i + 1] = uint8(def.face[i + 1] + uint(y))
-                        vertices[s + i + 2] = uint8(def.face[i + 2] + uint(z))
+                    for v := 0; v < nbVerts4Face; v += 1 {
+                        i := v * nbCoords4Vert
+                        vertices[s + i    ] = uint8(def.face[v * 3    ] + uint(x))
+                        vertices[s + i + 1] = uint8(def.face[v * 3 + 1] + uint(y))
+                        vertices[s + i + 2] = uint8(def.face[v * 3 + 2] + uint(z))
                         vertices[s + i + 3] = uint8(color)
-                        countVerts += 1
                     }
+                    countVerts += nbVerts4Face
                 }
             }
         }
     }}}
 
-    const sizeOfVert = nbCoords4Vert * sizeOfCoord
-    bufferVerts := vertices[:(countVerts * nbCoords4Vert)]
-
-    // once the arrays have been filled we can make VBOs and a VAO
-    gl.GenBuffers(1, &tile.VBO)
-
-    gl.BindBuffer(gl.ARRAY_BUFFER, tile.VBO)
-    gl.BufferData(gl.ARRAY_BUFFER, countVerts * sizeOfVert, gl.Ptr(bufferVerts), gl.STATIC_DRAW)
-    gl.EnableVertexAttribArray(0)
-    gl.VertexAttribPointer(0, 3, gl.UNSIGNED_BYTE, false, sizeOfVert, nil)
-    gl.VertexAttribPointer(1, 1, gl.UNSIGNED_BYTE, false, sizeOfVert, nil)
-
-    gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
-    return tile.VBO
+    return append([]uint8(nil), vertices[:(countVerts * nbCoords4Vert)]...)
 }
 
 
 // get the pixel at specified location
-func (tile Tile) GetVoxel (x, y, z int) uint {
+func (tile *Tile) GetVoxel (x, y, z int) uint {
     // if the pixel is out of bounds, return 0
     if x < 0 || 8 <= x || y < 0 || 8 <= y || z < 0 || 8 <= z {return uint(0)}
     row := tile.rows[y + z * 8] // planes along z
@@ -140,6 +121,15 @@ func (tile Tile) GetVoxel (x, y, z int) uint {
 }
 
 
+// set the pixel at specified location, out of bounds pixels are ignored
+func (tile *Tile) SetVoxel (x, y, z int, color uint) {
+    if x < 0 || 8 <= x || y < 0 || 8 <= y || z < 0 || 8 <= z {return}
+    shf := uint(14 - x * 2)
+    row := &tile.rows[y + z * 8]
+    *row = *row &^ (0b11 << shf) | uint16(color & 0b11) << shf
+}
+
+
 // declare arrays for placing faces
 var (
     //                     /* triangle 1    */     /* triangle 2    */
@@ -150,3 +140,18 @@ var (
     faceBottom = [...]uint {1,1,1, 1,1,0, 0,1,1,    0,1,0, 0,1,1, 1,1,0}
     faceRight  = [...]uint {1,1,1, 1,0,1, 1,1,0,    1,0,0, 1,1,0, 1,0,1}
 )
+
+
+// face of a voxel and the direction of the neighbor hiding it
+type faceDef struct {
+    ix, iy, iz int
+    face []uint
+}
+
+var faceDefs = [nbFaces4Vox]faceDef {
+    { 0, 0,-1, faceFront [:]},
+    { 0,-1, 0, faceTop   [:]},
+    {-1, 0, 0, faceLeft  [:]},
+    { 0, 0, 1, faceBack  [:]},
+    { 0, 1, 0, faceBottom[:]},
+    { 1, 0, 0, faceRight [:]}}
@@ -2,8 +2,10 @@ package main
 
 
 const (
-    mapSize = 16
-    nbTiles = mapSize * mapSize * mapSize
+    mapSize   = 16
+    nbTiles   = mapSize * mapSize * mapSize
+    chunkSize = 4 // cells along each axis of a chunk
+    nbChunks  = nbTiles / (chunkSize * chunkSize * chunkSize)
 )
 
 
@@ -12,64 +14,130 @@ type TileMap struct {
     rots [nbTiles]uint8 // [6bits] Is the tile rotated
     mirs [nbTiles]uint8 // [3bits] Is the tile mirrored
     pals [nbTiles]uint8 // [2bits] Palette to use
+
+    // meshes of the chunks, rebuilt when their cells change
+    chunks [nbChunks]Mesh
+    valid  [nbChunks]bool
+    bank   *TileBank // tiles used to build the meshes
+    mode   MeshMode
 }
 
 
 // Set the tile in the tile map from 3 bytes
-func (tm TileMap) Set (index uint, til, rot, mir, pal uint8) {
+func (tm *TileMap) Set (index uint, til, rot, mir, pal uint8) {
     tm.tils[index] = til
     tm.rots[index] = rot
     tm.mirs[index] = mir
     tm.pals[index] = pal
+    tm.valid[chunkOf(index)] = false
 }
 
 
-// Get the tile from the tile map
-func (tm TileMap) Get (index uint) (uint8, uint8, uint8, uint8) {
-    return tm.tils[index], tm.rots[index], tm.mirs[index], tm.pals[index]
+// rebuild the meshes of every chunk, after the cells are modified directly
+func (tm *TileMap) Invalidate () {
+    tm.valid = [nbChunks]bool{}
 }
 
 
-// render the tile maps in OpenGL (having 4096 draw calls per frame is acceptable)
-func DrawTileMaps (map1, map2 *TileMap, scroll Vector3) {
-    var brush Sprite // use a sprite as a brush
+// chunk containing a cell
+func chunkOf (index uint) uint {
+    x, y, z := index & 0xF, (index >> 4) & 0xF, index >> 8
+    const n = mapSize / chunkSize
+    return (z / chunkSize * n + y / chunkSize) * n + x / chunkSize
+}
 
-    // draw tiles from the tile map based on the scrolling
-    var index Vector3
-    for ix := uint(0); ix < mapSize; ix += 1 {
-    for iy := uint(0); iy < mapSize; iy += 1 {
-    for iz := uint(0); iz < mapSize; iz += 1 {
 
-        // find the tile data to display
-        index.Set(ix, iy, iz)
-        i, m := findTileWithScroll(index, scroll)
-        map0 := map1; if m {map0 = map2}
-        til, rot, mir, pal := map0.Get(i)
+// rebuild the meshes of the chunks that changed and return their number
+//( every chunk is rebuilt when the tiles or the mesher change )
+func (tm *TileMap) Update (tiles *TileBank, mode MeshMode) int {
+    if tm.bank != tiles || tm.mode != mode {
+        tm.Invalidate()
+        tm.bank, tm.mode = tiles, mode
+    }
+    count := 0
+    for c := uint(0); c < nbChunks; c += 1 {
+        if tm.valid[c] {continue}
+        tm.chunks[c].SetVertices(tm.buildChunk(c))
+        tm.valid[c] = true
+        count += 1
+    }
+    return count
+}
 
-        // if tile 0 there is nothing to do
-        if til != 0 {
-            brush.SetTile   (uint(til), nil) // TODO get tile from a list
-            brush.SetPalette(uint(pal), nil) // TODO get palette from a list
-            brush.rot.SetByte(rot)
-            brush.mir.SetByte(mir)
-            brush.pos = index.ShiftL(3).Add(scroll.Mask(0x7))
 
-            brush.Draw()
+// bake the tiles of a chunk with their orientation and palette
+//( vertices are placed relative to the map, colors are palette << 2 | color )
+func (tm *TileMap) buildChunk (chunk uint) []uint8 {
+    const n = mapSize / chunkSize
+    cx, cy, cz := chunk % n * chunkSize, chunk / n % n * chunkSize, chunk / (n * n) * chunkSize
+
+    var vertices []uint8
+    for z := cz; z < cz + chunkSize; z += 1 {
+    for y := cy; y < cy + chunkSize; y += 1 {
+    for x := cx; x < cx + chunkSize; x += 1 {
+        til, rot, mir, pal := tm.Get(z << 8 | y << 4 | x)
+        if til == 0 {continue}
+
+        var r3 Byte3; r3.SetByte(rot)
+        var m3 Bool3; m3.SetByte(mir)
+        tile := tm.bank[til].Orient(r3, m3)
+        mesh := tile.BuildMesh(tm.mode)
+        for i := 0; i < len(mesh); i += nbCoords4Vert {
+            vertices = append(vertices,
+                mesh[i    ] + uint8(x * 8),
+                mesh[i + 1] + uint8(y * 8),
+                mesh[i + 2] + uint8(z * 8),
+                (pal & 0x3) << 2 | mesh[i + 3])
         }
     }}}
+    return vertices
+}
+
+
+// meshes of the chunks, call Update before
+func (tm *TileMap) Chunks () []Mesh {
+    return tm.chunks[:]
 }
 
 
-func findTileWithScroll (index, scroll Vector3) (uint, bool) {
-    pos := index.Sub(scroll.ShiftR(3)).Mod(mapSize * 2)
+// Get the tile from the tile map
+func (tm *TileMap) Get (index uint) (uint8, uint8, uint8, uint8) {
+    return tm.tils[index], tm.rots[index], tm.mirs[index], tm.pals[index]
+}
+
+
+// render the tile maps with a draw call per chunk, using the palettes 0-3
+//( the maps are repeated as a checkerboard and moved by the scrolling )
+func DrawMapChunks (r Renderer, map1, map2 *TileMap, tiles *TileBank, palettes []Palette, scroll Vector3, mode MeshMode) {
+    map1.Update(tiles, mode)
+    map2.Update(tiles, mode)
+
+    const n = mapSize / chunkSize
+    for o := uint(0); o < 8; o += 1 {
+        // place of the map in the checkerboard
+        var offset Vector3
+        offset.Set(o & 0x1, (o >> 1) & 0x1, (o >> 2) & 0x1)
+        tm := map1
+        if (offset.x + offset.y + offset.z) % 2 != 0 {tm = map2}
+        origin := offset.ShiftL(7).Add(scroll)
+
+        for c := uint(0); c < nbChunks; c += 1 {
+            mesh := &tm.chunks[c]
+            if len(mesh.Vertices) == 0 {continue}
+
+            // skip the chunks outside the screen
+            var cell Vector3
+            cell.Set(c % n, c / n % n, c / (n * n))
+            pos := cell.ShiftL(5).Add(origin).Mask(0xFF)
+            if !onScreen(pos.x) || !onScreen(pos.y) || !onScreen(pos.z) {continue}
+            r.DrawMesh(mesh, palettes, origin.Mask(0xFF))
+        }
+    }
+}
 
-    // identify the map to use based on crossboard pattern
-    msk := 0
-    if pos.x >= mapSize {msk += 1}
-    if pos.y >= mapSize {msk += 1}
-    if pos.z >= mapSize {msk += 1}
 
-    // find index in the map
-    x, y, z := pos.Mod(mapSize).Get()
-    return z << 8 | y << 4 | x, (msk & 0x1) != 0
+// a chunk placed at a coordinate is partially visible
+//( coordinates wrap around 256 )
+func onScreen (p uint) bool {
+    return p < screenSize || p + chunkSize * 8 > 0x100
 }
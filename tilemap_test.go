@@ -0,0 +1,85 @@
+package main
+
+import (
+    "testing"
+)
+
+
+// only the chunks of the cells modified are built again
+func TestTileMapDirtyChunks (t *testing.T) {
+    ppu := goldenPPU()
+    sceneFine(ppu)
+    tm    := &ppu.maps[0]
+    tiles := ppu.Tiles()
+
+    steps := []struct {
+        name   string
+        change func ()
+        built  int
+    }{
+        {"first update", func () {}, nbChunks},
+        {"unchanged"   , func () {}, 0},
+        {"one cell"    , func () {tm.Set(0x123, 1, 0, 0, 0)}, 1},
+        {"same chunk"  , func () {tm.Set(0x000, 2, 0, 0, 0); tm.Set(0x333, 2, 0, 0, 0)}, 1},
+        {"two chunks"  , func () {tm.Set(0x003, 2, 0, 0, 0); tm.Set(0x004, 2, 0, 0, 0)}, 2},
+        {"corners"     , func () {tm.Set(0x000, 0, 0, 0, 0); tm.Set(0xFFF, 3, 0, 0, 0)}, 2},
+        {"invalidate"  , tm.Invalidate, nbChunks},
+    }
+    for _, step := range steps {
+        step.change()
+        if n := tm.Update(tiles, MeshGreedy); n != step.built {
+            t.Errorf("%s: %d chunks built instead of %d", step.name, n, step.built)
+        }
+    }
+
+    // every chunk is built again for other tiles or another mesher
+    if n := tm.Update(tiles, MeshFaces); n != nbChunks {
+        t.Errorf("other mesher: %d chunks built instead of %d", n, nbChunks)
+    }
+    if n := tm.Update(&TileBank{}, MeshFaces); n != nbChunks {
+        t.Errorf("other tiles: %d chunks built instead of %d", n, nbChunks)
+    }
+}
+
+
+// draw the maps of the fine scroll scene, the meshes are already built
+func BenchmarkDrawMapChunks (b *testing.B) {
+    ppu := goldenPPU()
+    sceneFine(ppu)
+    r := NewSoftRenderer(screenSize, screenSize, FrontCamera())
+    b.ResetTimer()
+    for n := 0; n < b.N; n += 1 {
+        r.Begin()
+        DrawMapChunks(r, &ppu.maps[0], &ppu.maps[1], ppu.Tiles(), ppu.palettes[:4], ppu.scroll, MeshGreedy)
+        r.End()
+    }
+}
+
+
+// modify a cell and build its chunk again
+func BenchmarkRebuildDirtyChunk (b *testing.B) {
+    ppu := goldenPPU()
+    sceneFine(ppu)
+    tm := &ppu.maps[0]
+    tm.Update(ppu.Tiles(), MeshGreedy)
+    b.ResetTimer()
+    for n := 0; n < b.N; n += 1 {
+        cell := uint(n * 37) % nbTiles
+        til, rot, mir, pal := tm.Get(cell)
+        tm.Set(cell, til, rot, mir, pal)
+        tm.Update(ppu.Tiles(), MeshGreedy)
+    }
+}
+
+
+// build every chunk of a map, what a single draw of the map used to cost
+func BenchmarkRebuildMap (b *testing.B) {
+    ppu := goldenPPU()
+    sceneFine(ppu)
+    tm := &ppu.maps[0]
+    b.ResetTimer()
+    for n := 0; n < b.N; n += 1 {
+        tm.Invalidate()
+        tm.Update(ppu.Tiles(), MeshGreedy)
+    }
+}
@@ -0,0 +1,338 @@
+package main
+
+/*
+    Execution traces, every instruction executed by the Processor
+    is recorded in a compact binary file s.t. two runs can be compared
+
+    header  "VOXT", version
+    record  u8  bit 0 set if an interrupt was serviced before
+            u16 pointer, u8 number of bytes, opcode and operands
+            u8  A X Y Z, u8 flags, u16 stack pointer, u16 cycles spent
+            u16 number of writes, (u16 address, u8 value) for each
+
+    the copies of OAMDMA are recorded as writes of the instruction
+    starting them, in the attribute table at $2100
+*/
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "bufio"
+    "strings"
+)
+
+
+const (
+    traceMagic   = "VOXT"
+    traceVersion = 2
+)
+
+
+// a byte written by an instruction
+type TraceWrite struct {
+    Addr  uint
+    Value uint8
+}
+
+
+// state of the processor after an instruction
+type TraceRecord struct {
+    Interrupt bool     // an interrupt was serviced before the instruction
+    PC        uint     // address of the instruction
+    Bytes     []uint8  // opcode and operands
+    Reg       [4]uint8 // A, X, Y, Z
+    Flags     uint8    // as pushed on the stack by interrupts
+    SP        uint     // stack pointer
+    Cycles    uint16   // cycles spent, including the interrupt and a copy of OAMDMA
+    Writes    []TraceWrite
+}
+
+
+// write instructions executed by a processor
+type Tracer struct {
+    out   *bufio.Writer
+    rec    TraceRecord
+    start  uint64
+    count  uint64
+    err    error
+}
+
+
+// start writing a trace, attach it to a processor with Attach
+func NewTracer (out io.Writer) *Tracer {
+    t := &Tracer{out: bufio.NewWriter(out)}
+    t.out.WriteString(traceMagic)
+    t.out.WriteByte(traceVersion)
+    return t
+}
+
+
+// record every instruction executed by the processor
+func (t *Tracer) Attach (cpu *Processor) {
+    cpu.tracer = t
+}
+
+
+// number of instructions recorded
+func (t *Tracer) Count () uint64 {
+    return t.count
+}
+
+
+// write the buffered records and return the first error met
+func (t *Tracer) Flush () error {
+    if err := t.out.Flush(); t.err == nil {t.err = err}
+    return t.err
+}
+
+
+// start recording an instruction
+func (t *Tracer) begin (cpu *Processor, interrupt bool, start uint64) {
+    inst := cpu.bus.GetByte(cpu.ptr)
+    size := uint(1)
+    if def := decodeOp(inst); def != nil {size = def.kind.Size(inst)}
+
+    t.rec.Interrupt = interrupt
+    t.rec.PC        = cpu.ptr & (memSize - 1)
+    t.rec.Bytes     = t.rec.Bytes[:0]
+    t.rec.Writes    = t.rec.Writes[:0]
+    for i := uint(0); i < size; i += 1 {
+        t.rec.Bytes = append(t.rec.Bytes, uint8(cpu.bus.GetByte(cpu.ptr + i)))
+    }
+    t.start = start
+}
+
+
+// record a byte written by the instruction
+func (t *Tracer) write (addr, value uint) {
+    t.rec.Writes = append(t.rec.Writes, TraceWrite{addr & (memSize - 1), uint8(value)})
+}
+
+
+// finish recording an instruction and write it
+func (t *Tracer) end (cpu *Processor) {
+    t.rec.Reg    = cpu.reg
+    t.rec.Flags  = cpu.flagsByte()
+    t.rec.SP     = uint(cpu.stack.ptr)
+    t.rec.Cycles = uint16(cpu.cycles - t.start)
+    t.count += 1
+    if t.err == nil {t.err = t.rec.encode(t.out)}
+}
+
+
+// write a record in its binary format
+func (rec *TraceRecord) encode (out *bufio.Writer) error {
+    var kind uint8
+    if rec.Interrupt {kind |= 0x1}
+    out.WriteByte(kind)
+    out.Write([]uint8{uint8(rec.PC >> 8), uint8(rec.PC), uint8(len(rec.Bytes))})
+    out.Write(rec.Bytes)
+    out.Write(rec.Reg[:])
+    out.Write([]uint8{rec.Flags, uint8(rec.SP >> 8), uint8(rec.SP), uint8(rec.Cycles >> 8), uint8(rec.Cycles)})
+    out.Write([]uint8{uint8(len(rec.Writes) >> 8), uint8(len(rec.Writes))})
+    for _, w := range rec.Writes {
+        _, err := out.Write([]uint8{uint8(w.Addr >> 8), uint8(w.Addr), w.Value})
+        if err != nil {return err}
+    }
+    return nil
+}
+
+
+// read records of a trace one after the other
+type TraceReader struct {
+    in *bufio.Reader
+}
+
+
+// check the header of a trace and prepare to read its records
+func NewTraceReader (in io.Reader) (*TraceReader, error) {
+    r := &TraceReader{bufio.NewReader(in)}
+    header := make([]uint8, len(traceMagic) + 1)
+    if _, err := io.ReadFull(r.in, header); err != nil || string(header[:4]) != traceMagic {
+        return nil, fmt.Errorf("Invalid trace: bad magic")
+    }
+    if header[4] != traceVersion {
+        return nil, fmt.Errorf("Invalid trace: unsupported version %d", header[4])
+    }
+    return r, nil
+}
+
+
+// read the next record, io.EOF once the trace is over
+func (r *TraceReader) Next () (*TraceRecord, error) {
+    var head [4]uint8
+    if _, err := io.ReadFull(r.in, head[:1]); err != nil {return nil, err}
+
+    rec := &TraceRecord{}
+    bad := func () (*TraceRecord, error) {
+        return nil, fmt.Errorf("Invalid trace: truncated record")
+    }
+    if _, err := io.ReadFull(r.in, head[1:4]); err != nil {return bad()}
+    rec.Interrupt = (head[0] & 0x1) != 0
+    rec.PC        = uint(head[1]) << 8 | uint(head[2])
+    rec.Bytes     = make([]uint8, head[3])
+    if _, err := io.ReadFull(r.in, rec.Bytes); err != nil {return bad()}
+
+    var state [11]uint8
+    if _, err := io.ReadFull(r.in, state[:]); err != nil {return bad()}
+    copy(rec.Reg[:], state[:4])
+    rec.Flags  = state[4]
+    rec.SP     = uint(state[5]) << 8 | uint(state[6])
+    rec.Cycles = uint16(state[7]) << 8 | uint16(state[8])
+
+    writes := make([]uint8, (int(state[9]) << 8 | int(state[10])) * 3)
+    if _, err := io.ReadFull(r.in, writes); err != nil {return bad()}
+    for i := 0; i < len(writes); i += 3 {
+        addr := uint(writes[i]) << 8 | uint(writes[i + 1])
+        rec.Writes = append(rec.Writes, TraceWrite{addr, writes[i + 2]})
+    }
+    return rec, nil
+}
+
+
+// format a record on a single line
+func (rec *TraceRecord) String () string {
+    bus := NewFlatBus()
+    bus.Load(rec.PC, rec.Bytes)
+    text := Disassemble(bus, rec.PC).String()
+
+    var sb strings.Builder
+    if rec.Interrupt {sb.WriteString("(interrupt) ")}
+    fmt.Fprintf(&sb, "%-28s A=%02X X=%02X Y=%02X Z=%02X F=%02X SP=%02X cycles=%d",
+        text, rec.Reg[0], rec.Reg[1], rec.Reg[2], rec.Reg[3], rec.Flags, rec.SP, rec.Cycles)
+    for _, w := range rec.Writes {
+        fmt.Fprintf(&sb, " [$%04X]=%02X", w.Addr, w.Value)
+    }
+    return sb.String()
+}
+
+
+// describe the first difference between two records, "" if they are equal
+func (rec *TraceRecord) Diff (other *TraceRecord) string {
+    switch {
+    case rec.Interrupt != other.Interrupt:
+        return "interrupt"
+    case rec.PC != other.PC:
+        return "pointer"
+    case string(rec.Bytes) != string(other.Bytes):
+        return "instruction"
+    case rec.Reg != other.Reg:
+        return "registers"
+    case rec.Flags != other.Flags:
+        return "flags"
+    case rec.SP != other.SP:
+        return "stack pointer"
+    case rec.Cycles != other.Cycles:
+        return "cycles"
+    case len(rec.Writes) != len(other.Writes):
+        return "memory writes"
+    }
+    for i := range rec.Writes {
+        if rec.Writes[i] != other.Writes[i] {return "memory writes"}
+    }
+    return ""
+}
+
+
+// result of the comparison of two traces
+type TraceDiff struct {
+    Index  uint64       // number of identical records before the divergence
+    A, B  *TraceRecord  // diverging records, nil if the trace is over
+    Field  string       // first field that differs
+}
+
+
+// compare two traces and return their first divergence, nil if identical
+func DiffTraces (a, b io.Reader) (*TraceDiff, error) {
+    ra, err := NewTraceReader(a)
+    if err != nil {return nil, err}
+    rb, err := NewTraceReader(b)
+    if err != nil {return nil, err}
+
+    for index := uint64(0); ; index += 1 {
+        recA, errA := ra.Next()
+        recB, errB := rb.Next()
+        if errA != nil && errA != io.EOF {return nil, errA}
+        if errB != nil && errB != io.EOF {return nil, errB}
+
+        switch {
+        case recA == nil && recB == nil:
+            return nil, nil
+        case recA == nil || recB == nil:
+            return &TraceDiff{index, recA, recB, "length"}, nil
+        }
+        if field := recA.Diff(recB); field != "" {
+            return &TraceDiff{index, recA, recB, field}, nil
+        }
+    }
+}
+
+
+// command line: vox-legacy trace [-frames n] [-pc addr] [-o output] image|game.vox
+func cmdTrace (args []string) error {
+    flags  := flag.NewFlagSet("trace", flag.ContinueOnError)
+    frames := flags.Int   ("frames", 60      , "number of frames to run")
+    entry  := flags.String("pc"    , "$0000" , "address of the first instruction of an image")
+    output := flags.String("o"     , "out.trace", "trace to write")
+    if err := flags.Parse(args); err != nil {return err}
+    if flags.NArg() != 1 {
+        return fmt.Errorf("usage: vox-legacy trace [-frames n] [-pc addr] [-o output] image|game.vox")
+    }
+
+    con := NewConsole()
+    if err := con.LoadFile(flags.Arg(0), *entry); err != nil {return err}
+
+    file, err := os.Create(*output)
+    if err != nil {return err}
+    defer file.Close()
+
+    tracer := NewTracer(file)
+    tracer.Attach(&con.cpu)
+    for i := 0; i < *frames; i += 1 {
+        if err := con.Frame(); err != nil {
+            tracer.Flush()
+            return err
+        }
+    }
+    if err := tracer.Flush(); err != nil {return err}
+    fmt.Printf("%d instructions traced\n", tracer.Count())
+    return nil
+}
+
+
+// command line: vox-legacy tracediff a.trace b.trace
+func cmdTraceDiff (args []string) error {
+    return traceDiff(args, os.Stdout)
+}
+
+
+// compare two trace files and print their first divergence
+func traceDiff (args []string, out io.Writer) error {
+    if len(args) != 2 {return fmt.Errorf("usage: vox-legacy tracediff a.trace b.trace")}
+
+    a, err := os.Open(args[0])
+    if err != nil {return err}
+    defer a.Close()
+    b, err := os.Open(args[1])
+    if err != nil {return err}
+    defer b.Close()
+
+    diff, err := DiffTraces(a, b)
+    if err != nil {return err}
+    if diff == nil {
+        fmt.Fprintln(out, "traces are identical")
+        return nil
+    }
+
+    fmt.Fprintf(out, "traces diverge at instruction %d (%s)\n", diff.Index, diff.Field)
+    for i, rec := range []*TraceRecord{diff.A, diff.B} {
+        if rec == nil {
+            fmt.Fprintf(out, "  %s: end of trace\n", args[i])
+        } else {
+            fmt.Fprintf(out, "  %s: %v\n", args[i], rec)
+        }
+    }
+    return nil
+}
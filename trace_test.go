@@ -0,0 +1,179 @@
+package main
+
+import (
+    "io"
+    "os"
+    "bytes"
+    "bufio"
+    "strings"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+)
+
+
+// program copying the page $0200 in the attribute table of the sprites
+const traceProgram = `
+        .org $4000
+        LOD A, #$02
+        STR A, $201D
+        LOD X, #$05
+        STR X, $0210
+end:    JMP end
+nmi:    RTI
+
+        .org $FFFA
+        .word nmi
+`
+
+
+// trace of the first instructions of a program, a NMI is requested before the fifth one
+func traceRun (t *testing.T, program string, count int) []uint8 {
+    image, err := Assemble("trace", program)
+    if err != nil {t.Fatal(err)}
+    con  := NewConsole()
+    cart := &Cartridge{Origin: addrROM, Entry: addrROM, Program: image[addrROM:], Tiles: make([]TileBank, 1)}
+    if err := con.Insert(cart); err != nil {t.Fatal(err)}
+    for i := uint(0); i < oamSize; i += 1 {con.ram.data[0x200 + i] = uint8(i * 3)}
+
+    var buf bytes.Buffer
+    tracer := NewTracer(&buf)
+    tracer.Attach(&con.cpu)
+    for i := 0; i < count; i += 1 {
+        if i == 4 {con.cpu.RequestNMI()}
+        con.cpu.Cycle()
+    }
+    if err := tracer.Flush(); err != nil {t.Fatal(err)}
+    if tracer.Count() != uint64(count) {t.Errorf("%d instructions recorded instead of %d", tracer.Count(), count)}
+    return buf.Bytes()
+}
+
+
+// read every record of a trace
+func readTrace (t *testing.T, data []uint8) []*TraceRecord {
+    r, err := NewTraceReader(bytes.NewReader(data))
+    if err != nil {t.Fatal(err)}
+    var records []*TraceRecord
+    for {
+        rec, err := r.Next()
+        if err == io.EOF {return records}
+        if err != nil {t.Fatal(err)}
+        records = append(records, rec)
+    }
+}
+
+
+// the records follow the processor, the copy of OAMDMA included
+func TestTraceRecords (t *testing.T) {
+    records := readTrace(t, traceRun(t, traceProgram, 5))
+    if len(records) != 5 {t.Fatalf("%d records instead of 5", len(records))}
+
+    lod, dma, nmi := records[0], records[1], records[4]
+    if lod.PC != addrROM || len(lod.Bytes) != 2 || lod.Reg[0] != 2 || len(lod.Writes) != 0 {t.Errorf("LOD: %v", lod)}
+
+    // the copy takes a cycle per byte and one more, the 513 writes are kept
+    if want := uint16(opCycles[dma.Bytes[0]]) + oamSize + 1; dma.Cycles != want {
+        t.Errorf("OAMDMA: %d cycles instead of %d", dma.Cycles, want)
+    }
+    if len(dma.Writes) != oamSize + 1 {t.Fatalf("OAMDMA: %d writes instead of %d", len(dma.Writes), oamSize + 1)}
+    if dma.Writes[0] != (TraceWrite{addrPPU + regOAMDMA, 2}) {t.Errorf("OAMDMA: first write %v", dma.Writes[0])}
+    for i := uint(0); i < oamSize; i += 1 {
+        if w := dma.Writes[1 + i]; w != (TraceWrite{addrOAM + i, uint8(i * 3)}) {
+            t.Errorf("OAMDMA: write %d is %v", i, w)
+            break
+        }
+    }
+
+    if records[3].Writes[0] != (TraceWrite{0x0210, 5}) {t.Errorf("STR: %v", records[3])}
+    if !nmi.Interrupt || nmi.PC != 0x400D || nmi.Cycles != interruptCycles + uint16(opCycles[nmi.Bytes[0]]) {
+        t.Errorf("NMI: %v", nmi)
+    }
+    if text := nmi.String(); !strings.HasPrefix(text, "(interrupt) 400D  A8        RTI") {t.Errorf("NMI printed as %q", text)}
+}
+
+
+// records are read back as written, wide ones included
+func TestTraceEncoding (t *testing.T) {
+    big := TraceRecord{Interrupt: true, PC: 0xFFFE, Bytes: []uint8{opJMP, 0x12, 0x34},
+        Reg: [4]uint8{1, 2, 3, 4}, Flags: 0xA5, SP: 0x100, Cycles: 0xFFFF}
+    for i := uint(0); i < 600; i += 1 {big.Writes = append(big.Writes, TraceWrite{0x2100 + i, uint8(i)})}
+    records := []TraceRecord{{PC: 0x4000, Bytes: []uint8{0x00}, Cycles: 1}, big, {PC: 0x1234, Cycles: 300}}
+
+    var buf bytes.Buffer
+    out := bufio.NewWriter(&buf)
+    out.WriteString(traceMagic)
+    out.WriteByte(traceVersion)
+    for i := range records {
+        if err := records[i].encode(out); err != nil {t.Fatal(err)}
+    }
+    out.Flush()
+    data := buf.Bytes()
+
+    back := readTrace(t, data)
+    if len(back) != len(records) {t.Fatalf("%d records instead of %d", len(back), len(records))}
+    for i := range records {
+        if field := back[i].Diff(&records[i]); field != "" {t.Errorf("record %d: %s differs", i, field)}
+    }
+
+    // a record cut anywhere is an error, not the end of the trace
+    last := len(data) - 12
+    for n := last + 1; n < len(data); n += 1 {
+        r, err := NewTraceReader(bytes.NewReader(data[:n]))
+        if err != nil {t.Fatal(err)}
+        r.Next(); r.Next()
+        if _, err := r.Next(); err == nil || err == io.EOF {t.Errorf("cut at %d: %v", n, err)}
+    }
+
+    invalid := map[string]string{
+        "empty"      : "",
+        "bad magic"  : "VOXS\x02",
+        "old version": "VOXT\x01",
+    }
+    for name, header := range invalid {
+        if _, err := NewTraceReader(strings.NewReader(header)); err == nil {t.Errorf("%s: read without error", name)}
+    }
+}
+
+
+// tracediff prints the first divergence of two trace files
+func TestTraceDiff (t *testing.T) {
+    dir, err := ioutil.TempDir("", "trace")
+    if err != nil {t.Fatal(err)}
+    defer os.RemoveAll(dir)
+
+    traces := map[string][]uint8{
+        "a"    : traceRun(t, traceProgram, 5),
+        "same" : traceRun(t, traceProgram, 5),
+        "short": traceRun(t, traceProgram, 3),
+        "other": traceRun(t, strings.Replace(traceProgram, "#$05", "#$06", 1), 5),
+    }
+    for name, data := range traces {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {t.Fatal(err)}
+    }
+
+    cases := []struct {
+        b     string
+        lines []string // lines printed, after the name of the file
+    }{
+        {"same" , []string{"traces are identical"}},
+        {"other", []string{"traces diverge at instruction 2 (instruction)",
+                           "4005  51 05     LOD X, #$05", "4005  51 06     LOD X, #$06"}},
+        {"short", []string{"traces diverge at instruction 3 (length)",
+                           "4007  41 02 10  STR X, $0210", "end of trace"}},
+    }
+    for _, c := range cases {
+        var out strings.Builder
+        a, b := filepath.Join(dir, "a"), filepath.Join(dir, c.b)
+        if err := traceDiff([]string{a, b}, &out); err != nil {t.Errorf("%s: %v", c.b, err); continue}
+        lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+        if len(lines) != len(c.lines) {t.Errorf("%s: printed %q", c.b, out.String()); continue}
+        for i, want := range c.lines {
+            if !strings.Contains(lines[i], want) {t.Errorf("%s: line %q does not contain %q", c.b, lines[i], want)}
+        }
+    }
+
+    if err := traceDiff([]string{filepath.Join(dir, "a")}, ioutil.Discard); err == nil {t.Error("one trace compared")}
+    if err := traceDiff([]string{filepath.Join(dir, "a"), filepath.Join(dir, "none")}, ioutil.Discard); err == nil {
+        t.Error("missing trace compared")
+    }
+}
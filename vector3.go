@@ -6,20 +6,20 @@ type Vector3 struct {
     x, y, z uint
 }
 
-func (v Vector3) Set (x, y, z uint) {
+func (v *Vector3) Set (x, y, z uint) {
     v.x = x
     v.y = y
     v.z = z
 }
 
-func (v Vector3) Set8 (x, y, z uint8) {
+func (v *Vector3) Set8 (x, y, z uint8) {
     v.x = uint(x)
     v.y = uint(y)
     v.z = uint(z)
 }
 
 // convert a single byte into three components
-func (v Vector3) SetByte (byte uint8) {
+func (v *Vector3) SetByte (byte uint8) {
     b := uint(byte)
     v.x = b >> 4 & 0x3
     v.y = b >> 2 & 0x3
@@ -98,20 +98,20 @@ type Byte3 struct {
     x, y, z uint8
 }
 
-func (v Byte3) Set (x, y, z uint) {
+func (v *Byte3) Set (x, y, z uint) {
     v.x = uint8(x)
     v.y = uint8(y)
     v.z = uint8(z)
 }
 
-func (v Byte3) Set8 (x, y, z uint8) {
+func (v *Byte3) Set8 (x, y, z uint8) {
     v.x = x
     v.y = y
     v.z = z
 }
 
 // convert a single byte into three components
-func (v Byte3) SetByte (byte uint8) {
+func (v *Byte3) SetByte (byte uint8) {
     v.x = byte >> 4 & 0x3
     v.y = byte >> 2 & 0x3
     v.z = byte      & 0x3
@@ -139,7 +139,7 @@ type Bool3 struct {
     x, y, z bool
 }
 
-func (v Bool3) SetByte (byte uint8) {
+func (v *Bool3) SetByte (byte uint8) {
     v.x = (byte & 0x4) != 0
     v.y = (byte & 0x2) != 0
     v.z = (byte & 0x1) != 0